@@ -1,7 +1,6 @@
 package main
 
 import (
-	"flag"
 	"os"
 	"testing"
 
@@ -10,7 +9,7 @@ import (
 )
 
 func TestRunMainMessageProducer(t *testing.T) {
-	resetEnvAndFlags()
+	resetEnv()
 	setupMock()
 	os.Args = []string{"noop", "-topic", testutil.Topic(200), "-record", "Horst Tester", "-header", "id=1", "-source", "open/source", "-ce"}
 	err := run()
@@ -21,7 +20,7 @@ func TestRunMainMessageProducer(t *testing.T) {
 
 // Test error handling (does not require server mock)
 func TestRunMainMessageProducerSimple(t *testing.T) {
-	resetEnvAndFlags()
+	resetEnv()
 	setupMock()
 	os.Args = []string{"noop", "-topic", testutil.Topic(200), "-record", "This is a simple string"}
 	err := run()
@@ -29,14 +28,14 @@ func TestRunMainMessageProducerSimple(t *testing.T) {
 }
 
 func TestHelp(t *testing.T) {
-	resetEnvAndFlags()
+	resetEnv()
 	os.Args = []string{"noop", "-help"}
 	err := run()
 	assert.NoError(t, err)
 }
 
 func TestEmptyRecord(t *testing.T) {
-	resetEnvAndFlags()
+	resetEnv()
 	os.Args = []string{"noop", "-topic", "hase", "-record", ""}
 	err := run()
 	assert.ErrorContains(t, err, "record must not be empty")
@@ -44,12 +43,8 @@ func TestEmptyRecord(t *testing.T) {
 
 // Helper
 
-func resetEnvAndFlags() {
+func resetEnv() {
 	os.Clearenv()
-	// How to unset flags Visited on command line in GoLang for Tests
-	// https://stackoverflow.com/a/57972717/4292075
-	// Otherwise you get "tmp/GoLand/___main_test_go.test flag redefined: topic"
-	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError) // flags are now reset
 }
 
 func setupMock() {
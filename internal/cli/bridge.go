@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/tillkuhn/rubin/pkg/polly"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+type bridgeFlags struct {
+	closeTimeout time.Duration
+	filters      arrayFlags
+	from         string
+	rate         rateFlags
+	timeout      time.Duration
+	to           string
+}
+
+func bridgeCommand() command {
+	return command{
+		name:        "bridge",
+		description: "Consume messages from a Kafka topic and produce them into another topic via REST Proxy",
+		options:     func() []interface{} { return []interface{}{&rubin.Options{}, &polly.Options{}} },
+		setup: func(_ *app, fs *flag.FlagSet) func(ctx context.Context) error {
+			var f bridgeFlags
+			fs.DurationVar(&f.closeTimeout, "close-timeout", 0, "Max time to wait for in-flight messages on shutdown (default KAFKA_CONSUMER_CLOSE_TIMEOUT or 10s)")
+			fs.Var(&f.filters, "filter", "Filter expression <field><op><value>, only matching messages are forwarded, can be used multiple times (see polly.Filter)")
+			fs.StringVar(&f.from, "from", "", "Kafka topic for message consumption")
+			fs.DurationVar(&f.timeout, "timeout", defaultConsumeTimeout, "Timeout duration to run the bridge, zero or negative value means no timeout")
+			f.rate.register(fs)
+			fs.StringVar(&f.to, "to", "", "Name of target Kafka Topic")
+			return func(ctx context.Context) error { return runBridge(ctx, f) }
+		},
+	}
+}
+
+func runBridge(ctx context.Context, f bridgeFlags) error {
+	if f.from == "" || f.to == "" {
+		return fmt.Errorf("%w: both -from and -to topic must be specified", errClient)
+	}
+	options, err := rubin.NewOptionsFromEnv()
+	if err != nil {
+		return err
+	}
+	f.rate.apply(options)
+	p, err := newPollyClient(f.closeTimeout)
+	if err != nil {
+		return err
+	}
+	return bridge(ctx, p, rubin.NewClient(options), f)
+}
+
+// bridge forwards messages until the timeout or SIGTERM, messages in flight are produced before it returns
+func bridge(ctx context.Context, p *polly.Client, producer *rubin.Client, f bridgeFlags) (err error) {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer func() {
+		err = errors.Join(err, p.WaitForClose(context.WithoutCancel(ctx)))
+		stop()
+	}()
+
+	handlerFunc := forwardTo(producer, f.to)
+	if len(f.filters) > 0 {
+		filter, err := polly.NewFilter(f.filters...)
+		if err != nil {
+			return err
+		}
+		handlerFunc = polly.FilterMessages(filter, handlerFunc)
+	}
+	log.Ctx(ctx).Info().Msgf("Bridging messages from %s to %s", f.from, f.to)
+	return pollUntilDone(ctx, p, kafka.ReaderConfig{Topic: f.from}, handlerFunc, f.timeout)
+}
+
+// forwardTo returns a handler that produces each consumed message into the target topic,
+// failures are logged since the consumer has no way to handle them
+func forwardTo(producer *rubin.Client, topic string) polly.HandleMessageFunc {
+	return func(ctx context.Context, message kafka.Message) {
+		if _, err := producer.Produce(ctx, bridgeRecord(message, topic)); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("Cannot forward message %d/%d to %s", message.Partition, message.Offset, topic)
+		}
+	}
+}
+
+// bridgeRecord converts a consumed message into a record request for the target topic, key and headers
+// are preserved. The value is passed as string, so JSON payloads (incl. CloudEvents) are forwarded as JSON
+func bridgeRecord(message kafka.Message, topic string) rubin.RecordRequest {
+	headers := make(map[string]string, len(message.Headers))
+	for _, h := range message.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	return rubin.RecordRequest{
+		Topic:   topic,
+		Key:     string(message.Key),
+		Data:    string(message.Value),
+		Headers: headers,
+	}
+}
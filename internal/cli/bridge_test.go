@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+	"github.com/tillkuhn/rubin/pkg/polly"
+	"github.com/tillkuhn/rubin/pkg/pollytest"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+func TestBridgeRecord(t *testing.T) {
+	rr := bridgeRecord(kafka.Message{
+		Key:     []byte("123"),
+		Value:   []byte(`{"id":42}`),
+		Headers: []kafka.Header{{Key: "content-type", Value: []byte("application/json")}},
+	}, "public.target")
+	assert.Equal(t, "public.target", rr.Topic)
+	assert.Equal(t, "123", rr.Key)
+	assert.Equal(t, `{"id":42}`, rr.Data)
+	assert.Equal(t, "application/json", rr.Headers["content-type"])
+}
+
+func TestBridgeRequiresTopics(t *testing.T) {
+	err := runBridge(context.Background(), bridgeFlags{from: "public.source"})
+	assert.ErrorIs(t, err, errClient)
+}
+
+func TestBridgeDrainsOnSigterm(t *testing.T) {
+	mock := testutil.ServerMock()
+	defer mock.Close()
+	received := make(chan struct{}, 1)
+	var completed atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		time.Sleep(100 * time.Millisecond) // slow REST Proxy, SIGTERM arrives while the record is in flight
+		if r.Context().Err() == nil {
+			completed.Add(1)
+		}
+		mock.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	producer := rubin.NewClient(&rubin.Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw"})
+	broker := pollytest.NewBroker()
+	assert.NoError(t, broker.Produce(kafka.Message{Topic: "public.source", Value: []byte("Hello")}))
+	p := polly.NewClient(&polly.Options{ConsumerGroupID: "bridge", ConsumerMaxReceive: -1, ConsumerCloseTimeout: time.Second},
+		polly.WithReaderFactory(broker.NewReader))
+
+	done := make(chan error)
+	go func() {
+		done <- bridge(context.Background(), p, producer, bridgeFlags{from: "public.source", to: testutil.Topic(200)})
+	}()
+	<-received
+	assert.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(1), completed.Load(), "in-flight record is produced after SIGTERM")
+}
@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/tillkuhn/rubin/pkg/polly"
+)
+
+const (
+	envPrefix = "POLLY_"
+	// defaultRetryExitCode is EX_TEMPFAIL from sysexits.h, "temporary failure, user is invited to retry"
+	defaultRetryExitCode = 75
+	defaultMaxRetries    = 3
+	defaultRetryDelay    = 1 * time.Second
+)
+
+var errHandlerCommand = errors.New("handler command error")
+
+// callbackOptions controls how the external handler command is invoked for each message
+type callbackOptions struct {
+	// command with optional shell-quoted arguments, e.g. `./handle.sh --name "hello world"`
+	command string
+	// timeout per invocation, zero means no timeout
+	timeout time.Duration
+	// retryExitCode signals a temporary failure, the invocation is retried up to maxRetries times
+	retryExitCode int
+	maxRetries    int
+	retryDelay    time.Duration
+	// deadLetter receives failed messages as JSON lines, if nil they are only logged and skipped
+	deadLetter io.Writer
+}
+
+// deadLetterRecord is written to callbackOptions.deadLetter for messages the handler failed to process
+type deadLetterRecord struct {
+	polly.MessageRecord
+	Command  string `json:"command"`
+	ExitCode int    `json:"exit_code"`
+	Reason   string `json:"reason"`
+}
+
+// PassToCallbackHandler wraps the command in opts and returns a function that can be used as polly.HandleMessageFunc
+//
+// Contract for the external command:
+//   - the message value is passed via STDIN
+//   - metadata is passed as environment variables POLLY_TOPIC, POLLY_PARTITION, POLLY_OFFSET, POLLY_KEY,
+//     POLLY_TIMESTAMP, POLLY_HEADER_<NAME> and POLLY_CE_ID, POLLY_CE_TYPE, POLLY_CE_SOURCE, POLLY_CE_SUBJECT for CloudEvents
+//   - exit code 0 means success, retryExitCode (or a timeout) means retry, all other codes mean failure (dead-letter / skip)
+func PassToCallbackHandler(opts callbackOptions) (polly.HandleMessageFunc, error) {
+	args, err := splitCommand(opts.command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: command is empty", errHandlerCommand)
+	}
+	log.Info().Msgf("Registering callback for externalCommand: %s", opts.command)
+	return func(ctx context.Context, message kafka.Message) {
+		var exitCode int
+		var err error
+		for attempt := 0; attempt <= opts.maxRetries; attempt++ {
+			if attempt > 0 {
+				delay := opts.retryDelay * time.Duration(attempt) // linear backoff
+				log.Warn().Msgf("Retrying handler command in %v (attempt %d/%d): %v", delay, attempt, opts.maxRetries, err)
+				select {
+				case <-ctx.Done():
+					log.Warn().Msgf("Context done, give up retrying handler command: %v", ctx.Err())
+					return
+				case <-time.After(delay):
+				}
+			}
+			var retry bool
+			exitCode, retry, err = invokeHandler(ctx, args, message, opts)
+			if err == nil || !retry {
+				break
+			}
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to execute handler command: %s exitCode=%d", opts.command, exitCode)
+			writeDeadLetter(opts, message, exitCode, err)
+		}
+	}, nil
+}
+
+// invokeHandler runs the command once and returns the exit code and whether a failure is worth a retry
+func invokeHandler(ctx context.Context, args []string, message kafka.Message, opts callbackOptions) (int, bool, error) {
+	cmdCtx := ctx
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(cmdCtx, args[0], args[1:]...) // #nosec G204
+	cmd.Stdin = bytes.NewReader(message.Value)
+	cmd.Env = append(os.Environ(), handlerEnv(message)...)
+
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		log.Info().Msgf("Handler command executed successfully: %s, output: %s", opts.command, string(output))
+		return 0, false, nil
+	}
+	if errors.Is(cmdCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return -1, true, fmt.Errorf("%w: timeout after %v, output: %s", errHandlerCommand, opts.timeout, string(output))
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return -1, false, fmt.Errorf("%w: %s", errHandlerCommand, err.Error())
+	}
+	exitCode := exitErr.ExitCode()
+	retry := opts.retryExitCode != 0 && exitCode == opts.retryExitCode
+	return exitCode, retry, fmt.Errorf("%w: %s, output: %s", errHandlerCommand, err.Error(), string(output))
+}
+
+// handlerEnv returns the message metadata as list of POLLY_* environment variables
+func handlerEnv(message kafka.Message) []string {
+	env := []string{
+		envPrefix + "TOPIC=" + message.Topic,
+		envPrefix + "PARTITION=" + strconv.Itoa(message.Partition),
+		envPrefix + "OFFSET=" + strconv.FormatInt(message.Offset, 10),
+		envPrefix + "KEY=" + string(message.Key),
+		envPrefix + "TIMESTAMP=" + message.Time.Format(time.RFC3339Nano),
+	}
+	for _, h := range message.Headers {
+		env = append(env, envPrefix+"HEADER_"+envName(h.Key)+"="+string(h.Value))
+	}
+	if ce, err := polly.AsCloudEvent(message); err == nil {
+		env = append(env,
+			envPrefix+"CE_ID="+ce.ID(),
+			envPrefix+"CE_TYPE="+ce.Type(),
+			envPrefix+"CE_SOURCE="+ce.Source(),
+			envPrefix+"CE_SUBJECT="+ce.Subject(),
+		)
+	}
+	return env
+}
+
+// envName converts a header name into a valid environment variable name, e.g. content-type => CONTENT_TYPE
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+func writeDeadLetter(opts callbackOptions, message kafka.Message, exitCode int, reason error) {
+	if opts.deadLetter == nil {
+		log.Warn().Msgf("Skip message %s %d/%d after handler failure (no dead-letter configured)", message.Topic, message.Partition, message.Offset)
+		return
+	}
+	rec := deadLetterRecord{MessageRecord: polly.NewMessageRecord(message), Command: opts.command, ExitCode: exitCode, Reason: reason.Error()}
+	if err := json.NewEncoder(opts.deadLetter).Encode(rec); err != nil {
+		log.Error().Err(err).Msgf("Cannot write dead-letter for message %s %d/%d", message.Topic, message.Partition, message.Offset)
+	}
+}
+
+// splitCommand splits a command line into arguments similar to a POSIX shell, supporting
+// single quotes (literal), double quotes (with backslash escapes) and backslash escapes outside of quotes
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range command {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("%w: unterminated quote or escape in %q", errHandlerCommand, command)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPassToCallbackHandler(t *testing.T) {
+	// Setup logger to capture output
+	var logBuf bytes.Buffer
+	log.Logger = zerolog.New(&logBuf)
+
+	handler, err := PassToCallbackHandler(callbackOptions{command: "cat"})
+	assert.NoError(t, err)
+	msg := kafka.Message{Value: []byte("test payload")}
+
+	handler(context.Background(), msg)
+
+	logOutput := logBuf.String()
+	if !bytes.Contains([]byte(logOutput), []byte("Handler command executed successfully")) {
+		t.Errorf("Expected successful handler execution, got log: %s", logOutput)
+	}
+}
+
+func TestCallbackHandlerEnv(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.Logger = zerolog.New(&logBuf)
+
+	handler, err := PassToCallbackHandler(callbackOptions{
+		command: `sh -c 'echo "topic=$POLLY_TOPIC key=$POLLY_KEY ct=$POLLY_HEADER_CONTENT_TYPE type=$POLLY_CE_TYPE"'`,
+	})
+	assert.NoError(t, err)
+	ce := cloudevents.NewEvent()
+	ce.SetID("1")
+	ce.SetSource("//test")
+	ce.SetType("app.started")
+	value, _ := json.Marshal(ce)
+	handler(context.Background(), kafka.Message{
+		Topic:   "public.hello",
+		Key:     []byte("key-1"),
+		Value:   value,
+		Headers: []kafka.Header{{Key: "content-type", Value: []byte(cloudevents.ApplicationCloudEventsJSON)}},
+	})
+	assert.Contains(t, logBuf.String(), "topic=public.hello key=key-1 ct=application/cloudevents+json type=app.started")
+}
+
+func TestCallbackHandlerExitCodes(t *testing.T) {
+	log.Logger = zerolog.Nop()
+	var deadLetter bytes.Buffer
+	msg := kafka.Message{Topic: "t", Key: []byte("k"), Value: []byte("v")}
+	opts := callbackOptions{retryExitCode: 75, maxRetries: 2, retryDelay: time.Millisecond, deadLetter: &deadLetter}
+
+	// retry code: 1 invocation + 2 retries, then dead-letter
+	countFile := t.TempDir() + "/count"
+	opts.command = "sh -c 'echo x >> " + countFile + "; exit 75'"
+	handler, err := PassToCallbackHandler(opts)
+	assert.NoError(t, err)
+	handler(context.Background(), msg)
+	count, _ := readLines(countFile)
+	assert.Equal(t, 3, count)
+
+	// other exit code: no retry, dead-letter immediately
+	countFile2 := t.TempDir() + "/count"
+	opts.command = "sh -c 'echo x >> " + countFile2 + "; exit 3'"
+	handler, err = PassToCallbackHandler(opts)
+	assert.NoError(t, err)
+	handler(context.Background(), msg)
+	count, _ = readLines(countFile2)
+	assert.Equal(t, 1, count)
+
+	lines := bytes.Split(bytes.TrimSpace(deadLetter.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+	var rec deadLetterRecord
+	assert.NoError(t, json.Unmarshal(lines[1], &rec))
+	assert.Equal(t, 3, rec.ExitCode)
+	assert.Equal(t, "k", rec.Key)
+	assert.Equal(t, "v", rec.Value)
+
+	// timeout is retried and finally dead-lettered
+	opts.command = "sleep 5"
+	opts.timeout = 10 * time.Millisecond
+	opts.maxRetries = 0
+	handler, err = PassToCallbackHandler(opts)
+	assert.NoError(t, err)
+	handler(context.Background(), msg)
+	assert.Contains(t, deadLetter.String(), "timeout after 10ms")
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"cat", []string{"cat"}},
+		{"  ./run.sh  -v   debug ", []string{"./run.sh", "-v", "debug"}},
+		{`./run.sh --name "hello world" 'single $quoted' esc\ aped`, []string{"./run.sh", "--name", "hello world", "single $quoted", "esc aped"}},
+		{`echo "say \"hi\"" ''`, []string{"echo", `say "hi"`, ""}},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.command)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.command)
+	}
+	_, err := splitCommand(`echo "unterminated`)
+	assert.ErrorContains(t, err, "unterminated")
+	_, err = PassToCallbackHandler(callbackOptions{command: "  "})
+	assert.ErrorContains(t, err, "command is empty")
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "CONTENT_TYPE", envName("content-type"))
+	assert.Equal(t, "X_TRACE_ID_1", envName("x.trace id 1"))
+}
+
+func readLines(file string) (int, error) {
+	b, err := os.ReadFile(file)
+	return bytes.Count(b, []byte("\n")), err
+}
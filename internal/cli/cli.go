@@ -0,0 +1,216 @@
+// Package cli implements the rubin command line interface with subcommands for producing, consuming and
+// administration, it's shared by the rubin executable and the polly alias (which defaults to consume)
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/tillkuhn/rubin/internal/logging"
+	"github.com/tillkuhn/rubin/internal/usage"
+)
+
+const (
+	envconfigPrefix = "kafka"
+	appName         = "rubin"
+	// defaultCommand is used if the first argument is a flag that is not a global flag, for backwards compatibility
+	// with the original rubin executable that only supported producing (e.g. rubin -topic hello -record hi)
+	defaultCommand = "produce"
+)
+
+var (
+	errUnknownCommand   = errors.New("unknown command")
+	errInvalidArgs      = errors.New("invalid arguments")
+	errUnsupportedShell = errors.New("unsupported shell")
+	errClient           = errors.New("client error") // used to wrap fine-grained errors
+)
+
+// BuildInfo is passed from main packages, where version information is injected with ldflags during build
+type BuildInfo struct {
+	Version string
+	Date    string
+	Commit  string
+	BuiltBy string
+}
+
+// String returns the one-line version info
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("%s %s built %s by %s (%s)", appName, b.Version, b.Date, b.BuiltBy, b.Commit)
+}
+
+// globalFlags are supported by all commands, either before or after the command name
+type globalFlags struct {
+	envFile   string
+	help      bool
+	verbosity string
+}
+
+// command is a subcommand of the CLI. setup registers the command specific flags on the flag set
+// and returns the function to run the command, which is invoked after the flags have been parsed.
+// This allows us to introspect flags without running the command, e.g. for help and completion
+type command struct {
+	name        string
+	description string
+	// options returns the envconfig specs shown in the command's help, nil if the command is not configured via env
+	options func() []interface{}
+	// args are the values offered by shell completion for positional arguments, e.g. supported shells
+	args  []string
+	setup func(app *app, fs *flag.FlagSet) func(ctx context.Context) error
+}
+
+type app struct {
+	info     BuildInfo
+	global   globalFlags
+	out      io.Writer
+	commands map[string]command
+}
+
+// Run parses the global flags, selects the command by the first positional argument and runs it
+//
+//	rubin [global flags] <command> [command flags]
+func Run(ctx context.Context, info BuildInfo, args []string) error {
+	a := newApp(info, os.Stdout)
+	return a.run(ctx, args)
+}
+
+func newApp(info BuildInfo, out io.Writer) *app {
+	a := &app{info: info, out: out, global: globalFlags{verbosity: "info"}, commands: map[string]command{}}
+	for _, cmd := range []command{
+		produceCommand(), consumeCommand(), bridgeCommand(), topicsCommand(), clustersCommand(), groupsCommand(),
+		configCommand(), doctorCommand(), flushSpoolCommand(), validateCommand(), versionCommand(), completionCommand(),
+	} {
+		a.commands[cmd.name] = cmd
+	}
+	return a
+}
+
+func (a *app) run(ctx context.Context, args []string) error {
+	log.Logger = log.With().Str("app", appName).Logger().Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	ctx = log.Logger.WithContext(ctx)
+
+	fs := a.newFlagSet(appName)
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			a.showHelp(fs)
+			return nil
+		}
+		// first flag is not a global flag, so we assume legacy rubin invocation without command
+		return a.runCommand(ctx, defaultCommand, args)
+	}
+	if fs.NArg() == 0 {
+		a.showHelp(fs)
+		return nil
+	}
+	return a.runCommand(ctx, fs.Arg(0), fs.Args()[1:])
+}
+
+func (a *app) runCommand(ctx context.Context, name string, args []string) error {
+	cmd, ok := a.commands[name]
+	if !ok {
+		return fmt.Errorf("%w: %s, run '%s -help' for a list of commands", errUnknownCommand, name, appName)
+	}
+	fs := a.newFlagSet(appName + " " + cmd.name)
+	runFunc := cmd.setup(a, fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			a.showCommandHelp(cmd, fs)
+			return nil
+		}
+		return err
+	}
+	if a.global.help {
+		a.showCommandHelp(cmd, fs)
+		return nil
+	}
+	log.Debug().Str("logger", "main").Msgf("Switching to LogLevel=%s", logging.ApplyLogLevel(a.global.verbosity))
+	if err := initEnv(ctx, a.global.envFile); err != nil {
+		return err
+	}
+	return runFunc(ctx)
+}
+
+// newFlagSet returns a flag set with the global flags registered, their current values are used as defaults,
+// so global flags specified before the command are retained if they're not repeated after the command
+func (a *app) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&a.global.envFile, "env-file", a.global.envFile, "location of environment variable file e.g. /tmp/.env")
+	fs.BoolVar(&a.global.help, "help", a.global.help, "Display this help")
+	fs.StringVar(&a.global.verbosity, "v", a.global.verbosity, "verbosity level, one of 'debug', 'info', 'warn', 'error'")
+	return fs
+}
+
+// sortedCommands returns the commands sorted by name, e.g. for help and completion
+func (a *app) sortedCommands() []command {
+	cmds := make([]command, 0, len(a.commands))
+	for _, cmd := range a.commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].name < cmds[j].name })
+	return cmds
+}
+
+func (a *app) showHelp(fs *flag.FlagSet) {
+	_, _ = fmt.Fprintf(a.out, "Usage: %s [global flags] <command> [command flags]\n\nAvailable commands:\n", appName)
+	for _, cmd := range a.sortedCommands() {
+		_, _ = fmt.Fprintf(a.out, "  %-12s %s\n", cmd.name, cmd.description)
+	}
+	_, _ = fmt.Fprintf(a.out, "\nRun '%s <command> -help' for command specific flags and environment configuration.\n\n", appName)
+	usage.ShowHelp(envconfigPrefix, fs)
+}
+
+func (a *app) showCommandHelp(cmd command, fs *flag.FlagSet) {
+	_, _ = fmt.Fprintf(a.out, "Usage: %s %s [flags]\n\n%s\n\n", appName, cmd.name, cmd.description)
+	var options []interface{}
+	if cmd.options != nil {
+		options = cmd.options()
+	}
+	usage.ShowHelp(envconfigPrefix, fs, options...)
+}
+
+// parseInterspersed continues parsing the remaining args of a parsed flag set, so positional arguments can be
+// mixed with flags (e.g. topics describe public.hello -v debug), and returns the positional arguments
+func parseInterspersed(fs *flag.FlagSet) ([]string, error) {
+	var positional []string
+	for fs.NArg() > 0 {
+		positional = append(positional, fs.Arg(0))
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return nil, err
+		}
+	}
+	return positional, nil
+}
+
+func initEnv(ctx context.Context, envFile string) error {
+	if envFile != "" {
+		log.Ctx(ctx).Info().Msgf("Loading environment from custom location %s", envFile)
+		if err := godotenv.Load(envFile); err != nil {
+			return fmt.Errorf("error loading environment vars from %s: %w", envFile, err)
+		}
+	}
+	return nil
+}
+
+// arrayFlags based on https://stackoverflow.com/a/28323276/4292075
+// How to get a list of values into a flag in Golang?
+//
+//	go run your_file.go --list1 value1 --list1 value2
+type arrayFlags []string
+
+func (af *arrayFlags) String() string {
+	return strings.Join(*af, ",")
+}
+
+func (af *arrayFlags) Set(value string) error {
+	*af = append(*af, value)
+	return nil
+}
@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+func TestHelpAndUnknownCommand(t *testing.T) {
+	var out bytes.Buffer
+	a := newApp(BuildInfo{}, &out)
+	assert.NoError(t, a.run(context.Background(), nil))
+	assert.Contains(t, out.String(), "Available commands")
+	assert.Contains(t, out.String(), "bridge")
+
+	assert.NoError(t, newApp(BuildInfo{}, &out).run(context.Background(), []string{"-help"}))
+	assert.NoError(t, newApp(BuildInfo{}, &out).run(context.Background(), []string{"-help", "consume"}))
+	assert.NoError(t, newApp(BuildInfo{}, &out).run(context.Background(), []string{"bridge", "-help"}))
+	assert.Contains(t, out.String(), "Usage: rubin bridge [flags]")
+
+	err := newApp(BuildInfo{}, &out).run(context.Background(), []string{"-v", "debug", "nope"})
+	assert.ErrorIs(t, err, errUnknownCommand)
+}
+
+func TestLegacyProduceWithoutCommand(t *testing.T) {
+	setupMock(t)
+	err := newApp(BuildInfo{}, &bytes.Buffer{}).run(context.Background(), []string{"-topic", testutil.Topic(200), "-record", "Hello", "-header", "id=1",
+		"-partition", "1", "-timestamp", "2024-03-01T12:00:00.123Z"})
+	assert.NoError(t, err)
+	// global flags before the command are retained
+	err = newApp(BuildInfo{}, &bytes.Buffer{}).run(context.Background(), []string{"-v", "warn", "produce", "-topic", testutil.Topic(200), "-record", ""})
+	assert.ErrorContains(t, err, "record must not be empty")
+}
+
+func TestVersionConfigAndTopics(t *testing.T) {
+	setupMock(t)
+	var out bytes.Buffer
+	a := newApp(BuildInfo{Version: "v1.2.3", Commit: "abc"}, &out)
+	assert.NoError(t, a.run(context.Background(), []string{"version"}))
+	assert.Contains(t, out.String(), "rubin v1.2.3")
+
+	out.Reset()
+	assert.NoError(t, a.run(context.Background(), []string{"config"}))
+	assert.Contains(t, out.String(), "/kafka/v3/clusters/"+testutil.ClusterID)
+	assert.NotContains(t, out.String(), "friedrich") // secrets must not be printed
+
+	out.Reset()
+	assert.NoError(t, a.run(context.Background(), []string{"topics"}))
+	assert.Contains(t, out.String(), "public.welcome")
+}
+
+func TestHeaderMap(t *testing.T) {
+	hm := headerMap([]string{"id=1", "invalid", "query=a=b"})
+	assert.Equal(t, map[string]string{"id": "1", "query": "a=b"}, hm)
+}
+
+func setupMock(t *testing.T) {
+	mock := testutil.ServerMock()
+	t.Cleanup(mock.Close)
+	t.Setenv("KAFKA_REST_ENDPOINT", mock.URL)
+	t.Setenv("KAFKA_CLUSTER_ID", testutil.ClusterID)
+	t.Setenv("KAFKA_PRODUCER_API_KEY", "hase")
+	t.Setenv("KAFKA_PRODUCER_API_SECRET", "friedrich")
+}
+
+func TestParseTimestamp(t *testing.T) {
+	ts, err := parseTimestamp("")
+	assert.NoError(t, err)
+	assert.True(t, ts.IsZero())
+	ts, err = parseTimestamp("1700000000123")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1700000000123), ts.UnixMilli())
+	ts, err = parseTimestamp("2024-03-01T12:00:00.123Z")
+	assert.NoError(t, err)
+	assert.Equal(t, 123, ts.Nanosecond()/1e6)
+	_, err = parseTimestamp("yesterday")
+	assert.ErrorIs(t, err, errInvalidArgs)
+}
+
+func TestProduceOutput(t *testing.T) {
+	setupMock(t)
+	var out bytes.Buffer
+	args := []string{"produce", "-topic", testutil.Topic(200), "-record", "Hello"}
+	assert.NoError(t, newApp(BuildInfo{}, &out).run(context.Background(), append(args, "-output", "json")))
+	var result produceOutput
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	assert.Equal(t, int64(42), result.Offset)
+	assert.Equal(t, "public.welcome", result.Topic)
+	assert.Equal(t, int64(31), result.ValueSize)
+	assert.False(t, result.Timestamp.IsZero())
+	assert.Empty(t, result.CloudEventID)
+
+	out.Reset()
+	assert.NoError(t, newApp(BuildInfo{}, &out).run(context.Background(), append(args, "-ce")))
+	assert.Contains(t, out.String(), "offset=42")
+	assert.Contains(t, out.String(), "cloudevent_id=")
+
+	out.Reset()
+	assert.NoError(t, newApp(BuildInfo{}, &out).run(context.Background(), append(args, "-output", "quiet")))
+	assert.Empty(t, out.String())
+	assert.ErrorIs(t, newApp(BuildInfo{}, &out).run(context.Background(), append(args, "-output", "yaml")), errInvalidArgs)
+}
+
+func TestProduceDryRun(t *testing.T) {
+	t.Setenv("KAFKA_REST_ENDPOINT", "http://127.0.0.1:1") // unreachable, the request must not be sent
+	t.Setenv("KAFKA_CLUSTER_ID", testutil.ClusterID)
+	var out bytes.Buffer
+	args := []string{"produce", "-topic", "public.hello", "-record", `{"msg":"hello"}`, "-dry-run"}
+	assert.NoError(t, newApp(BuildInfo{}, &out).run(context.Background(), args))
+	var request rubin.DryRunRequest
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &request), "only the request is printed")
+	assert.Contains(t, request.URL, "/topics/public.hello/records")
+
+	out.Reset()
+	assert.NoError(t, newApp(BuildInfo{}, &out).run(context.Background(), append(args, "-dry-run-format", "curl")))
+	assert.True(t, strings.HasPrefix(out.String(), "curl -X POST"))
+
+	out.Reset()
+	assert.NoError(t, newApp(BuildInfo{}, &out).run(context.Background(), append(args, "-output", "quiet")))
+	assert.Empty(t, out.String(), "quiet suppresses the rendered request")
+	assert.ErrorIs(t, newApp(BuildInfo{}, &out).run(context.Background(), append(args, "-output", "json")), errInvalidArgs)
+}
@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+func completionCommand() command {
+	return command{
+		name:        "completion",
+		description: "Print shell completion script, usage: completion bash|zsh|fish e.g. source <(rubin completion bash)",
+		args:        []string{"bash", "zsh", "fish"},
+		setup: func(a *app, fs *flag.FlagSet) func(ctx context.Context) error {
+			return func(_ context.Context) error { return a.writeCompletion(a.out, fs.Arg(0)) }
+		},
+	}
+}
+
+// completionFlag describes a flag of a command, value is false for bool flags which don't expect an argument
+type completionFlag struct {
+	name  string
+	usage string
+	value bool
+}
+
+// commandFlags introspects the flags of a command (including global flags) by running its setup on a fresh flag set
+func (a *app) commandFlags(cmd command) []completionFlag {
+	var flags []completionFlag
+	fs := a.newFlagSet(cmd.name)
+	cmd.setup(a, fs)
+	fs.VisitAll(func(f *flag.Flag) {
+		bf, isBool := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{name: f.Name, usage: f.Usage, value: !isBool || !bf.IsBoolFlag()})
+	})
+	return flags
+}
+
+func (a *app) writeCompletion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		return a.writeBashCompletion(w)
+	case "zsh":
+		return a.writeZshCompletion(w)
+	case "fish":
+		return a.writeFishCompletion(w)
+	default:
+		return fmt.Errorf("%w: '%s', supported shells are bash, zsh and fish", errUnsupportedShell, shell)
+	}
+}
+
+func (a *app) writeBashCompletion(w io.Writer) error {
+	var sb strings.Builder
+	names := make([]string, 0, len(a.commands))
+	sb.WriteString("# bash completion for " + appName + ", usage: source <(" + appName + " completion bash)\n")
+	sb.WriteString("_" + appName + "() {\n")
+	sb.WriteString("  local cur prev cmd i\n  cur=\"${COMP_WORDS[COMP_CWORD]}\"\n  prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	sb.WriteString("  for ((i=1; i < COMP_CWORD; i++)); do\n    [[ ${COMP_WORDS[i]} != -* ]] && { cmd=${COMP_WORDS[i]}; break; }\n  done\n")
+	sb.WriteString("  case \"$cmd\" in\n")
+	for _, cmd := range a.sortedCommands() {
+		names = append(names, cmd.name)
+		flags := slices.Clone(cmd.args)
+		var valueFlags []string
+		for _, f := range a.commandFlags(cmd) {
+			flags = append(flags, "-"+f.name)
+			if f.value {
+				valueFlags = append(valueFlags, "-"+f.name)
+			}
+		}
+		_, _ = fmt.Fprintf(&sb, "    %s)\n", cmd.name)
+		if len(valueFlags) > 0 {
+			// complete file names for flags that expect a value, e.g. -env-file
+			_, _ = fmt.Fprintf(&sb, "      case \"$prev\" in %s) COMPREPLY=($(compgen -f -- \"$cur\")); return;; esac\n", strings.Join(valueFlags, "|"))
+		}
+		_, _ = fmt.Fprintf(&sb, "      COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"));;\n", strings.Join(flags, " "))
+	}
+	_, _ = fmt.Fprintf(&sb, "    *)\n      COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"));;\n", strings.Join(names, " "))
+	sb.WriteString("  esac\n}\n")
+	sb.WriteString("complete -o default -F _" + appName + " " + appName + "\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (a *app) writeZshCompletion(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("#compdef " + appName + "\n# zsh completion for " + appName + ", usage: source <(" + appName + " completion zsh)\n")
+	sb.WriteString("_" + appName + "() {\n  local -a commands\n  commands=(\n")
+	for _, cmd := range a.sortedCommands() {
+		_, _ = fmt.Fprintf(&sb, "    '%s:%s'\n", cmd.name, zshEscape(cmd.description))
+	}
+	sb.WriteString("  )\n  if (( CURRENT == 2 )); then\n    _describe 'command' commands\n    return\n  fi\n")
+	sb.WriteString("  case \"${words[2]}\" in\n")
+	for _, cmd := range a.sortedCommands() {
+		_, _ = fmt.Fprintf(&sb, "    %s)\n      _arguments", cmd.name)
+		for _, f := range a.commandFlags(cmd) {
+			spec := fmt.Sprintf("-%s[%s]", f.name, zshEscape(f.usage))
+			if f.value {
+				spec += ":value:_files"
+			}
+			_, _ = fmt.Fprintf(&sb, " \\\n        '%s'", spec)
+		}
+		if len(cmd.args) > 0 {
+			_, _ = fmt.Fprintf(&sb, " \\\n        ':arg:(%s)'", strings.Join(cmd.args, " "))
+		}
+		sb.WriteString(";;\n")
+	}
+	sb.WriteString("  esac\n}\n")
+	sb.WriteString("compdef _" + appName + " " + appName + "\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (a *app) writeFishCompletion(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("# fish completion for " + appName + ", usage: " + appName + " completion fish | source\n")
+	_, _ = fmt.Fprintf(&sb, "complete -c %s -f\n", appName)
+	for _, cmd := range a.sortedCommands() {
+		_, _ = fmt.Fprintf(&sb, "complete -c %s -n '__fish_use_subcommand' -a %s -d '%s'\n", appName, cmd.name, fishEscape(cmd.description))
+	}
+	for _, cmd := range a.sortedCommands() {
+		if len(cmd.args) > 0 {
+			_, _ = fmt.Fprintf(&sb, "complete -c %s -n '__fish_seen_subcommand_from %s' -a '%s'\n", appName, cmd.name, strings.Join(cmd.args, " "))
+		}
+		for _, f := range a.commandFlags(cmd) {
+			requires := ""
+			if f.value {
+				requires = " -r"
+			}
+			// -o is for old style options with a single dash, which is what the flag package uses
+			_, _ = fmt.Fprintf(&sb, "complete -c %s -n '__fish_seen_subcommand_from %s' -o %s%s -d '%s'\n",
+				appName, cmd.name, f.name, requires, fishEscape(f.usage))
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// zshEscape escapes characters with special meaning in _arguments specs, single quotes are replaced
+func zshEscape(s string) string {
+	return strings.NewReplacer("'", "", "[", "\\[", "]", "\\]", ":", "\\:").Replace(s)
+}
+
+func fishEscape(s string) string {
+	return strings.ReplaceAll(s, "'", "\\'")
+}
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletion(t *testing.T) {
+	for shell, expected := range map[string][]string{
+		"bash": {"complete -o default -F _rubin rubin", "-topic", "produce)"},
+		"zsh":  {"#compdef rubin", "'-ce[expect CloudEvents format for event payload, only applies to -output text]'", "'-from[Kafka topic for message consumption]:value:_files'"},
+		"fish": {"__fish_seen_subcommand_from bridge' -o to -r", "__fish_seen_subcommand_from consume' -o ce -d"},
+	} {
+		var out bytes.Buffer
+		assert.NoError(t, newApp(BuildInfo{}, &out).run(context.Background(), []string{"completion", shell}))
+		for _, e := range expected {
+			assert.Contains(t, out.String(), e, shell)
+		}
+	}
+	err := newApp(BuildInfo{}, &bytes.Buffer{}).run(context.Background(), []string{"completion", "powershell"})
+	assert.ErrorIs(t, err, errUnsupportedShell)
+}
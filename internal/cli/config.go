@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/tillkuhn/rubin/pkg/polly"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+func configCommand() command {
+	return command{
+		name:        "config",
+		description: "Print the configuration resolved from environment (secrets are redacted)",
+		options:     func() []interface{} { return []interface{}{&rubin.Options{}, &polly.Options{}} },
+		setup: func(a *app, _ *flag.FlagSet) func(ctx context.Context) error {
+			return func(_ context.Context) error { return a.printConfig() }
+		},
+	}
+}
+
+func versionCommand() command {
+	return command{
+		name:        "version",
+		description: "Print version and build information",
+		setup: func(a *app, _ *flag.FlagSet) func(ctx context.Context) error {
+			return func(_ context.Context) error {
+				_, err := fmt.Fprintln(a.out, a.info.String())
+				return err
+			}
+		},
+	}
+}
+
+func (a *app) printConfig() error {
+	rOptions, err := rubin.NewOptionsFromEnvWithPrefix(envconfigPrefix)
+	if err != nil {
+		return err
+	}
+	pOptions, err := polly.NewOptionsFromEnvWithPrefix(envconfigPrefix)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(a.out, "produce (REST Proxy): %s\n", rOptions)
+	_, _ = fmt.Fprintf(a.out, "  cluster endpoint:   %s\n", rOptions.ClusterEndpoint())
+	_, _ = fmt.Fprintf(a.out, "  tls:                %s\n", rOptions.TLS)
+	_, _ = fmt.Fprintf(a.out, "consume (Kafka):      %s\n", pOptions)
+	_, err = fmt.Fprintf(a.out, "  tls:                %s\n", pOptions.TLS)
+	return err
+}
@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/tillkuhn/rubin/pkg/polly"
+)
+
+// defaultConsumeTimeout for the consumer to run before it's shut down, can be disabled with -timeout 0
+const defaultConsumeTimeout = 30 * time.Second
+
+type consumeFlags struct {
+	ce             bool
+	closeTimeout   time.Duration
+	concurrency    int
+	deadLetterFile string
+	filters        arrayFlags
+	handler        string
+	handlerMode    string
+	handlerRetries int
+	handlerTimeout time.Duration
+	orderedByKey   bool
+	output         string
+	retryExitCode  int
+	template       string
+	timeout        time.Duration
+	topic          string
+}
+
+func consumeCommand() command {
+	return command{
+		name:        "consume",
+		description: "Consume messages from a Kafka topic and dump them or pass them to a handler command (polly)",
+		options:     func() []interface{} { return []interface{}{&polly.Options{}} },
+		setup: func(_ *app, fs *flag.FlagSet) func(ctx context.Context) error {
+			var f consumeFlags
+			f.registerFlags(fs)
+			return func(ctx context.Context) error { return runConsume(ctx, f) }
+		},
+	}
+}
+
+func (f *consumeFlags) registerFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&f.ce, "ce", false, "expect CloudEvents format for event payload, only applies to -output text")
+	fs.DurationVar(&f.closeTimeout, "close-timeout", 0, "Max time to wait for in-flight messages on shutdown (default KAFKA_CONSUMER_CLOSE_TIMEOUT or 10s)")
+	fs.StringVar(&f.deadLetterFile, "dead-letter-file", "", "File to append messages (JSON lines) the -handler failed to process, default is to log and skip them")
+	fs.Var(&f.filters, "filter", "Filter expression <field><op><value> e.g. 'ce.type~*.created' or 'json.id>=42', can be used multiple times (see polly.Filter)")
+	fs.StringVar(&f.handler, "handler", "", "External command with optional (shell-quoted) arguments to pass message payload via STDIN and metadata as POLLY_* env vars, if not set messages will be dumped to STDOUT")
+	fs.IntVar(&f.concurrency, "handler-concurrency", 1, "Max number of -handler invocations running in parallel, in-flight handlers are drained on shutdown")
+	fs.BoolVar(&f.orderedByKey, "handler-ordered", false, "Process messages with the same key in order if -handler-concurrency > 1")
+	fs.StringVar(&f.handlerMode, "handler-mode", handlerModeExec, "'exec' starts -handler per message, 'coprocess' starts it once and streams messages as JSON lines via STDIN, expecting acks {\"id\":1,\"status\":\"ok|retry|fail\"} on STDOUT")
+	fs.IntVar(&f.handlerRetries, "handler-retries", defaultMaxRetries, "Max number of retries if the -handler exits with -handler-retry-code or times out")
+	fs.IntVar(&f.retryExitCode, "handler-retry-code", defaultRetryExitCode, "Exit code of -handler that signals a temporary failure, other non-zero codes are considered permanent")
+	fs.DurationVar(&f.handlerTimeout, "handler-timeout", 0, "Timeout per -handler invocation, zero means no timeout")
+	fs.StringVar(&f.output, "output", polly.OutputText, "Output format for received messages, one of 'text', 'json', 'pretty', 'raw', 'template'")
+	fs.StringVar(&f.template, "template", "", "Go template for -output template, evaluated against polly.MessageRecord e.g. '{{.Key}} {{.Value.type}}'")
+	fs.DurationVar(&f.timeout, "timeout", defaultConsumeTimeout, "Timeout duration to run the consumer, zero or negative value means no timeout")
+	fs.StringVar(&f.topic, "topic", "", "Kafka topic for message consumption")
+}
+
+func runConsume(ctx context.Context, f consumeFlags) (err error) {
+	mLogger := log.Ctx(ctx).With().Str("logger", "main").Logger()
+	p, err := newPollyClient(f.closeTimeout)
+	if err != nil {
+		return err
+	}
+	// open before registering WaitForClose, so deferred close happens after handlers are down
+	var deadLetter io.Writer
+	if f.deadLetterFile != "" {
+		dlf, err := os.OpenFile(f.deadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("cannot open dead-letter file %s: %w", f.deadLetterFile, err)
+		}
+		defer func() { _ = dlf.Close() }()
+		deadLetter = dlf
+	}
+
+	// Nice: From go 1.16 onwards we no longer have to manage signal channel manually https://henvic.dev/posts/signal-notify-context/
+	// also a good intro on different contexts: https://www.sohamkamani.com/golang/context/
+	// in-flight handlers are drained by WaitForClose before stop() releases the signals (and cancels ctx)
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer func() {
+		err = errors.Join(err, p.WaitForClose(context.WithoutCancel(ctx)))
+		stop()
+	}()
+
+	handlerFunc, err := selectHandler(ctx, f, deadLetter, p)
+	if err != nil {
+		return err
+	}
+	if len(f.filters) > 0 {
+		filter, err := polly.NewFilter(f.filters...)
+		if err != nil {
+			return err
+		}
+		mLogger.Info().Msgf("Only messages matching %s will be passed to the handler", filter)
+		handlerFunc = polly.FilterMessages(filter, handlerFunc)
+	}
+	return pollUntilDone(ctx, p, kafka.ReaderConfig{Topic: f.topic}, handlerFunc, f.timeout)
+}
+
+// newPollyClient returns a client configured from environment, closeTimeout takes precedence if it's set
+func newPollyClient(closeTimeout time.Duration) (*polly.Client, error) {
+	options, err := polly.NewOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if closeTimeout > 0 {
+		options.ConsumerCloseTimeout = closeTimeout
+	}
+	return polly.NewClient(options), nil
+}
+
+// pollUntilDone runs the consumer until it returns an error, the timeout is reached or the context is done
+func pollUntilDone(ctx context.Context, p *polly.Client, rc kafka.ReaderConfig, handlerFunc polly.HandleMessageFunc, timeout time.Duration) error {
+	mLogger := log.Ctx(ctx).With().Str("logger", "main").Logger()
+	timeoutChan := initTimeoutChannel(ctx, timeout)
+	errChan := make(chan error, 1)
+
+	go func() {
+		errChan <- p.Poll(ctx, rc, withoutCancel(handlerFunc))
+	}()
+
+	select {
+	case err := <-errChan:
+		mLogger.Info().Msgf("CLI: Got error from Kafka Consumer: %v", err)
+		return err
+	case <-timeoutChan:
+		mLogger.Info().Msgf("CLI: Timeout period exceeded after %v, shutting down consumer", timeout)
+	case <-ctx.Done():
+		mLogger.Info().Msgf("CLI: Context Notified on '%v', waiting for polly subsystem shutdown\n", ctx.Err())
+	}
+	return nil
+}
+
+// withoutCancel runs the handler with a context that isn't canceled by SIGTERM, since the messages have already
+// been read (and committed) they're finished while WaitForClose waits for the consumer instead of being aborted
+func withoutCancel(next polly.HandleMessageFunc) polly.HandleMessageFunc {
+	return func(ctx context.Context, message kafka.Message) {
+		next(context.WithoutCancel(ctx), message)
+	}
+}
+
+// set the timeout channel to nil when the timeout is zero or negative. A nil channel in a select never fires,
+// so we can keep the select block simple.
+func initTimeoutChannel(ctx context.Context, timeout time.Duration) <-chan time.Time {
+	timeoutChan := (<-chan time.Time)(nil)
+	if timeout > 0 {
+		timeoutChan = time.After(timeout)
+		log.Ctx(ctx).Info().Msgf("Timeout is set, consumer will terminate after %v", timeout)
+	} else {
+		log.Ctx(ctx).Info().Msg("No timeout set, running consumer until interrupted")
+	}
+	return timeoutChan
+}
+
+// validate rejects flag combinations where one flag would silently be ignored: -handler receives the raw messages,
+// so -output, -template and -ce don't apply, and -ce only applies to the default text output
+func (f consumeFlags) validate() error {
+	customOutput := (f.output != "" && f.output != polly.OutputText) || f.template != ""
+	switch {
+	case f.handler != "" && customOutput:
+		return fmt.Errorf("%w: -output and -template cannot be combined with -handler, which receives the raw messages", errInvalidArgs)
+	case f.handler != "" && f.ce:
+		return fmt.Errorf("%w: -ce cannot be combined with -handler, which receives the raw messages", errInvalidArgs)
+	case f.ce && customOutput:
+		return fmt.Errorf("%w: -ce only applies to -output text, use -output json or -template to render CloudEvents", errInvalidArgs)
+	}
+	return nil
+}
+
+func selectHandler(ctx context.Context, f consumeFlags, deadLetter io.Writer, p *polly.Client) (polly.HandleMessageFunc, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	output := f.output
+	if f.template != "" && (output == "" || output == polly.OutputText) {
+		output = polly.OutputTemplate // -template implies -output template
+	}
+	switch {
+	case f.handler != "":
+		opts := callbackOptions{
+			command:       f.handler,
+			timeout:       f.handlerTimeout,
+			retryExitCode: f.retryExitCode,
+			maxRetries:    f.handlerRetries,
+			retryDelay:    defaultRetryDelay,
+			deadLetter:    deadLetter,
+		}
+		if f.handlerMode != handlerModeCoprocess {
+			handler, err := PassToCallbackHandler(opts)
+			if err != nil {
+				return nil, err
+			}
+			d := newDispatcher(ctx, f.concurrency, f.orderedByKey, p.CloseTimeout(), handler)
+			p.RegisterCloser(d) // WaitForClose drains in-flight handlers after the consumer went down
+			return d.HandleMessage, nil
+		}
+		if f.concurrency > 1 {
+			return nil, fmt.Errorf("%w: -handler-concurrency > 1 is not supported in coprocess mode", errHandlerCommand)
+		}
+		cp, err := newCoprocess(opts)
+		if err != nil {
+			return nil, err
+		}
+		p.RegisterCloser(cp) // WaitForClose shuts down the coprocess after the consumer went down
+		return cp.HandleMessage, nil
+	case output != "" && output != polly.OutputText:
+		return polly.NewOutputHandler(os.Stdout, output, f.template)
+	case f.ce:
+		return DumpCloudEvent, nil
+	default:
+		return polly.DumpMessage, nil
+	}
+}
+
+// DumpCloudEvent simple handler function that can be used as HandleMessageFunc and prints the CloudEvent
+func DumpCloudEvent(_ context.Context, message kafka.Message) {
+	ce, err := polly.AsCloudEvent(message)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("%d/%d type %s\npayload: %v\n", message.Partition, message.Offset, ce.Type(), ce)
+}
@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/pkg/polly"
+)
+
+func TestSelectHandler(t *testing.T) {
+	_, err := selectHandler(context.Background(), consumeFlags{output: "json"}, nil, nil)
+	assert.NoError(t, err)
+	_, err = selectHandler(context.Background(), consumeFlags{output: polly.OutputText, template: "{{.Key}}"}, nil, nil)
+	assert.NoError(t, err)
+	_, err = selectHandler(context.Background(), consumeFlags{output: "xml"}, nil, nil)
+	assert.ErrorContains(t, err, "invalid output format")
+
+	// flags that would be ignored are rejected
+	for _, f := range []consumeFlags{
+		{handler: "cat", output: "json"},
+		{handler: "cat", template: "{{.Key}}"},
+		{handler: "cat", ce: true},
+		{ce: true, output: "json"},
+		{ce: true, template: "{{.Key}}"},
+	} {
+		_, err = selectHandler(context.Background(), f, nil, nil)
+		assert.ErrorIs(t, err, errInvalidArgs, "%+v", f)
+	}
+	_, err = selectHandler(context.Background(), consumeFlags{ce: true, output: polly.OutputText}, nil, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,267 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/tillkuhn/rubin/pkg/polly"
+)
+
+const (
+	handlerModeExec      = "exec"
+	handlerModeCoprocess = "coprocess"
+	// defaultCoprocessGracePeriod for the coprocess to finish in-flight messages and exit after STDIN is closed
+	defaultCoprocessGracePeriod = 5 * time.Second
+	maxAckLineBytes             = 1024 * 1024
+	ackBufferSize               = 16
+	ackOK                       = "ok"
+	ackRetry                    = "retry"
+	ackFail                     = "fail"
+)
+
+var errCoprocess = errors.New("coprocess error")
+
+// coprocessEnvelope is written as JSON line to the coprocess STDIN for every message
+type coprocessEnvelope struct {
+	ID uint64 `json:"id"`
+	polly.MessageRecord
+}
+
+// coprocessAck is expected as JSON line on the coprocess STDOUT for every envelope, status is one of ok, retry, fail.
+// Other lines on STDOUT are considered log output of the handler
+type coprocessAck struct {
+	ID     uint64 `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// coprocess keeps a long-lived handler process, so we don't have to fork a new process per message.
+// Only one message is in flight at a time, so the consumer is slowed down to the pace of the handler (backpressure).
+// The process is (re)started on demand if it crashes, and shut down gracefully by Close
+type coprocess struct {
+	opts callbackOptions
+	args []string
+	// mu guards the process state and ensures there's only a single message in flight
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	acks      chan coprocessAck
+	exited    chan struct{}
+	seq       uint64
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func newCoprocess(opts callbackOptions) (*coprocess, error) {
+	args, err := splitCommand(opts.command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: command is empty", errHandlerCommand)
+	}
+	log.Info().Msgf("Registering coprocess for externalCommand: %s", opts.command)
+	return &coprocess{opts: opts, args: args, closing: make(chan struct{})}, nil
+}
+
+// String representation of the coprocess
+func (cp *coprocess) String() string {
+	return "coprocess " + cp.opts.command
+}
+
+// HandleMessage passes the message to the coprocess and waits for the ack, signature matches polly.HandleMessageFunc
+func (cp *coprocess) HandleMessage(ctx context.Context, message kafka.Message) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	var err error
+	for attempt := 0; attempt <= cp.opts.maxRetries; attempt++ {
+		if attempt > 0 {
+			delay := cp.opts.retryDelay * time.Duration(attempt) // linear backoff
+			log.Warn().Msgf("Retrying message in coprocess in %v (attempt %d/%d): %v", delay, attempt, cp.opts.maxRetries, err)
+			select {
+			case <-ctx.Done():
+				log.Warn().Msgf("Context done, give up retrying message in coprocess: %v", ctx.Err())
+				return
+			case <-cp.closing:
+				log.Warn().Msg("Coprocess is closing, give up retrying message")
+				return
+			case <-time.After(delay):
+			}
+		}
+		var retry bool
+		retry, err = cp.send(message)
+		if err == nil || !retry {
+			break
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Coprocess failed to handle message %s %d/%d", message.Topic, message.Partition, message.Offset)
+		writeDeadLetter(cp.opts, message, -1, err)
+	}
+}
+
+// send writes the envelope to the coprocess and waits for the matching ack, returns whether an error is worth a retry
+func (cp *coprocess) send(message kafka.Message) (bool, error) {
+	select {
+	case <-cp.closing:
+		return false, fmt.Errorf("%w: coprocess is closing", errCoprocess)
+	default:
+	}
+	if cp.cmd != nil {
+		select {
+		case <-cp.exited:
+			log.Warn().Msg("Coprocess exited since last message, restarting")
+			cp.reset()
+		default:
+		}
+	}
+	if cp.cmd == nil {
+		if err := cp.start(); err != nil {
+			return true, err
+		}
+	}
+
+	cp.seq++
+	envelope := coprocessEnvelope{ID: cp.seq, MessageRecord: polly.NewMessageRecord(message)}
+	line, err := json.Marshal(envelope)
+	if err != nil {
+		return false, fmt.Errorf("%w: cannot marshal envelope: %s", errCoprocess, err.Error())
+	}
+	if _, err := cp.stdin.Write(append(line, '\n')); err != nil {
+		cp.kill()
+		return true, fmt.Errorf("%w: cannot write to coprocess: %s", errCoprocess, err.Error())
+	}
+
+	var timeout, grace <-chan time.Time
+	if cp.opts.timeout > 0 {
+		timer := time.NewTimer(cp.opts.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	closing := cp.closing
+	for {
+		select {
+		case ack := <-cp.acks:
+			if ack.ID != envelope.ID {
+				log.Debug().Msgf("Ignore stale ack id=%d, waiting for id=%d", ack.ID, envelope.ID)
+				continue
+			}
+			return ackResult(ack)
+		case <-cp.exited:
+			cp.reset()
+			return true, fmt.Errorf("%w: coprocess exited unexpectedly while handling message id=%d", errCoprocess, envelope.ID)
+		case <-timeout:
+			cp.kill()
+			return true, fmt.Errorf("%w: no ack after %v for message id=%d", errCoprocess, cp.opts.timeout, envelope.ID)
+		case <-closing:
+			// let the in-flight message finish within the grace period
+			closing = nil
+			grace = time.After(defaultCoprocessGracePeriod)
+		case <-grace:
+			cp.kill()
+			return false, fmt.Errorf("%w: no ack within shutdown grace period for message id=%d", errCoprocess, envelope.ID)
+		}
+	}
+}
+
+func ackResult(ack coprocessAck) (bool, error) {
+	switch ack.Status {
+	case ackOK:
+		return false, nil
+	case ackRetry:
+		return true, fmt.Errorf("%w: coprocess requested retry for message id=%d: %s", errCoprocess, ack.ID, ack.Reason)
+	case ackFail:
+		return false, fmt.Errorf("%w: coprocess failed message id=%d: %s", errCoprocess, ack.ID, ack.Reason)
+	default:
+		return false, fmt.Errorf("%w: unknown ack status %q for message id=%d", errCoprocess, ack.Status, ack.ID)
+	}
+}
+
+// start launches the process and a goroutine that reads acks from STDOUT, must be called with mu held
+func (cp *coprocess) start() error {
+	cmd := exec.Command(cp.args[0], cp.args[1:]...) // #nosec G204
+	cmd.Env = append(os.Environ(), envPrefix+"MODE="+handlerModeCoprocess)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("%w: %s", errCoprocess, err.Error())
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("%w: %s", errCoprocess, err.Error())
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%w: cannot start %s: %s", errCoprocess, cp.opts.command, err.Error())
+	}
+	log.Info().Msgf("Started coprocess pid=%d: %s", cmd.Process.Pid, cp.opts.command)
+
+	acks := make(chan coprocessAck, ackBufferSize)
+	exited := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxAckLineBytes)
+		for scanner.Scan() {
+			var ack coprocessAck
+			if err := json.Unmarshal(scanner.Bytes(), &ack); err != nil || ack.ID == 0 {
+				log.Info().Msgf("Coprocess output: %s", scanner.Text())
+				continue
+			}
+			select {
+			case acks <- ack:
+			default:
+				log.Warn().Msgf("Ack buffer full, drop ack id=%d", ack.ID)
+			}
+		}
+		// Wait must only be called after all reads from the pipe have completed
+		err := cmd.Wait()
+		log.Info().Msgf("Coprocess pid=%d exited: %v", cmd.Process.Pid, err)
+		close(exited)
+	}()
+	cp.cmd, cp.stdin, cp.acks, cp.exited = cmd, stdin, acks, exited
+	return nil
+}
+
+// kill terminates the process and waits until it exited, must be called with mu held
+func (cp *coprocess) kill() {
+	if cp.cmd == nil {
+		return
+	}
+	_ = cp.cmd.Process.Kill()
+	<-cp.exited
+	cp.reset()
+}
+
+func (cp *coprocess) reset() {
+	cp.cmd, cp.stdin = nil, nil
+}
+
+// Close stops accepting messages, waits for the in-flight message (if any) and closes STDIN so the
+// process can exit gracefully, it's killed if it's still running after the grace period
+func (cp *coprocess) Close() error {
+	cp.closeOnce.Do(func() { close(cp.closing) })
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.cmd == nil {
+		return nil
+	}
+	log.Info().Msgf("Closing coprocess pid=%d", cp.cmd.Process.Pid)
+	_ = cp.stdin.Close() // EOF signals the handler to exit
+	select {
+	case <-cp.exited:
+		cp.reset()
+	case <-time.After(defaultCoprocessGracePeriod):
+		log.Warn().Msgf("Coprocess did not exit within %v, killing it", defaultCoprocessGracePeriod)
+		cp.kill()
+	}
+	return nil
+}
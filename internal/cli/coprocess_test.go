@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+)
+
+func TestCoprocess(t *testing.T) {
+	log.Logger = zerolog.Nop()
+	var deadLetter bytes.Buffer
+	cp, err := newCoprocess(callbackOptions{
+		command:    testutil.TestDataDir + "/coprocess-handler.sh",
+		maxRetries: 1,
+		retryDelay: time.Millisecond,
+		timeout:    5 * time.Second,
+		deadLetter: &deadLetter,
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, cp.String(), "coprocess-handler.sh")
+	ctx := context.Background()
+
+	cp.HandleMessage(ctx, kafka.Message{Key: []byte("ok-1"), Value: []byte(`{"hello":"world"}`)})
+	pid := cp.cmd.Process.Pid
+	cp.HandleMessage(ctx, kafka.Message{Key: []byte("ok-2"), Value: []byte("plain")})
+	assert.Equal(t, pid, cp.cmd.Process.Pid, "process should be reused")
+	assert.Empty(t, deadLetter.String())
+	assert.Equal(t, uint64(2), cp.seq)
+
+	cp.HandleMessage(ctx, kafka.Message{Key: []byte("fail")})
+	assert.Contains(t, deadLetter.String(), "cannot process fail")
+
+	deadLetter.Reset()
+	cp.HandleMessage(ctx, kafka.Message{Key: []byte("retry")})
+	assert.Contains(t, deadLetter.String(), "try again later")
+	assert.Equal(t, uint64(5), cp.seq, "retry should be sent twice")
+
+	// crash is retried with a new process and finally dead-lettered
+	deadLetter.Reset()
+	cp.HandleMessage(ctx, kafka.Message{Key: []byte("crash")})
+	assert.Contains(t, deadLetter.String(), "exited unexpectedly")
+
+	// restarted after crash
+	cp.HandleMessage(ctx, kafka.Message{Key: []byte("ok-3")})
+	assert.NotNil(t, cp.cmd)
+	assert.NotEqual(t, pid, cp.cmd.Process.Pid)
+
+	assert.NoError(t, cp.Close())
+	assert.Nil(t, cp.cmd)
+
+	// closed coprocess does not accept new messages
+	deadLetter.Reset()
+	cp.HandleMessage(ctx, kafka.Message{Key: []byte("ok-4")})
+	assert.Contains(t, deadLetter.String(), "coprocess is closing")
+}
+
+func TestCoprocessTimeout(t *testing.T) {
+	log.Logger = zerolog.Nop()
+	var deadLetter bytes.Buffer
+	cp, err := newCoprocess(callbackOptions{command: "cat", timeout: 20 * time.Millisecond, deadLetter: &deadLetter})
+	assert.NoError(t, err)
+	// cat echoes the envelope, which has the right id but no valid status
+	cp.HandleMessage(context.Background(), kafka.Message{Key: []byte("k")})
+	assert.Contains(t, deadLetter.String(), "unknown ack status")
+
+	cp2, err := newCoprocess(callbackOptions{command: "sleep 5", timeout: 20 * time.Millisecond, deadLetter: &deadLetter})
+	assert.NoError(t, err)
+	cp2.HandleMessage(context.Background(), kafka.Message{Key: []byte("k")})
+	assert.Contains(t, deadLetter.String(), "no ack after 20ms")
+	assert.NoError(t, cp2.Close())
+
+	_, err = newCoprocess(callbackOptions{command: ""})
+	assert.ErrorContains(t, err, "command is empty")
+}
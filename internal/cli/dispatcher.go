@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/tillkuhn/rubin/pkg/polly"
+)
+
+// dispatcher runs a HandleMessageFunc with bounded concurrency using a fixed pool of workers.
+// If orderedByKey is true, messages are assigned to workers by hash of their key, so messages with the
+// same key are processed in order. HandleMessage blocks while all workers are busy (backpressure).
+//
+// Handlers run with a context that is detached from the consumer's context, so in-flight handlers
+// are not killed when the consumer is stopped (e.g. by SIGTERM), but drained by Close
+type dispatcher struct {
+	next         polly.HandleMessageFunc
+	queues       []chan kafka.Message
+	orderedByKey bool
+	drainTimeout time.Duration
+	running      atomic.Int32
+	ctx          context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+	// closing is closed by Close, queues are never closed so late senders can't panic
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// newDispatcher starts the workers, ctx is only used to derive the (detached) handler context, e.g. for logging.
+// drainTimeout is the max time Close waits for in-flight handlers before their context is canceled
+func newDispatcher(ctx context.Context, concurrency int, orderedByKey bool, drainTimeout time.Duration, next polly.HandleMessageFunc) *dispatcher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	d := &dispatcher{next: next, orderedByKey: orderedByKey, drainTimeout: drainTimeout, closing: make(chan struct{})}
+	d.ctx, d.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	// unordered workers share a single queue, ordered workers get a dedicated queue each
+	numQueues := 1
+	if orderedByKey {
+		numQueues = concurrency
+	}
+	for i := 0; i < numQueues; i++ {
+		d.queues = append(d.queues, make(chan kafka.Message))
+	}
+	for i := 0; i < concurrency; i++ {
+		d.wg.Add(1)
+		go d.work(d.queues[i%numQueues])
+	}
+	log.Ctx(ctx).Info().Msgf("Dispatching messages to %d concurrent handler(s) orderedByKey=%v", concurrency, orderedByKey)
+	return d
+}
+
+func (d *dispatcher) work(queue <-chan kafka.Message) {
+	defer d.wg.Done()
+	for {
+		select {
+		case message := <-queue:
+			d.running.Add(1)
+			d.next(d.ctx, message)
+			d.running.Add(-1)
+		case <-d.closing:
+			return
+		}
+	}
+}
+
+// HandleMessage passes the message to the next idle worker, signature matches polly.HandleMessageFunc.
+// The message is queued even if ctx is done, since it has already been read (and committed), waiting for
+// a worker is bounded by Close
+func (d *dispatcher) HandleMessage(ctx context.Context, message kafka.Message) {
+	queue := d.queues[0]
+	if d.orderedByKey {
+		h := fnv.New32a()
+		_, _ = h.Write(message.Key)
+		queue = d.queues[h.Sum32()%uint32(len(d.queues))] // #nosec G115 -- len is the worker count
+	}
+	select {
+	case queue <- message:
+	case <-d.closing:
+		log.Ctx(ctx).Warn().Msgf("Dispatcher closed, message %s %d/%d not dispatched", message.Topic, message.Partition, message.Offset)
+	}
+}
+
+// String representation of the dispatcher
+func (d *dispatcher) String() string {
+	return "handler dispatcher"
+}
+
+// Close stops accepting messages and waits for in-flight handlers to finish. If they don't finish
+// within the drain timeout, their context is canceled (which kills external commands) and we wait again.
+// In this case a polly.ErrCloseTimeout with the number of canceled handlers is returned
+func (d *dispatcher) Close() error {
+	d.closeOnce.Do(func() { close(d.closing) })
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		d.wg.Wait()
+	}()
+	var err error
+	select {
+	case <-drained:
+		log.Info().Msg("All in-flight handlers finished")
+	case <-time.After(d.drainTimeout):
+		running := d.running.Load()
+		log.Warn().Msgf("%d handler(s) did not finish within %v, canceling them", running, d.drainTimeout)
+		err = fmt.Errorf("%w %v: %d handler(s) canceled", polly.ErrCloseTimeout, d.drainTimeout, running)
+		d.cancel()
+		<-drained
+	}
+	d.cancel()
+	return err
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/pkg/polly"
+)
+
+func TestDispatcherConcurrency(t *testing.T) {
+	var running, maxRunning int32
+	handler := func(_ context.Context, _ kafka.Message) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	}
+	d := newDispatcher(context.Background(), 3, false, time.Second, handler)
+	for i := 0; i < 9; i++ {
+		d.HandleMessage(context.Background(), kafka.Message{Offset: int64(i)})
+	}
+	assert.NoError(t, d.Close())
+	assert.Equal(t, int32(3), maxRunning)
+	assert.Equal(t, int32(0), running, "close must wait for in-flight handlers")
+}
+
+func TestDispatcherOrderedByKey(t *testing.T) {
+	var mu sync.Mutex
+	offsets := map[string][]int64{}
+	handler := func(_ context.Context, msg kafka.Message) {
+		time.Sleep(time.Duration(msg.Offset%3) * time.Millisecond) // shuffle timing
+		mu.Lock()
+		defer mu.Unlock()
+		offsets[string(msg.Key)] = append(offsets[string(msg.Key)], msg.Offset)
+	}
+	d := newDispatcher(context.Background(), 4, true, time.Second, handler)
+	keys := []string{"a", "b", "c", "d", "e"}
+	for i := 0; i < 50; i++ {
+		d.HandleMessage(context.Background(), kafka.Message{Key: []byte(keys[i%len(keys)]), Offset: int64(i)})
+	}
+	assert.NoError(t, d.Close())
+	for _, k := range keys {
+		assert.Len(t, offsets[k], 10)
+		assert.IsIncreasing(t, offsets[k], k)
+	}
+}
+
+func TestDispatcherQueuesAfterCancel(t *testing.T) {
+	var handled atomic.Int32
+	d := newDispatcher(context.Background(), 1, false, time.Second, func(context.Context, kafka.Message) {
+		time.Sleep(5 * time.Millisecond)
+		handled.Add(1)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := range 3 {
+		d.HandleMessage(ctx, kafka.Message{Offset: int64(i)})
+	}
+	assert.NoError(t, d.Close())
+	assert.Equal(t, int32(3), handled.Load(), "messages that have been read are not dropped")
+}
+
+func TestDispatcherDrainTimeout(t *testing.T) {
+	started := make(chan struct{})
+	handler := func(ctx context.Context, _ kafka.Message) {
+		close(started)
+		<-ctx.Done() // simulates a long-running handler that's only stopped by cancellation
+	}
+	consumerCtx, cancelConsumer := context.WithCancel(context.Background())
+	d := newDispatcher(consumerCtx, 0, false, 10*time.Millisecond, handler)
+	d.HandleMessage(consumerCtx, kafka.Message{})
+	<-started
+	cancelConsumer() // must not affect in-flight handlers
+	assert.NoError(t, d.ctx.Err())
+	err := d.Close()
+	assert.ErrorIs(t, err, polly.ErrCloseTimeout)
+	assert.ErrorContains(t, err, "1 handler(s) canceled")
+	assert.Error(t, d.ctx.Err())
+
+	// late messages must not panic after close
+	d.HandleMessage(context.Background(), kafka.Message{})
+}
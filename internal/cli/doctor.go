@@ -0,0 +1,289 @@
+package cli
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tillkuhn/rubin/pkg/polly"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+	"github.com/tillkuhn/rubin/pkg/tlsconfig"
+)
+
+const (
+	statusPass = "PASS"
+	statusWarn = "WARN"
+	statusFail = "FAIL"
+	statusSkip = "SKIP"
+
+	defaultCheckTimeout = 5 * time.Second
+	// restErrorUnauthorized is the REST Proxy error code if the API key lacks ACLs / RBAC roles
+	restErrorUnauthorized = 40301
+)
+
+var errDoctor = errors.New("diagnostics failed")
+
+type doctorFlags struct {
+	skipBrokers bool
+	timeout     time.Duration
+	topic       string
+}
+
+// checkResult is a single row of the diagnostics report, hint explains how to fix a failed (or suspicious) check
+type checkResult struct {
+	name   string
+	status string
+	detail string
+	hint   string
+}
+
+func doctorCommand() command {
+	return command{
+		name:        "doctor",
+		description: "Diagnose configuration, connectivity and permissions for REST Proxy and brokers",
+		options:     func() []interface{} { return []interface{}{&rubin.Options{}, &polly.Options{}} },
+		setup: func(a *app, fs *flag.FlagSet) func(ctx context.Context) error {
+			var f doctorFlags
+			fs.BoolVar(&f.skipBrokers, "skip-brokers", false, "Skip checks for Kafka brokers (consume), e.g. if only produce via REST Proxy is used")
+			fs.DurationVar(&f.timeout, "timeout", defaultCheckTimeout, "Timeout per network check")
+			fs.StringVar(&f.topic, "topic", "", "Topic to check for existence and permissions (optional)")
+			return func(ctx context.Context) error { return runDoctor(ctx, a.out, f) }
+		},
+	}
+}
+
+func runDoctor(ctx context.Context, out io.Writer, f doctorFlags) error {
+	rOptions, err := rubin.NewOptionsFromEnvWithPrefix(envconfigPrefix)
+	if err != nil {
+		return err
+	}
+	results := restChecks(ctx, rOptions, f)
+	if !f.skipBrokers {
+		pOptions, err := polly.NewOptionsFromEnvWithPrefix(envconfigPrefix)
+		if err != nil {
+			return err
+		}
+		results = append(results, brokerChecks(ctx, pOptions, f.timeout)...)
+	}
+	return printReport(out, results)
+}
+
+// printReport prints the results as table followed by the hints, an error is returned if at least one check failed
+func printReport(out io.Writer, results []checkResult) error {
+	tabPadding := 2
+	tabs := tabwriter.NewWriter(out, 1, 0, tabPadding, ' ', 0)
+	_, _ = fmt.Fprintln(tabs, "CHECK\tSTATUS\tDETAIL")
+	var failed int
+	var hints []string
+	for _, r := range results {
+		_, _ = fmt.Fprintf(tabs, "%s\t%s\t%s\n", r.name, r.status, r.detail)
+		if r.status == statusFail {
+			failed++
+		}
+		if r.hint != "" && (r.status == statusFail || r.status == statusWarn) {
+			hints = append(hints, fmt.Sprintf("  %s: %s", r.name, r.hint))
+		}
+	}
+	if err := tabs.Flush(); err != nil {
+		return err
+	}
+	if len(hints) > 0 {
+		_, _ = fmt.Fprintf(out, "\nHints:\n%s\n", strings.Join(hints, "\n"))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d checks failed", errDoctor, failed, len(results))
+	}
+	return nil
+}
+
+// restChecks validates the REST Proxy options and checks DNS, TLS, authentication and metadata endpoints.
+// The metadata checks are skipped if DNS or TLS checks failed, since they would fail for the same reason
+func restChecks(ctx context.Context, o *rubin.Options, f doctorFlags) []checkResult {
+	topic := f.topic
+	if topic == "" {
+		topic = "<topic>"
+	}
+	endpoint, err := url.Parse(o.RecordEndpoint(topic))
+	results := []checkResult{restConfigCheck(o, endpoint, err), restCredentialsCheck(o)}
+	if results[0].status == statusFail {
+		return results
+	}
+
+	// missing credentials don't skip the remaining checks, since the response of the REST Proxy may give additional hints
+	network := []checkResult{dnsCheck(ctx, "rest dns", endpoint.Hostname(), f.timeout)}
+	if endpoint.Scheme == "https" {
+		network = append(network, tlsCheck("rest tls", hostPort(endpoint), o.TLS, f.timeout))
+	}
+	results = append(results, network...)
+	if hasFailed(network) {
+		return append(results, checkResult{name: "rest cluster", status: statusSkip, detail: "previous check failed"})
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	client := rubin.NewClient(o)
+	cluster, err := client.DescribeCluster(tctx)
+	if err != nil {
+		return append(results, apiErrorResult("rest cluster", err))
+	}
+	results = append(results, checkResult{name: "rest cluster", status: statusPass, detail: "cluster " + cluster.ClusterId})
+	if f.topic == "" {
+		return append(results, checkResult{name: "rest topic", status: statusSkip, detail: "no -topic specified"})
+	}
+	topicData, err := client.DescribeTopic(tctx, f.topic)
+	if err != nil {
+		result := apiErrorResult("rest topic", err)
+		var apiErr *rubin.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			result.hint = "Topic " + f.topic + " does not exist or the API key has no DESCRIBE permission for it"
+		}
+		return append(results, result)
+	}
+	return append(results, checkResult{name: "rest topic", status: statusPass,
+		detail: fmt.Sprintf("%s partitions=%d replication=%d", topicData.TopicName, topicData.PartitionsCount, topicData.ReplicationFactor)})
+}
+
+func restConfigCheck(o *rubin.Options, endpoint *url.URL, parseErr error) checkResult {
+	hint := "Set KAFKA_REST_ENDPOINT (e.g. https://pkc-123.eu-central-1.aws.confluent.cloud:443) and KAFKA_CLUSTER_ID, or KAFKA_PRODUCER_TOPIC_URL"
+	switch {
+	case parseErr != nil:
+		return checkResult{name: "rest config", status: statusFail, detail: parseErr.Error(), hint: hint}
+	case o.ProducerTopicURL.String() == "" && (o.RestEndpoint == "" || o.ClusterID == ""):
+		return checkResult{name: "rest config", status: statusFail, detail: "endpoint or cluster id missing", hint: hint}
+	case endpoint.Scheme != "https" && endpoint.Scheme != "http", endpoint.Host == "":
+		return checkResult{name: "rest config", status: statusFail, detail: "invalid url " + endpoint.String(),
+			hint: "KAFKA_REST_ENDPOINT must be an absolute http(s) url without path, e.g. https://localhost:443"}
+	case !strings.Contains(endpoint.Path, "/kafka/v3/clusters/"):
+		return checkResult{name: "rest config", status: statusWarn, detail: endpoint.String(),
+			hint: "Unexpected url path, REST Proxy v3 urls usually look like /kafka/v3/clusters/<cluster_id>/topics/<topic>"}
+	case endpoint.Scheme == "http":
+		return checkResult{name: "rest config", status: statusWarn, detail: endpoint.String(), hint: "Credentials are sent unencrypted, use https"}
+	}
+	return checkResult{name: "rest config", status: statusPass, detail: endpoint.String()}
+}
+
+func restCredentialsCheck(o *rubin.Options) checkResult {
+	// decode BasicAuth since it considers both API key / secret and user info of the topic url
+	auth, _ := base64.StdEncoding.DecodeString(o.BasicAuth())
+	user, secret, _ := strings.Cut(string(auth), ":")
+	if user == "" || secret == "" {
+		return checkResult{name: "rest credentials", status: statusFail, detail: fmt.Sprintf("hasKey=%v hasSecret=%v", user != "", secret != ""),
+			hint: "Set KAFKA_PRODUCER_API_KEY and KAFKA_PRODUCER_API_SECRET (or user info in KAFKA_PRODUCER_TOPIC_URL)"}
+	}
+	return checkResult{name: "rest credentials", status: statusPass, detail: "key " + user}
+}
+
+// apiErrorResult maps REST Proxy errors to a failed check with remediation hint
+func apiErrorResult(name string, err error) checkResult {
+	result := checkResult{name: name, status: statusFail, detail: err.Error(),
+		hint: "REST Proxy is not reachable, check network, proxy and firewall settings"}
+	var apiErr *rubin.APIError
+	if !errors.As(err, &apiErr) {
+		return result
+	}
+	result.detail = fmt.Sprintf("http status %d", apiErr.StatusCode)
+	if apiErr.Message != "" {
+		result.detail += fmt.Sprintf(" error_code %d: %s", apiErr.ErrorCode, apiErr.Message)
+	}
+	switch {
+	case apiErr.StatusCode == http.StatusUnauthorized:
+		result.hint = "Credentials were rejected, check KAFKA_PRODUCER_API_KEY and KAFKA_PRODUCER_API_SECRET and make sure the key belongs to this cluster"
+	case apiErr.StatusCode == http.StatusForbidden || apiErr.ErrorCode == restErrorUnauthorized:
+		result.hint = "The API key is valid but not authorized, check the ACLs or RBAC role bindings of the key"
+	case apiErr.StatusCode == http.StatusNotFound:
+		result.hint = "Cluster not found, check KAFKA_CLUSTER_ID and the path of the endpoint"
+	default:
+		result.hint = "Unexpected response, run with KAFKA_DUMP_MESSAGES=true to see request and response"
+	}
+	return result
+}
+
+// brokerChecks validates the consumer options and checks DNS and TLS handshake for each broker
+func brokerChecks(ctx context.Context, o *polly.Options, timeout time.Duration) []checkResult {
+	var results []checkResult
+	if o.ConsumerAPIKey == "" || o.ConsumerAPISecret == "" {
+		results = append(results, checkResult{name: "broker credentials", status: statusFail,
+			detail: fmt.Sprintf("hasKey=%v hasSecret=%v", o.ConsumerAPIKey != "", o.ConsumerAPISecret != ""),
+			hint:   "Set KAFKA_CONSUMER_API_KEY and KAFKA_CONSUMER_API_SECRET"})
+	} else {
+		results = append(results, checkResult{name: "broker credentials", status: statusPass, detail: "key " + o.ConsumerAPIKey})
+	}
+	for _, broker := range o.Brokers() {
+		host, _, err := net.SplitHostPort(broker)
+		if err != nil {
+			results = append(results, checkResult{name: "broker config", status: statusFail, detail: err.Error(),
+				hint: "KAFKA_BOOTSTRAP_SERVERS must be a comma separated list of host:port"})
+			continue
+		}
+		dns := dnsCheck(ctx, "broker dns "+host, host, timeout)
+		results = append(results, dns)
+		if dns.status == statusPass {
+			results = append(results, tlsCheck("broker tls "+broker, broker, o.TLS, timeout))
+		}
+	}
+	return results
+}
+
+func dnsCheck(ctx context.Context, name string, host string, timeout time.Duration) checkResult {
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(tctx, host)
+	if err != nil {
+		return checkResult{name: name, status: statusFail, detail: err.Error(), hint: "Host cannot be resolved, check spelling and DNS / VPN settings"}
+	}
+	return checkResult{name: name, status: statusPass, detail: strings.Join(addrs, ",")}
+}
+
+// tlsCheck performs a TLS handshake using the configured CA, client certificate and server name
+func tlsCheck(name string, addr string, options tlsconfig.Options, timeout time.Duration) checkResult {
+	tlsConfig, err := options.Config()
+	if err != nil {
+		return checkResult{name: name, status: statusFail, detail: err.Error(), hint: "Check KAFKA_TLS_* files"}
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsConfig)
+	if err != nil {
+		return checkResult{name: name, status: statusFail, detail: err.Error(),
+			hint: "Connection or handshake failed, check host and port and whether a custom CA (KAFKA_TLS_CA_FILE) is required"}
+	}
+	defer func() { _ = conn.Close() }()
+	state := conn.ConnectionState()
+	detail := tls.VersionName(state.Version)
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		detail += fmt.Sprintf(" cn=%s expires=%s", cert.Subject.CommonName, cert.NotAfter.Format(time.DateOnly))
+	}
+	if options.InsecureSkipVerify {
+		return checkResult{name: name, status: statusWarn, detail: detail, hint: "Certificate verification is disabled (KAFKA_TLS_INSECURE_SKIP_VERIFY)"}
+	}
+	return checkResult{name: name, status: statusPass, detail: detail}
+}
+
+// hostPort returns host:port of the url, using the default port of the scheme if not specified
+func hostPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+func hasFailed(results []checkResult) bool {
+	for _, r := range results {
+		if r.status == statusFail {
+			return true
+		}
+	}
+	return false
+}
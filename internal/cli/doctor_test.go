@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+	"github.com/tillkuhn/rubin/pkg/polly"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+	"github.com/tillkuhn/rubin/pkg/tlsconfig"
+)
+
+func TestDoctorRestChecks(t *testing.T) {
+	srv := testutil.TLSServerMock()
+	defer srv.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, testutil.WriteCertPEM(srv, caFile))
+	o := &rubin.Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "hase", ProducerAPISecret: "friedrich",
+		TLS: tlsconfig.Options{CAFile: caFile}}
+
+	results := restChecks(context.Background(), o, doctorFlags{topic: "public.hello", timeout: time.Second})
+	assert.Equal(t, map[string]string{"rest config": statusPass, "rest credentials": statusPass, "rest dns": statusPass,
+		"rest tls": statusPass, "rest cluster": statusPass, "rest topic": statusPass}, statusByName(results))
+
+	// unknown topic and cluster
+	results = restChecks(context.Background(), o, doctorFlags{topic: "public.unknown", timeout: time.Second})
+	assert.Equal(t, statusFail, statusByName(results)["rest topic"])
+	o.ClusterID = "unknown"
+	results = restChecks(context.Background(), o, doctorFlags{timeout: time.Second})
+	assert.Contains(t, results[len(results)-1].hint, "KAFKA_CLUSTER_ID")
+
+	// missing credentials result in 401, tls check fails without custom CA
+	o.ClusterID, o.ProducerAPIKey, o.ProducerAPISecret = testutil.ClusterID, "", ""
+	results = restChecks(context.Background(), o, doctorFlags{timeout: time.Second})
+	assert.Equal(t, statusFail, statusByName(results)["rest credentials"])
+	assert.Contains(t, results[len(results)-1].hint, "Credentials were rejected")
+	o.TLS = tlsconfig.Options{}
+	assert.Equal(t, statusFail, statusByName(restChecks(context.Background(), o, doctorFlags{timeout: time.Second}))["rest tls"])
+}
+
+func TestDoctorConfigChecks(t *testing.T) {
+	results := restChecks(context.Background(), &rubin.Options{}, doctorFlags{timeout: time.Second})
+	assert.Equal(t, map[string]string{"rest config": statusFail, "rest credentials": statusFail}, statusByName(results))
+
+	topicURL, _ := url.Parse("http://user:pw@localhost:8082/v3/clusters/abc/topics/hello")
+	assert.Equal(t, statusWarn, restConfigCheck(&rubin.Options{ProducerTopicURL: *topicURL}, topicURL, nil).status)
+	assert.Equal(t, statusPass, restCredentialsCheck(&rubin.Options{ProducerTopicURL: *topicURL}).status)
+
+	results = brokerChecks(context.Background(), &polly.Options{BootstrapServers: "localhost,127.0.0.1:1"}, time.Second)
+	assert.Equal(t, map[string]string{"broker credentials": statusFail, "broker config": statusFail,
+		"broker dns 127.0.0.1": statusPass, "broker tls 127.0.0.1:1": statusFail}, statusByName(results))
+}
+
+func TestDoctorReport(t *testing.T) {
+	setupMock(t)
+	var out bytes.Buffer
+	err := newApp(BuildInfo{}, &out).run(context.Background(), []string{"doctor", "-skip-brokers", "-topic", "public.hello"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "public.hello partitions=6 replication=3")
+	assert.Contains(t, out.String(), "Credentials are sent unencrypted") // mock uses http
+
+	err = printReport(&out, []checkResult{{name: "rest dns", status: statusFail, hint: "check dns"}})
+	assert.ErrorIs(t, err, errDoctor)
+}
+
+func statusByName(results []checkResult) map[string]string {
+	m := make(map[string]string, len(results))
+	for _, r := range results {
+		m[r.name] = r.status
+	}
+	return m
+}
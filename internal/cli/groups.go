@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+func clustersCommand() command {
+	return command{
+		name:        "clusters",
+		description: "List the clusters managed by the REST Proxy",
+		options:     func() []interface{} { return []interface{}{&rubin.Options{}} },
+		setup: func(a *app, _ *flag.FlagSet) func(ctx context.Context) error {
+			return func(ctx context.Context) error {
+				client, err := rubin.NewClientFromEnv()
+				if err != nil {
+					return err
+				}
+				return runClusters(ctx, a.out, client)
+			}
+		},
+	}
+}
+
+func groupsCommand() command {
+	return command{
+		name:        "groups",
+		description: "List consumer groups with lag via REST Proxy, usage: groups [<group>] to show the lag per partition of a group",
+		options:     func() []interface{} { return []interface{}{&rubin.Options{}} },
+		setup: func(a *app, fs *flag.FlagSet) func(ctx context.Context) error {
+			return func(ctx context.Context) error {
+				args, err := parseInterspersed(fs)
+				if err != nil {
+					return err
+				}
+				client, err := rubin.NewClientFromEnv()
+				if err != nil {
+					return err
+				}
+				if len(args) > 0 {
+					return runGroupLags(ctx, a.out, client, args[0])
+				}
+				return runGroups(ctx, a.out, client)
+			}
+		},
+	}
+}
+
+func runClusters(ctx context.Context, out io.Writer, client *rubin.Client) error {
+	clusters, err := client.ListClusters(ctx)
+	if err != nil {
+		return err
+	}
+	tabs := newTabWriter(out)
+	_, _ = fmt.Fprintln(tabs, "CLUSTER\tURL")
+	for _, c := range clusters {
+		_, _ = fmt.Fprintf(tabs, "%s\t%s\n", c.ClusterId, c.Metadata.Self)
+	}
+	return tabs.Flush()
+}
+
+// runGroups lists the consumer groups, the lag summary is fetched per group. Since not all REST Proxy versions
+// support lags, errors are logged and the lag is omitted, but missing permissions and cancellation are returned
+func runGroups(ctx context.Context, out io.Writer, client *rubin.Client) error {
+	groups, err := client.ListConsumerGroups(ctx)
+	if err != nil {
+		return err
+	}
+	tabs := newTabWriter(out)
+	_, _ = fmt.Fprintln(tabs, "GROUP\tSTATE\tTOTAL LAG\tMAX LAG\tMAX LAG TOPIC")
+	for _, g := range groups {
+		summary, err := client.ConsumerGroupLagSummary(ctx, g.ConsumerGroupId)
+		if err != nil {
+			var apiErr *rubin.APIError
+			if ctx.Err() != nil {
+				return fmt.Errorf("cannot get lag summary for %s: %w", g.ConsumerGroupId, ctx.Err())
+			}
+			if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+				return err
+			}
+			log.Ctx(ctx).Warn().Msgf("Cannot get lag summary for %s: %v", g.ConsumerGroupId, err)
+			_, _ = fmt.Fprintf(tabs, "%s\t%s\t-\t-\t-\n", g.ConsumerGroupId, g.State)
+			continue
+		}
+		_, _ = fmt.Fprintf(tabs, "%s\t%s\t%d\t%d\t%s/%d\n", g.ConsumerGroupId, g.State,
+			summary.TotalLag, summary.MaxLag, summary.MaxLagTopicName, summary.MaxLagPartitionId)
+	}
+	return tabs.Flush()
+}
+
+func runGroupLags(ctx context.Context, out io.Writer, client *rubin.Client, group string) error {
+	lags, err := client.ConsumerGroupLags(ctx, group)
+	if err != nil {
+		return err
+	}
+	tabs := newTabWriter(out)
+	_, _ = fmt.Fprintln(tabs, "TOPIC\tPARTITION\tCURRENT OFFSET\tLOG END OFFSET\tLAG\tCONSUMER")
+	for _, l := range lags {
+		_, _ = fmt.Fprintf(tabs, "%s\t%d\t%d\t%d\t%d\t%s\n", l.TopicName, l.PartitionId, l.CurrentOffset, l.LogEndOffset, l.Lag, l.ConsumerId)
+	}
+	return tabs.Flush()
+}
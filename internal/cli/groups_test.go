@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+func TestGroupsCommand(t *testing.T) {
+	setupMock(t)
+	for _, tc := range []struct {
+		args     []string
+		expected string
+		err      string
+	}{
+		{args: []string{"clusters"}, expected: "abc-r2d2"},
+		{args: []string{"groups"}, expected: "app.consumer  STABLE  110        100      public.hello/1"},
+		{args: []string{"groups"}, expected: "app.legacy    EMPTY   -          -        -"}, // lag summary not found
+		{args: []string{"groups", "app.consumer"}, expected: "public.hello  1          400             500             100"},
+		{args: []string{"groups", "app.unknown"}, err: "404"},
+	} {
+		var out bytes.Buffer
+		err := newApp(BuildInfo{}, &out).run(context.Background(), tc.args)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, tc.args)
+			continue
+		}
+		assert.NoError(t, err, tc.args)
+		assert.Contains(t, out.String(), tc.expected, tc.args)
+	}
+}
+
+func TestGroupsErrors(t *testing.T) {
+	mock := testutil.ServerMock()
+	defer mock.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/lag-summary") || strings.HasSuffix(r.URL.Path, "/clusters") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mock.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	client := rubin.NewClient(&rubin.Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw"})
+	var out bytes.Buffer
+
+	var apiErr *rubin.APIError
+	err := runGroups(context.Background(), &out, client)
+	assert.ErrorAs(t, err, &apiErr, "missing permissions for lags are not hidden")
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+	assert.ErrorAs(t, runClusters(context.Background(), &out, client), &apiErr)
+
+	// canceled while fetching the lag summary
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	canceling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/lag-summary") {
+			cancel()
+			<-r.Context().Done()
+			return
+		}
+		mock.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer canceling.Close()
+	client = rubin.NewClient(&rubin.Options{RestEndpoint: canceling.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw"})
+	assert.ErrorIs(t, runGroups(ctx, &out, client), context.Canceled)
+	assert.Empty(t, out.String())
+}
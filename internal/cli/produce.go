@@ -0,0 +1,193 @@
+package cli
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+// Output formats of the produce command
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputQuiet = "quiet"
+)
+
+type produceFlags struct {
+	ce        bool
+	dryRun    bool
+	dryRunFmt string
+	eType     string
+	headers   arrayFlags
+	key       string
+	output    string
+	partition int
+	rate      rateFlags
+	record    string
+	schema    string
+	source    string
+	subject   string
+	template  string
+	timestamp string
+	topic     string
+	vars      arrayFlags
+}
+
+func produceCommand() command {
+	return command{
+		name:        "produce",
+		description: "Produce a record into a Kafka topic via REST Proxy (default if no command is given)",
+		options:     func() []interface{} { return []interface{}{&rubin.Options{}} },
+		setup: func(a *app, fs *flag.FlagSet) func(ctx context.Context) error {
+			var f produceFlags
+			fs.BoolVar(&f.ce, "ce", false, "CloudEvents format for event payload (default: STRING or JSON)")
+			fs.BoolVar(&f.dryRun, "dry-run", false, "Print the REST request instead of sending it, e.g. to review pipeline changes")
+			fs.StringVar(&f.dryRunFmt, "dry-run-format", "", "Format of the request printed by -dry-run: json or curl (default json)")
+			fs.StringVar(&f.key, "key", "", "Kafka Message Key (optional, default is generated uuid)")
+			fs.StringVar(&f.output, "output", outputText, "Output format of the produce result: text, json (one object per record) or quiet, only text and quiet are supported with -dry-run")
+			fs.IntVar(&f.partition, "partition", -1, "Partition to produce to, default (-1) lets the partitioner decide based on the key")
+			fs.StringVar(&f.record, "record", "", "RecordRequest payload to send into the Kafka Topic")
+			fs.StringVar(&f.schema, "schema", "", "Validate the payload against a JSON Schema file or http(s) URL before producing")
+			fs.StringVar(&f.source, "source", "rubin/cli", "CloudEventy: The context in which an event happened")
+			fs.StringVar(&f.subject, "subject", "", "CloudEventy: The subject of the event in the context of the event producer")
+			fs.StringVar(&f.template, "template", "", "Render the payload from a Go text/template file instead of -record, output of *.json.tmpl must be valid JSON")
+			fs.StringVar(&f.timestamp, "timestamp", "", "Record timestamp as RFC3339 (e.g. 2024-03-01T12:00:00.123Z) or unix epoch millis, default is now")
+			fs.StringVar(&f.topic, "topic", "", "Name of target Kafka Topic")
+			fs.StringVar(&f.eType, "type", "event.Event", "CloudEvents: Type of event related to the originating occurrence")
+			// nice: we can also use flags for maps https://www.emmanuelgautier.com/blog/string-map-command-argument-go
+			fs.Var(&f.headers, "header", "Header formatted as key=value, can be used multiple times")
+			f.rate.register(fs)
+			fs.Var(&f.vars, "var", "Template variable formatted as key=value, available as {{ .Vars.key }}, can be used multiple times")
+			return func(ctx context.Context) error { return runProduce(ctx, a.out, f) }
+		},
+	}
+}
+
+// produceOutput is printed by the produce command if -output json is used
+type produceOutput struct {
+	rubin.ProduceResult
+	// Spooled is true if the record could not be produced but has been persisted in the spool
+	Spooled bool `json:"spooled,omitempty"`
+}
+
+func runProduce(ctx context.Context, out io.Writer, f produceFlags) error {
+	if f.output != outputText && f.output != outputJSON && f.output != outputQuiet {
+		return fmt.Errorf("%w: unsupported output %s, expected one of %s, %s, %s", errInvalidArgs, f.output, outputText, outputJSON, outputQuiet)
+	}
+	options, err := rubin.NewOptionsFromEnv()
+	if err != nil {
+		return err
+	}
+	f.rate.apply(options)
+	if f.dryRun {
+		options.DryRun = true
+		options.DryRunFormat = cmp.Or(f.dryRunFmt, options.DryRunFormat)
+	}
+	if options.DryRun && f.output == outputJSON {
+		return fmt.Errorf("%w: -output %s cannot be combined with -dry-run, which prints the request instead of the result", errInvalidArgs, f.output)
+	}
+	if f.output != outputQuiet {
+		options.DryRunOutput = out // -output quiet only validates the request in dry-run mode
+	}
+	client := rubin.NewClient(options)
+	if f.record, err = payload(f.record, f.template, f.vars); err != nil {
+		return err
+	}
+	if f.schema != "" {
+		client.SetSchemaValidator(rubin.NewSchemaValidator().ForTopic(f.topic, f.schema))
+	}
+	ts, err := parseTimestamp(f.timestamp)
+	if err != nil {
+		return err
+	}
+	var partition *int32
+	if f.partition >= 0 {
+		p := int32(f.partition) // #nosec G115 -- partition counts are small
+		partition = &p
+	}
+	log.Ctx(ctx).Debug().Msgf("Using %s", client)
+	request := rubin.RecordRequest{
+		Topic:        f.topic,
+		Data:         f.record,
+		Key:          f.key,
+		Headers:      headerMap(f.headers),
+		Partition:    partition,
+		Timestamp:    ts,
+		AsCloudEvent: f.ce,
+		Source:       f.source,
+		Type:         f.eType,
+		Subject:      f.subject,
+	}
+	resp, err := client.Produce(ctx, request)
+	if err != nil && options.SpoolDir != "" && rubin.IsRetriable(err) {
+		if err := spoolRecord(ctx, options, request, err); err != nil {
+			return err
+		}
+		return printProduceResult(out, f.output, produceOutput{ProduceResult: rubin.ProduceResult{Topic: request.Topic}, Spooled: true})
+	}
+	if err != nil || options.DryRun {
+		return err // in dry-run mode, the rendered request is the only output
+	}
+	return printProduceResult(out, f.output, produceOutput{ProduceResult: resp.Result()})
+}
+
+// printProduceResult prints the result in the given output format
+func printProduceResult(out io.Writer, output string, result produceOutput) error {
+	switch output {
+	case outputQuiet:
+		return nil
+	case outputJSON:
+		return json.NewEncoder(out).Encode(result)
+	}
+	if result.Spooled {
+		_, err := fmt.Fprintf(out, "Record for topic %s has been spooled\n", result.Topic)
+		return err
+	}
+	line := fmt.Sprintf("Record produced topic=%s partition=%d offset=%d", result.Topic, result.Partition, result.Offset)
+	if !result.Timestamp.IsZero() {
+		line += " timestamp=" + result.Timestamp.Format(time.RFC3339Nano)
+	}
+	if result.CloudEventID != "" {
+		line += " cloudevent_id=" + result.CloudEventID
+	}
+	_, err := fmt.Fprintln(out, line)
+	return err
+}
+
+// parseTimestamp parses RFC3339 timestamps with optional fractional seconds or unix epoch millis,
+// an empty string results in a zero time which means "now"
+func parseTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if millis, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(millis), nil
+	}
+	ts, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return ts, fmt.Errorf("%w: invalid timestamp %s, expected RFC3339 or unix epoch millis", errInvalidArgs, s)
+	}
+	return ts, nil
+}
+
+// headerMap converts a list of key=value flags into a map, entries without = are ignored
+func headerMap(headers []string) map[string]string {
+	hm := make(map[string]string)
+	for _, h := range headers {
+		k, v, found := strings.Cut(h, "=")
+		if !found {
+			continue
+		}
+		hm[k] = v
+	}
+	return hm
+}
@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"flag"
+
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+// rateFlags throttle produce requests, they take precedence over KAFKA_RATE_LIMIT, KAFKA_RATE_LIMIT_BYTES and KAFKA_MAX_IN_FLIGHT
+type rateFlags struct {
+	rate        float64
+	rateBytes   int64
+	maxInFlight int
+}
+
+func (f *rateFlags) register(fs *flag.FlagSet) {
+	fs.Float64Var(&f.rate, "rate", 0, "Max records per second, e.g. to avoid 429 responses during bulk replays (default unlimited)")
+	fs.Int64Var(&f.rateBytes, "rate-bytes", 0, "Max request bytes per second (default unlimited)")
+	fs.IntVar(&f.maxInFlight, "max-in-flight", 0, "Max concurrent produce requests (default unlimited)")
+}
+
+// apply overwrites the limits of options with flags that are set
+func (f *rateFlags) apply(options *rubin.Options) {
+	if f.rate > 0 {
+		options.RateLimit = f.rate
+	}
+	if f.rateBytes > 0 {
+		options.RateLimitBytes = f.rateBytes
+	}
+	if f.maxInFlight > 0 {
+		options.MaxInFlight = f.maxInFlight
+	}
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+func flushSpoolCommand() command {
+	return command{
+		name:        "flush-spool",
+		description: "Re-send records from the spool (KAFKA_SPOOL_DIR) that could not be produced earlier",
+		options:     func() []interface{} { return []interface{}{&rubin.Options{}} },
+		setup: func(a *app, fs *flag.FlagSet) func(ctx context.Context) error {
+			var rate rateFlags
+			rate.register(fs)
+			return func(ctx context.Context) error { return runFlushSpool(ctx, a.out, rate) }
+		},
+	}
+}
+
+func runFlushSpool(ctx context.Context, out io.Writer, rate rateFlags) error {
+	options, err := rubin.NewOptionsFromEnv()
+	if err != nil {
+		return err
+	}
+	rate.apply(options)
+	if options.SpoolDir == "" {
+		return fmt.Errorf("%w: spool is disabled, set KAFKA_SPOOL_DIR", errInvalidArgs)
+	}
+	spool, err := rubin.NewSpool(rubin.SpoolOptions{Dir: options.SpoolDir, MaxSize: options.SpoolMaxSize})
+	if err != nil {
+		return err
+	}
+	client := rubin.NewClient(options)
+	stats, err := spool.Replay(ctx, func(ctx context.Context, request rubin.RecordRequest) error {
+		_, err := client.Produce(ctx, request)
+		return err
+	})
+	_, _ = fmt.Fprintf(out, "Spool %s: sent=%d remaining=%d corrupted=%d\n", options.SpoolDir, stats.Sent, stats.Remaining, stats.Corrupted)
+	return err
+}
+
+// spoolRecord persists a record that failed with a temporary error, so it can be re-sent later with flush-spool.
+// The produce command succeeds if the record has been spooled, since it's not lost
+func spoolRecord(ctx context.Context, options *rubin.Options, request rubin.RecordRequest, produceErr error) error {
+	spool, err := rubin.NewSpool(rubin.SpoolOptions{Dir: options.SpoolDir, MaxSize: options.SpoolMaxSize})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = spool.Close() }()
+	if err := spool.Append(request); err != nil {
+		return fmt.Errorf("%w (spool failed: %w)", produceErr, err)
+	}
+	log.Ctx(ctx).Warn().Msgf("Record could not be produced (%v) and has been spooled to %s, use 'rubin flush-spool' to re-send it",
+		produceErr, options.SpoolDir)
+	return nil
+}
@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+)
+
+func TestProduceSpoolAndFlush(t *testing.T) {
+	t.Setenv("KAFKA_SPOOL_DIR", t.TempDir())
+	t.Setenv("KAFKA_REST_ENDPOINT", "http://127.0.0.1:1") // unreachable
+	t.Setenv("KAFKA_CLUSTER_ID", testutil.ClusterID)
+	t.Setenv("KAFKA_PRODUCER_API_KEY", "hase")
+	t.Setenv("KAFKA_PRODUCER_API_SECRET", "friedrich")
+	var out bytes.Buffer
+	err := newApp(BuildInfo{}, &out).run(context.Background(), []string{"produce", "-topic", testutil.Topic(200), "-record", "spool me", "-output", "json"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), `"spooled":true`)
+
+	out.Reset()
+	err = newApp(BuildInfo{}, &out).run(context.Background(), []string{"flush-spool"})
+	assert.Error(t, err)
+	assert.Contains(t, out.String(), "sent=0 remaining=1")
+
+	mock := testutil.ServerMock()
+	defer mock.Close()
+	t.Setenv("KAFKA_REST_ENDPOINT", mock.URL)
+	out.Reset()
+	assert.NoError(t, newApp(BuildInfo{}, &out).run(context.Background(), []string{"flush-spool"}))
+	assert.Contains(t, out.String(), "sent=1 remaining=0")
+
+	t.Setenv("KAFKA_SPOOL_DIR", "")
+	assert.ErrorContains(t, newApp(BuildInfo{}, &out).run(context.Background(), []string{"flush-spool"}), "spool is disabled")
+}
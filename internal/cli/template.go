@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// jsonTemplateSuffix marks templates whose output must be valid JSON
+const jsonTemplateSuffix = ".json.tmpl"
+
+// templateData is passed to payload templates, e.g. {{ .Vars.version }} or {{ .Git.Commit }}
+type templateData struct {
+	Vars map[string]string
+	Git  gitInfo
+}
+
+// gitInfo is resolved from environment variables set by common CI systems (GitHub Actions, GitLab CI)
+type gitInfo struct {
+	Commit     string
+	Branch     string
+	Repository string
+	Actor      string
+}
+
+func gitInfoFromEnv() gitInfo {
+	return gitInfo{
+		Commit:     cmp.Or(os.Getenv("GITHUB_SHA"), os.Getenv("CI_COMMIT_SHA"), os.Getenv("GIT_COMMIT")),
+		Branch:     cmp.Or(os.Getenv("GITHUB_REF_NAME"), os.Getenv("CI_COMMIT_REF_NAME"), os.Getenv("GIT_BRANCH")),
+		Repository: cmp.Or(os.Getenv("GITHUB_REPOSITORY"), os.Getenv("CI_PROJECT_PATH")),
+		Actor:      cmp.Or(os.Getenv("GITHUB_ACTOR"), os.Getenv("GITLAB_USER_LOGIN")),
+	}
+}
+
+// templateFuncs are the helpers available in payload templates
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// env returns the value of the environment variable, or the optional default if it's empty
+		"env": func(name string, def ...string) string {
+			return cmp.Or(append([]string{os.Getenv(name)}, def...)...)
+		},
+		// now returns the current UTC time formatted as RFC3339 with millis, or using the optional Go layout
+		"now": func(layout ...string) string {
+			return time.Now().UTC().Format(cmp.Or(append(layout, "2006-01-02T15:04:05.000Z07:00")...))
+		},
+		"uuid": func() string { return uuid.New().String() },
+		// json encodes the value as JSON, for strings this results in a quoted and properly escaped value
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+}
+
+// renderTemplate renders the payload template file with the given key=value variables. The output of
+// templates with suffix .json.tmpl is validated, references to undefined variables are reported as error
+func renderTemplate(file string, vars []string) (string, error) {
+	data := templateData{Vars: map[string]string{}, Git: gitInfoFromEnv()}
+	for _, v := range vars {
+		key, value, found := strings.Cut(v, "=")
+		if !found || key == "" {
+			return "", fmt.Errorf("%w: invalid template variable %s, expected key=value", errInvalidArgs, v)
+		}
+		data.Vars[key] = value
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("%w: cannot read template: %w", errInvalidArgs, err)
+	}
+	tmpl, err := template.New(filepath.Base(file)).Funcs(templateFuncs()).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errInvalidArgs, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("%w: %w", errInvalidArgs, err)
+	}
+	if strings.HasSuffix(file, jsonTemplateSuffix) && !json.Valid(buf.Bytes()) {
+		return "", fmt.Errorf("%w: output of template %s is not valid JSON: %s", errInvalidArgs, file, buf.String())
+	}
+	return buf.String(), nil
+}
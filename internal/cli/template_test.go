@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/pkg/rubintest"
+)
+
+func writeTemplate(t *testing.T, name string, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	return file
+}
+
+func TestRenderTemplate(t *testing.T) {
+	t.Setenv("GITHUB_SHA", "abc123")
+	t.Setenv("DEPLOY_ENV", "prod")
+	file := writeTemplate(t, "deploy.json.tmpl", `{"msg": {{ .Vars.msg | json }}, "env": "{{ env "DEPLOY_ENV" }}", "region": "{{ env "REGION" "eu" }}",
+ "commit": "{{ .Git.Commit }}", "id": "{{ uuid }}", "at": "{{ now }}", "year": "{{ now "2006" }}"}`)
+
+	out, err := renderTemplate(file, []string{`msg=say "hello"`})
+	assert.NoError(t, err)
+	var payload map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(out), &payload))
+	assert.Equal(t, `say "hello"`, payload["msg"])
+	assert.Equal(t, "prod", payload["env"])
+	assert.Equal(t, "eu", payload["region"])
+	assert.Equal(t, "abc123", payload["commit"])
+	assert.Len(t, payload["id"], 36)
+	assert.Len(t, payload["year"], 4)
+
+	_, err = renderTemplate(file, nil)
+	assert.ErrorContains(t, err, "msg", "undefined variables are reported")
+	_, err = renderTemplate(file, []string{"novalue"})
+	assert.ErrorIs(t, err, errInvalidArgs)
+
+	invalid := writeTemplate(t, "broken.json.tmpl", `{"msg": {{ .Vars.msg }}}`)
+	_, err = renderTemplate(invalid, []string{"msg=unquoted"})
+	assert.ErrorContains(t, err, "not valid JSON")
+	text := writeTemplate(t, "plain.tmpl", `Hello {{ .Vars.msg }}`)
+	out, err = renderTemplate(text, []string{"msg=unquoted"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello unquoted", out)
+	_, err = renderTemplate(filepath.Join(t.TempDir(), "missing.tmpl"), nil)
+	assert.ErrorIs(t, err, errInvalidArgs)
+}
+
+func TestProduceTemplate(t *testing.T) {
+	srv := rubintest.NewServer(rubintest.ServerOptions{})
+	defer srv.Close()
+	t.Setenv("KAFKA_REST_ENDPOINT", srv.URL)
+	t.Setenv("KAFKA_CLUSTER_ID", srv.Options().ClusterID)
+	t.Setenv("KAFKA_PRODUCER_API_KEY", srv.Options().ProducerAPIKey)
+	t.Setenv("KAFKA_PRODUCER_API_SECRET", srv.Options().ProducerAPISecret)
+	file := writeTemplate(t, "event.json.tmpl", `{"version": "{{ .Vars.version }}"}`)
+
+	args := []string{"produce", "-topic", "public.hello", "-template", file, "-var", "version=1.2.3", "-output", "quiet"}
+	assert.NoError(t, newApp(BuildInfo{}, &bytes.Buffer{}).run(context.Background(), args))
+	records := srv.Records("public.hello")
+	assert.Len(t, records, 1)
+	assert.Equal(t, "JSON", records[0].ValueType)
+	assert.JSONEq(t, `{"version": "1.2.3"}`, string(records[0].Value))
+
+	err := newApp(BuildInfo{}, &bytes.Buffer{}).run(context.Background(), append(args, "-record", "both"))
+	assert.ErrorIs(t, err, errInvalidArgs)
+}
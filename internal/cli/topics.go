@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/confluentinc/kafka-rest-sdk-go/kafkarestv3"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+const (
+	topicsList     = "list"
+	topicsDescribe = "describe"
+	topicsCreate   = "create"
+	topicsDelete   = "delete"
+)
+
+type topicsFlags struct {
+	configs           arrayFlags
+	partitions        int
+	replicationFactor int
+	yes               bool
+}
+
+func topicsCommand() command {
+	return command{
+		name:        "topics",
+		description: "Manage topics via REST Proxy, usage: topics [list | describe <topic> | create <topic> | delete <topic>]",
+		options:     func() []interface{} { return []interface{}{&rubin.Options{}} },
+		args:        []string{topicsList, topicsDescribe, topicsCreate, topicsDelete},
+		setup: func(a *app, fs *flag.FlagSet) func(ctx context.Context) error {
+			var f topicsFlags
+			fs.Var(&f.configs, "config", "Topic config formatted as name=value for create e.g. retention.ms=86400000, can be used multiple times")
+			fs.IntVar(&f.partitions, "partitions", 0, "Number of partitions for create, zero means cluster default")
+			fs.IntVar(&f.replicationFactor, "replication-factor", 0, "Replication factor for create, zero means cluster default")
+			fs.BoolVar(&f.yes, "yes", false, "Confirm delete, topics are only deleted if this flag is set")
+			return func(ctx context.Context) error {
+				args, err := parseInterspersed(fs)
+				if err != nil {
+					return err
+				}
+				client, err := rubin.NewClientFromEnv()
+				if err != nil {
+					return err
+				}
+				return runTopics(ctx, a.out, client, f, args)
+			}
+		},
+	}
+}
+
+func runTopics(ctx context.Context, out io.Writer, client *rubin.Client, f topicsFlags, args []string) error {
+	action := topicsList
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if action == topicsList {
+		topics, err := client.ListTopics(ctx)
+		if err != nil {
+			return err
+		}
+		return printTopics(out, topics)
+	}
+	actionAndTopic := 2 // golangci treats 2 as a magic number
+	if len(args) != actionAndTopic {
+		return fmt.Errorf("%w: usage: topics %s <topic>", errInvalidArgs, action)
+	}
+	topic := args[1]
+	switch action {
+	case topicsDescribe:
+		return describeTopic(ctx, out, client, topic)
+	case topicsCreate:
+		topicData, err := client.CreateTopic(ctx, rubin.TopicSpec{
+			Name:              topic,
+			PartitionsCount:   int32(f.partitions),        // #nosec G115 -- partition counts are small
+			ReplicationFactor: int32(f.replicationFactor), // #nosec G115
+			Configs:           headerMap(f.configs),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "Topic %s created\n", topicData.TopicName)
+		return err
+	case topicsDelete:
+		if !f.yes {
+			return fmt.Errorf("%w: deleting topic %s cannot be undone, add -yes to confirm", errInvalidArgs, topic)
+		}
+		if err := client.DeleteTopic(ctx, topic); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(out, "Topic %s deleted\n", topic)
+		return err
+	default:
+		return fmt.Errorf("%w: unknown topics action %s, expected one of %s", errInvalidArgs, action,
+			strings.Join([]string{topicsList, topicsDescribe, topicsCreate, topicsDelete}, ", "))
+	}
+}
+
+func describeTopic(ctx context.Context, out io.Writer, client *rubin.Client, topic string) error {
+	topicData, err := client.DescribeTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+	configs, err := client.ListTopicConfigs(ctx, topic)
+	if err != nil {
+		return err
+	}
+	if err := printTopics(out, []kafkarestv3.TopicData{topicData}); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(out)
+	tabs := newTabWriter(out)
+	_, _ = fmt.Fprintln(tabs, "CONFIG\tVALUE\tSOURCE")
+	for _, c := range configs {
+		value := ""
+		switch {
+		case c.IsSensitive:
+			value = "********"
+		case c.Value != nil:
+			value = *c.Value
+		}
+		_, _ = fmt.Fprintf(tabs, "%s\t%s\t%s\n", c.Name, value, c.Source)
+	}
+	return tabs.Flush()
+}
+
+func printTopics(out io.Writer, topics []kafkarestv3.TopicData) error {
+	tabs := newTabWriter(out)
+	_, _ = fmt.Fprintln(tabs, "TOPIC\tPARTITIONS\tREPLICATION\tINTERNAL")
+	for _, t := range topics {
+		_, _ = fmt.Fprintf(tabs, "%s\t%d\t%d\t%v\n", t.TopicName, t.PartitionsCount, t.ReplicationFactor, t.IsInternal)
+	}
+	return tabs.Flush()
+}
+
+func newTabWriter(out io.Writer) *tabwriter.Writer {
+	tabPadding := 2
+	return tabwriter.NewWriter(out, 1, 0, tabPadding, ' ', 0)
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicsCommand(t *testing.T) {
+	setupMock(t)
+	for _, tc := range []struct {
+		args     []string
+		expected string
+		err      string
+	}{
+		{args: []string{"topics"}, expected: "public.welcome"},
+		{args: []string{"topics", "describe", "public.hello"}, expected: "retention.ms      86400000  DYNAMIC_TOPIC_CONFIG"},
+		{args: []string{"topics", "create", "public.hello", "-partitions", "6", "-config", "retention.ms=1000"}, expected: "Topic public.hello created"},
+		{args: []string{"topics", "delete", "public.hello"}, err: "add -yes to confirm"},
+		{args: []string{"topics", "delete", "-yes", "public.hello"}, expected: "Topic public.hello deleted"},
+		{args: []string{"topics", "describe"}, err: "usage: topics describe <topic>"},
+		{args: []string{"topics", "rename", "public.hello"}, err: "unknown topics action"},
+	} {
+		var out bytes.Buffer
+		err := newApp(BuildInfo{}, &out).run(context.Background(), tc.args)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, tc.args)
+			continue
+		}
+		assert.NoError(t, err, tc.args)
+		assert.Contains(t, out.String(), tc.expected, tc.args)
+	}
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+type validateFlags struct {
+	record   string
+	schema   string
+	template string
+	vars     arrayFlags
+}
+
+func validateCommand() command {
+	return command{
+		name:        "validate",
+		description: "Validate a payload against a JSON Schema without producing it, e.g. validate -schema event.json -record '{...}'",
+		setup: func(a *app, fs *flag.FlagSet) func(ctx context.Context) error {
+			var f validateFlags
+			fs.StringVar(&f.record, "record", "", "Payload to validate")
+			fs.StringVar(&f.schema, "schema", "", "JSON Schema file or http(s) URL (required)")
+			fs.StringVar(&f.template, "template", "", "Render the payload from a Go text/template file instead of -record")
+			fs.Var(&f.vars, "var", "Template variable formatted as key=value, can be used multiple times")
+			return func(ctx context.Context) error { return runValidate(ctx, a.out, f) }
+		},
+	}
+}
+
+func runValidate(ctx context.Context, out io.Writer, f validateFlags) error {
+	if f.schema == "" {
+		return fmt.Errorf("%w: -schema is required", errInvalidArgs)
+	}
+	record, err := payload(f.record, f.template, f.vars)
+	if err != nil {
+		return err
+	}
+	schema, err := rubin.NewSchemaValidator().Schema(ctx, f.schema)
+	if err != nil {
+		return err
+	}
+	err = schema.Validate(record)
+	var validationErr *rubin.SchemaValidationError
+	if errors.As(err, &validationErr) {
+		for _, v := range validationErr.Violations {
+			_, _ = fmt.Fprintln(out, v.String())
+		}
+		return fmt.Errorf("%w %s: %d violation(s)", rubin.ErrSchemaValidation, f.schema, len(validationErr.Violations))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "Payload is valid according to %s\n", f.schema)
+	return err
+}
+
+// payload returns the record, or the rendered template if set. Both are mutually exclusive, and the result must not be empty
+func payload(record string, template string, vars []string) (string, error) {
+	if template != "" {
+		if record != "" {
+			return "", fmt.Errorf("%w: -record and -template are mutually exclusive", errInvalidArgs)
+		}
+		var err error
+		if record, err = renderTemplate(template, vars); err != nil {
+			return "", err
+		}
+	}
+	if strings.TrimSpace(record) == "" {
+		return "", fmt.Errorf("%w: message record must not be empty", errClient)
+	}
+	return record, nil
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+const deploymentSchema = testutil.TestDataDir + "/schema-deployment.json"
+
+func TestValidate(t *testing.T) {
+	var out bytes.Buffer
+	ctx := context.Background()
+	err := newApp(BuildInfo{}, &out).run(ctx, []string{"validate", "-schema", deploymentSchema, "-record", `{"app": "api", "version": "1.0.0"}`})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Payload is valid")
+
+	out.Reset()
+	err = newApp(BuildInfo{}, &out).run(ctx, []string{"validate", "-schema", deploymentSchema, "-record", `{"version": "latest"}`})
+	assert.ErrorIs(t, err, rubin.ErrSchemaValidation)
+	assert.Contains(t, out.String(), "/app: required property is missing")
+	assert.Contains(t, out.String(), "/version: value must match pattern")
+
+	file := writeTemplate(t, "deploy.json.tmpl", `{"app": "{{ .Vars.app }}", "version": "1.0.0"}`)
+	err = newApp(BuildInfo{}, &out).run(ctx, []string{"validate", "-schema", deploymentSchema, "-template", file, "-var", "app=api"})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, newApp(BuildInfo{}, &out).run(ctx, []string{"validate", "-record", "{}"}), errInvalidArgs)
+}
+
+func TestProduceSchema(t *testing.T) {
+	setupMock(t)
+	err := newApp(BuildInfo{}, &bytes.Buffer{}).run(context.Background(), []string{"produce", "-topic", testutil.Topic(200),
+		"-schema", deploymentSchema, "-record", `{"app": "api"}`})
+	assert.ErrorIs(t, err, rubin.ErrSchemaValidation)
+}
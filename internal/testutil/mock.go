@@ -1,6 +1,7 @@
 package testutil
 
 import (
+	"encoding/pem"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +15,22 @@ const (
 )
 
 func ServerMock() *httptest.Server {
+	return httptest.NewServer(mockMux())
+}
+
+// TLSServerMock same as ServerMock but serves https using a self-signed certificate,
+// use WriteCertPEM to make the certificate available as CA file
+func TLSServerMock() *httptest.Server {
+	return httptest.NewTLSServer(mockMux())
+}
+
+// WriteCertPEM writes the PEM encoded server certificate of a TLS mock to file (e.g. to be used as CA file)
+func WriteCertPEM(srv *httptest.Server, file string) error {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	return os.WriteFile(file, certPEM, 0o600)
+}
+
+func mockMux() *http.ServeMux {
 	handler := http.NewServeMux()
 	// http.StatusUnauthorized /* 401 */ is html !!!
 	for _, code := range []int{http.StatusOK, http.StatusBadRequest /*400*/, http.StatusForbidden /*403*/} {
@@ -22,8 +39,25 @@ func ServerMock() *httptest.Server {
 			mockHandler(fmt.Sprintf("%s/response-%d.json", TestDataDir, code)),
 		)
 	}
-	srv := httptest.NewServer(handler)
-	return srv
+	// admin api, only a single topic (public.hello) and consumer group (app.consumer) are known
+	clusterPath := "/kafka/v3/clusters/" + ClusterID
+	for pattern, responseFile := range map[string]string{
+		"GET /kafka/v3/clusters":                                           "clusters-200.json",
+		"GET " + clusterPath:                                               "cluster-200.json",
+		"GET " + clusterPath + "/topics":                                   "topics-200.json",
+		"POST " + clusterPath + "/topics":                                  "topic-200.json",
+		"GET " + clusterPath + "/topics/" + topicPrefix:                    "topic-200.json",
+		"GET " + clusterPath + "/topics/" + topicPrefix + "/configs":       "topic-configs-200.json",
+		"GET " + clusterPath + "/consumer-groups":                          "consumer-groups-200.json",
+		"GET " + clusterPath + "/consumer-groups/app.consumer/lag-summary": "lag-summary-200.json",
+		"GET " + clusterPath + "/consumer-groups/app.consumer/lags":        "lags-200.json",
+	} {
+		handler.HandleFunc(pattern, mockHandler(TestDataDir+"/"+responseFile))
+	}
+	handler.HandleFunc("DELETE "+clusterPath+"/topics/"+topicPrefix, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return handler
 }
 
 // Topic expects a response file testdata/response-<statuscode>
@@ -9,13 +9,19 @@ import (
 	"github.com/kelseyhightower/envconfig"
 )
 
-func ShowHelp(envconfigPrefix string, optionsSpec interface{}) {
-	usagePadding := 4
-	tabs := tabwriter.NewWriter(os.Stdout, 1, 0, usagePadding, ' ', 0)
-	_ = envconfig.Usagef(envconfigPrefix, optionsSpec, tabs, envconfig.DefaultTableFormat)
-	_ = tabs.Flush()
+// ShowHelp prints the environment configuration table for each of the optionsSpecs
+// followed by the defaults of the given flag set
+func ShowHelp(envconfigPrefix string, flags *flag.FlagSet, optionsSpecs ...interface{}) {
+	for _, optionsSpec := range optionsSpecs {
+		usagePadding := 4
+		tabs := tabwriter.NewWriter(os.Stdout, 1, 0, usagePadding, ' ', 0)
+		_ = envconfig.Usagef(envconfigPrefix, optionsSpec, tabs, envconfig.DefaultTableFormat)
+		_ = tabs.Flush()
+		fmt.Println()
+	}
 	// use below approach instead of flag.Usage() for customized output: https://stackoverflow.com/a/23726033/4292075
-	fmt.Println("\nIn addition, the following CLI arguments are supported")
-	flag.PrintDefaults()
+	fmt.Println("In addition, the following CLI arguments are supported")
+	flags.SetOutput(os.Stdout)
+	flags.PrintDefaults()
 	fmt.Println()
 }
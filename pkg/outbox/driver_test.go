@@ -0,0 +1,174 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeDB is an in-memory stand-in for the outbox table, it implements the database/sql driver interfaces
+// and understands exactly the statements issued by Outbox and Relay (sqlite dialect), so no database is needed
+type fakeDB struct {
+	mu     sync.Mutex
+	rows   []fakeRow
+	nextID int64
+	// snapshot is restored on rollback
+	snapshot []fakeRow
+	// failUpdate is returned by statements that record a failed attempt
+	failUpdate error
+}
+
+type fakeRow struct {
+	id            int64
+	topic         string
+	record        string
+	createdAt     time.Time
+	attempts      int64
+	nextAttemptAt time.Time
+	lastError     string
+	sentAt        *time.Time
+}
+
+var errFakeQuery = errors.New("unsupported fake query")
+
+func openFakeDB() (*sql.DB, *fakeDB) {
+	f := &fakeDB{}
+	db := sql.OpenDB(f)
+	db.SetMaxOpenConns(1) // the fake supports only one transaction at a time
+	return db, f
+}
+
+// row returns a copy of the row with the given id
+func (f *fakeDB) row(id int64) fakeRow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.rows {
+		if r.id == id {
+			return r
+		}
+	}
+	return fakeRow{}
+}
+
+func (f *fakeDB) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.rows)
+}
+
+// driver.Connector, driver.Driver, driver.Conn and driver.Tx are all implemented by fakeDB
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return f }
+func (f *fakeDB) Open(string) (driver.Conn, error)             { return f, nil }
+func (f *fakeDB) Close() error                                 { return nil }
+func (f *fakeDB) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: f, query: query}, nil
+}
+
+func (f *fakeDB) Begin() (driver.Tx, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.snapshot = slices.Clone(f.rows)
+	return f, nil
+}
+
+func (f *fakeDB) Commit() error { return nil }
+
+func (f *fakeDB) Rollback() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rows = f.snapshot
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	f := s.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "INSERT"):
+		f.nextID++
+		f.rows = append(f.rows, fakeRow{id: f.nextID, topic: args[0].(string), record: args[1].(string),
+			createdAt: args[2].(time.Time), nextAttemptAt: args[3].(time.Time)})
+		return driver.RowsAffected(1), nil
+	case strings.Contains(s.query, "SET sent_at"):
+		return f.update(args[1].(int64), func(r *fakeRow) {
+			sentAt := args[0].(time.Time)
+			r.sentAt = &sentAt
+			r.attempts++
+		}), nil
+	case strings.Contains(s.query, "SET attempts") && f.failUpdate != nil:
+		return nil, f.failUpdate
+	case strings.Contains(s.query, "SET attempts"):
+		return f.update(args[3].(int64), func(r *fakeRow) {
+			r.attempts, r.lastError, r.nextAttemptAt = args[0].(int64), args[1].(string), args[2].(time.Time)
+		}), nil
+	case strings.HasPrefix(s.query, "DELETE"):
+		before := args[0].(time.Time)
+		n := len(f.rows)
+		f.rows = slices.DeleteFunc(f.rows, func(r fakeRow) bool { return r.sentAt != nil && r.sentAt.Before(before) })
+		return driver.RowsAffected(n - len(f.rows)), nil
+	}
+	return nil, fmt.Errorf("%w: %s", errFakeQuery, s.query)
+}
+
+func (f *fakeDB) update(id int64, fn func(r *fakeRow)) driver.Result {
+	for i := range f.rows {
+		if f.rows[i].id == id {
+			fn(&f.rows[i])
+			return driver.RowsAffected(1)
+		}
+	}
+	return driver.RowsAffected(0)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if !strings.HasPrefix(s.query, "SELECT") {
+		return nil, fmt.Errorf("%w: %s", errFakeQuery, s.query)
+	}
+	maxAttempts, now, limit := args[0].(int64), args[1].(time.Time), args[2].(int64)
+	f := s.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := &fakeRows{}
+	for _, r := range f.rows { // rows are sorted by id
+		if r.sentAt == nil && r.attempts < maxAttempts && !r.nextAttemptAt.After(now) && int64(len(result.values)) < limit {
+			result.values = append(result.values, []driver.Value{r.id, r.record, r.attempts})
+		}
+	}
+	return result, nil
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"id", "record", "attempts"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,149 @@
+// Package outbox implements the transactional outbox pattern for services using database/sql: records are stored
+// in an outbox table within the caller's transaction, so they are only produced if the business data is committed.
+// A Relay polls the table and produces the records via rubin, with retry bookkeeping for failed attempts.
+//
+// The outbox table can be created with Outbox.CreateTable or by your schema migration tool (see Outbox.Schema)
+package outbox
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+// Dialects define the placeholder style and DDL of the database
+const (
+	// DialectPostgres uses $1, $2 ... placeholders and locks polled rows with FOR UPDATE SKIP LOCKED,
+	// so multiple relays can poll the same table
+	DialectPostgres = "postgres"
+	// DialectSQLite uses ? placeholders, only a single relay should poll the table
+	DialectSQLite = "sqlite"
+	// DialectMySQL uses ? placeholders and locks polled rows with FOR UPDATE SKIP LOCKED (MySQL 8+)
+	DialectMySQL = "mysql"
+)
+
+const defaultTable = "rubin_outbox"
+
+var (
+	errInvalidOptions = errors.New("invalid outbox options")
+	// tableNameRegexp restricts table names since they are part of the SQL statements (optionally schema qualified)
+	tableNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+)
+
+// Options configure table name and dialect of the Outbox, zero values are replaced by defaults
+type Options struct {
+	// Table name of the outbox table (default rubin_outbox)
+	Table string
+	// Dialect is one of DialectPostgres (default), DialectSQLite or DialectMySQL
+	Dialect string
+}
+
+// Execer is implemented by *sql.Tx, *sql.DB and *sql.Conn. Pass the transaction that also writes the business data
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Outbox stores records in the outbox table
+type Outbox struct {
+	options Options
+}
+
+// New returns an Outbox for the given options
+func New(options Options) (*Outbox, error) {
+	options.Table = cmp.Or(options.Table, defaultTable)
+	options.Dialect = cmp.Or(options.Dialect, DialectPostgres)
+	if !tableNameRegexp.MatchString(options.Table) {
+		return nil, fmt.Errorf("%w: invalid table name %s", errInvalidOptions, options.Table)
+	}
+	if options.Dialect != DialectPostgres && options.Dialect != DialectSQLite && options.Dialect != DialectMySQL {
+		return nil, fmt.Errorf("%w: unsupported dialect %s", errInvalidOptions, options.Dialect)
+	}
+	return &Outbox{options: options}, nil
+}
+
+// Schema returns the DDL to create the outbox table for the dialect, e.g. to add it to your migrations
+func (o *Outbox) Schema() string {
+	id := "BIGSERIAL PRIMARY KEY"
+	ts := "TIMESTAMPTZ"
+	switch o.options.Dialect {
+	case DialectSQLite:
+		id, ts = "INTEGER PRIMARY KEY AUTOINCREMENT", "TIMESTAMP"
+	case DialectMySQL:
+		id, ts = "BIGINT AUTO_INCREMENT PRIMARY KEY", "TIMESTAMP(3)"
+	}
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  id %s,
+  topic VARCHAR(255) NOT NULL,
+  record TEXT NOT NULL,
+  created_at %s NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at %s NOT NULL,
+  last_error TEXT,
+  sent_at %s NULL
+)`, o.options.Table, id, ts, ts, ts)
+}
+
+// CreateTable creates the outbox table if it doesn't exist
+func (o *Outbox) CreateTable(ctx context.Context, db Execer) error {
+	_, err := db.ExecContext(ctx, o.Schema())
+	return err
+}
+
+// Add stores the record in the outbox table using the caller's transaction. If the record has no timestamp,
+// the current time is used, so the event time is retained even if the record is produced later by the Relay
+func (o *Outbox) Add(ctx context.Context, tx Execer, request rubin.RecordRequest) error {
+	now := time.Now()
+	request.Timestamp = cmp.Or(request.Timestamp, now)
+	record, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("cannot marshal outbox record: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, o.query("INSERT INTO %s (topic, record, created_at, attempts, next_attempt_at) VALUES (?, ?, ?, 0, ?)"),
+		request.Topic, string(record), now, now)
+	return err
+}
+
+// DeleteSent removes records that have been sent before the given time, e.g. to run as periodic cleanup job
+func (o *Outbox) DeleteSent(ctx context.Context, db Execer, before time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, o.query("DELETE FROM %s WHERE sent_at IS NOT NULL AND sent_at < ?"), before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// query inserts the table name and converts ? placeholders to the dialect
+func (o *Outbox) query(format string) string {
+	q := fmt.Sprintf(format, o.options.Table)
+	if o.options.Dialect != DialectPostgres {
+		return q
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// lockClause is appended to the poll query to lock rows for the duration of the relay transaction
+func (o *Outbox) lockClause() string {
+	if o.options.Dialect == DialectSQLite {
+		return ""
+	}
+	return " FOR UPDATE SKIP LOCKED"
+}
@@ -0,0 +1,89 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+func TestNewDefaultsAndValidation(t *testing.T) {
+	o, err := New(Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "rubin_outbox", o.options.Table)
+	assert.Equal(t, DialectPostgres, o.options.Dialect)
+	assert.Contains(t, o.Schema(), "BIGSERIAL")
+
+	_, err = New(Options{Table: "outbox; DROP TABLE users"})
+	assert.ErrorIs(t, err, errInvalidOptions)
+	_, err = New(Options{Dialect: "oracle"})
+	assert.ErrorIs(t, err, errInvalidOptions)
+	_, err = New(Options{Table: "events.outbox", Dialect: DialectMySQL})
+	assert.NoError(t, err)
+}
+
+func TestQueryPlaceholders(t *testing.T) {
+	pg, _ := New(Options{Table: "ob"})
+	assert.Equal(t, "UPDATE ob SET a = $1 WHERE id = $2", pg.query("UPDATE %s SET a = ? WHERE id = ?"))
+	assert.Equal(t, " FOR UPDATE SKIP LOCKED", pg.lockClause())
+	sqlite, _ := New(Options{Table: "ob", Dialect: DialectSQLite})
+	assert.Equal(t, "UPDATE ob SET a = ? WHERE id = ?", sqlite.query("UPDATE %s SET a = ? WHERE id = ?"))
+	assert.Empty(t, sqlite.lockClause())
+	assert.Contains(t, sqlite.Schema(), "AUTOINCREMENT")
+}
+
+func TestAddWithinTransaction(t *testing.T) {
+	ctx := context.Background()
+	db, fake := openFakeDB()
+	o, _ := New(Options{Dialect: DialectSQLite})
+	assert.NoError(t, o.CreateTable(ctx, db))
+
+	// rolled back business transaction must not leave a record behind
+	tx, err := db.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, o.Add(ctx, tx, rubin.RecordRequest{Topic: "public.hello", Data: "discarded"}))
+	assert.NoError(t, tx.Rollback())
+	assert.Equal(t, 0, fake.len())
+
+	tx, _ = db.BeginTx(ctx, nil)
+	partition := int32(2)
+	assert.NoError(t, o.Add(ctx, tx, rubin.RecordRequest{
+		Topic: "public.hello", Data: map[string]interface{}{"action": "order/created"}, Key: "4711",
+		Headers: map[string]string{"trace": "abc"}, Partition: &partition,
+		AsCloudEvent: true, Source: "//order-service", Type: "order.created", Subject: "4711",
+	}))
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, 1, fake.len())
+
+	row := fake.row(2) // like database sequences, ids are not reused after rollback
+	assert.Equal(t, "public.hello", row.topic)
+	assert.Zero(t, row.attempts)
+	assert.Nil(t, row.sentAt)
+	var stored rubin.RecordRequest
+	assert.NoError(t, json.Unmarshal([]byte(row.record), &stored))
+	assert.Equal(t, "order.created", stored.Type)
+	assert.Equal(t, "//order-service", stored.Source)
+	assert.True(t, stored.AsCloudEvent)
+	assert.Equal(t, int32(2), *stored.Partition)
+	assert.Equal(t, map[string]interface{}{"action": "order/created"}, stored.Data)
+	assert.WithinDuration(t, time.Now(), stored.Timestamp, time.Second, "event time is set when the record is added")
+}
+
+func TestDeleteSent(t *testing.T) {
+	ctx := context.Background()
+	db, fake := openFakeDB()
+	o, _ := New(Options{Dialect: DialectSQLite})
+	for range 2 {
+		assert.NoError(t, o.Add(ctx, db, rubin.RecordRequest{Topic: "public.hello", Data: "hello"}))
+	}
+	sentAt := time.Now().Add(-time.Hour)
+	fake.rows[0].sentAt = &sentAt
+
+	deleted, err := o.DeleteSent(ctx, db, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.Equal(t, 1, fake.len())
+}
@@ -0,0 +1,205 @@
+package outbox
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	defaultBatchSize    = 100
+	defaultMaxAttempts  = 10
+	defaultBackoff      = 1 * time.Second
+	defaultMaxBackoff   = 5 * time.Minute
+	// maxErrorLength limits the error message stored in last_error (bytes)
+	maxErrorLength = 1000
+)
+
+// Producer is implemented by *rubin.Client, it can be replaced e.g. by a wrapper that adds headers
+type Producer interface {
+	Produce(ctx context.Context, request rubin.RecordRequest) (rubin.RecordResponse, error)
+}
+
+// RelayOptions configure polling and retries of the Relay, zero values are replaced by defaults
+type RelayOptions struct {
+	// PollInterval is the time to wait before polling again if no records are pending (default 5s)
+	PollInterval time.Duration
+	// BatchSize is the max number of records fetched and sent within one transaction (default 100)
+	BatchSize int
+	// MaxAttempts is the number of attempts after which a record is given up and remains in the table with
+	// its last error for manual inspection (default 10)
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it's doubled for each further attempt up to MaxBackoff (default 1s / 5m)
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Relay polls the outbox table and produces pending records in the order they were added. Records are marked as
+// sent after the REST Proxy committed them, if the relay crashes in between they are sent again (at-least-once).
+// A failed record is retried with exponential backoff, and the current batch is stopped so the records
+// that follow are not sent before it. Since the failed record is skipped until its next attempt, ordering
+// is best-effort only if retries are involved
+type Relay struct {
+	db       *sql.DB
+	outbox   *Outbox
+	producer Producer
+	options  RelayOptions
+}
+
+// outboxRow is a pending record polled from the outbox table
+type outboxRow struct {
+	id       int64
+	record   string
+	attempts int
+}
+
+// NewRelay returns a Relay that produces the records of the outbox table via producer
+func NewRelay(db *sql.DB, outbox *Outbox, producer Producer, options RelayOptions) *Relay {
+	options.PollInterval = cmp.Or(options.PollInterval, defaultPollInterval)
+	options.BatchSize = cmp.Or(options.BatchSize, defaultBatchSize)
+	options.MaxAttempts = cmp.Or(options.MaxAttempts, defaultMaxAttempts)
+	options.Backoff = cmp.Or(options.Backoff, defaultBackoff)
+	options.MaxBackoff = max(cmp.Or(options.MaxBackoff, defaultMaxBackoff), options.Backoff)
+	return &Relay{db: db, outbox: outbox, producer: producer, options: options}
+}
+
+// Run processes batches until the context is done. If a batch is full, the next one is processed immediately,
+// otherwise the relay waits for PollInterval. Database errors are logged and retried after PollInterval
+func (r *Relay) Run(ctx context.Context) error {
+	logger := log.Ctx(ctx).With().Str("logger", "outbox").Logger()
+	logger.Info().Msgf("Outbox relay started table=%s interval=%v batch=%d", r.outbox.options.Table, r.options.PollInterval, r.options.BatchSize)
+	for {
+		sent, err := r.ProcessBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Error().Err(err).Msg("Outbox relay batch failed")
+		}
+		if err == nil && sent == r.options.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Outbox relay stopped")
+			return nil
+		case <-time.After(r.options.PollInterval):
+		}
+	}
+}
+
+// ProcessBatch sends the pending records of one batch within a transaction, and returns the number of sent records.
+// A failed record is not returned as error but recorded in the table (attempts, last_error, next_attempt_at).
+// The failure is recorded after the sent records have been committed, so an error while updating the failed
+// record can't roll back their sent_at and cause them to be sent again
+func (r *Relay) ProcessBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin outbox transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }() // no-op after commit
+
+	rows, err := r.pending(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	var failed *outboxRow
+	var sendErr error
+	for _, row := range rows {
+		now := time.Now()
+		if sendErr = r.send(ctx, row); sendErr != nil {
+			log.Ctx(ctx).Warn().Str("logger", "outbox").Err(sendErr).Msgf("Outbox record %d failed attempt=%d", row.id, row.attempts+1)
+			failed = &row
+			break
+		}
+		if _, err := tx.ExecContext(ctx, r.outbox.query("UPDATE %s SET sent_at = ?, attempts = attempts + 1 WHERE id = ?"), now, row.id); err != nil {
+			return 0, fmt.Errorf("cannot mark outbox record %d as sent: %w", row.id, err)
+		}
+		sent++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("cannot commit outbox transaction: %w", err)
+	}
+	if failed != nil {
+		// the row is no longer locked, another relay may retry it early which is fine for at-least-once delivery
+		if err := r.markFailed(ctx, *failed, time.Now(), sendErr); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// pending returns the records that are due, oldest first
+func (r *Relay) pending(ctx context.Context, tx *sql.Tx) ([]outboxRow, error) {
+	q := r.outbox.query("SELECT id, record, attempts FROM %s WHERE sent_at IS NULL AND attempts < ? AND next_attempt_at <= ? ORDER BY id LIMIT ?")
+	rows, err := tx.QueryContext(ctx, q+r.outbox.lockClause(), r.options.MaxAttempts, time.Now(), r.options.BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot poll outbox: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	var result []outboxRow
+	for rows.Next() {
+		var row outboxRow
+		if err := rows.Scan(&row.id, &row.record, &row.attempts); err != nil {
+			return nil, fmt.Errorf("cannot scan outbox record: %w", err)
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func (r *Relay) send(ctx context.Context, row outboxRow) error {
+	var request rubin.RecordRequest
+	if err := json.Unmarshal([]byte(row.record), &request); err != nil {
+		return fmt.Errorf("cannot unmarshal outbox record: %w", err)
+	}
+	_, err := r.producer.Produce(ctx, request)
+	return err
+}
+
+// markFailed increments the attempts and schedules the next attempt. Permanent errors (see rubin.IsRetriable)
+// are not retried, attempts is set to MaxAttempts so the record is kept for inspection
+func (r *Relay) markFailed(ctx context.Context, row outboxRow, now time.Time, sendErr error) error {
+	attempts := row.attempts + 1
+	var apiErr *rubin.APIError
+	if errors.As(sendErr, &apiErr) && !rubin.IsRetriable(sendErr) {
+		attempts = max(attempts, r.options.MaxAttempts)
+	}
+	_, err := r.db.ExecContext(ctx, r.outbox.query("UPDATE %s SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?"),
+		attempts, truncateError(sendErr.Error()), now.Add(r.backoff(attempts)), row.id)
+	if err != nil {
+		return fmt.Errorf("cannot update outbox record %d: %w", row.id, err)
+	}
+	return nil
+}
+
+// truncateError limits msg to maxErrorLength bytes without splitting a rune, and removes invalid UTF-8 and
+// NUL bytes which are rejected by Postgres text columns
+func truncateError(msg string) string {
+	msg = strings.ReplaceAll(strings.ToValidUTF8(msg, "?"), "\x00", "")
+	if len(msg) <= maxErrorLength {
+		return msg
+	}
+	cut := maxErrorLength
+	for cut > 0 && !utf8.RuneStart(msg[cut]) {
+		cut--
+	}
+	return msg[:cut]
+}
+
+// backoff returns the delay before the next attempt, i.e. Backoff * 2^(attempts-1) capped at MaxBackoff
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.options.Backoff
+	for i := 1; i < attempts && delay < r.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, r.options.MaxBackoff)
+}
@@ -0,0 +1,153 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+var _ Producer = (*rubin.Client)(nil)
+
+// fakeProducer records produced requests, and fails with the queued errors first
+type fakeProducer struct {
+	mu       sync.Mutex
+	requests []rubin.RecordRequest
+	errs     []error
+}
+
+func (p *fakeProducer) Produce(_ context.Context, request rubin.RecordRequest) (rubin.RecordResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return rubin.RecordResponse{}, err
+	}
+	p.requests = append(p.requests, request)
+	return rubin.RecordResponse{ErrorCode: http.StatusOK}, nil
+}
+
+func (p *fakeProducer) produced() []rubin.RecordRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]rubin.RecordRequest{}, p.requests...)
+}
+
+func setupRelay(t *testing.T, producer Producer, options RelayOptions, records ...string) (*Relay, *fakeDB) {
+	t.Helper()
+	db, fake := openFakeDB()
+	o, err := New(Options{Dialect: DialectSQLite})
+	assert.NoError(t, err)
+	for _, data := range records {
+		assert.NoError(t, o.Add(context.Background(), db, rubin.RecordRequest{Topic: "public.hello", Data: data, AsCloudEvent: true, Type: "hello"}))
+	}
+	return NewRelay(db, o, producer, options), fake
+}
+
+func TestRelayProcessBatch(t *testing.T) {
+	producer := &fakeProducer{}
+	relay, fake := setupRelay(t, producer, RelayOptions{BatchSize: 2}, "one", "two", "three")
+
+	sent, err := relay.ProcessBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	sent, err = relay.ProcessBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	sent, err = relay.ProcessBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	produced := producer.produced()
+	assert.Len(t, produced, 3)
+	assert.Equal(t, "one", produced[0].Data)
+	assert.Equal(t, "three", produced[2].Data)
+	assert.True(t, produced[0].AsCloudEvent)
+	assert.Equal(t, "hello", produced[0].Type)
+	assert.NotNil(t, fake.row(3).sentAt)
+	assert.Equal(t, int64(1), fake.row(3).attempts)
+}
+
+func TestRelayRetryWithBackoff(t *testing.T) {
+	producer := &fakeProducer{errs: []error{&rubin.APIError{StatusCode: http.StatusServiceUnavailable}}}
+	relay, fake := setupRelay(t, producer, RelayOptions{Backoff: 20 * time.Millisecond}, "one", "two")
+
+	sent, err := relay.ProcessBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent, "batch stops at the first failure to retain order")
+	row := fake.row(1)
+	assert.Equal(t, int64(1), row.attempts)
+	assert.Contains(t, row.lastError, "503")
+	assert.True(t, row.nextAttemptAt.After(time.Now()))
+
+	// the failed record is skipped until its next attempt is due
+	sent, _ = relay.ProcessBatch(context.Background())
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, "two", producer.produced()[0].Data)
+
+	time.Sleep(30 * time.Millisecond)
+	sent, _ = relay.ProcessBatch(context.Background())
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, int64(2), fake.row(1).attempts)
+	assert.NotNil(t, fake.row(1).sentAt)
+}
+
+func TestRelayPermanentError(t *testing.T) {
+	producer := &fakeProducer{errs: []error{&rubin.APIError{StatusCode: http.StatusForbidden}}}
+	relay, fake := setupRelay(t, producer, RelayOptions{MaxAttempts: 3, Backoff: time.Millisecond}, "one")
+
+	_, err := relay.ProcessBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), fake.row(1).attempts, "permanent errors are not retried")
+	time.Sleep(5 * time.Millisecond)
+	sent, _ := relay.ProcessBatch(context.Background())
+	assert.Equal(t, 0, sent)
+	assert.Nil(t, fake.row(1).sentAt)
+}
+
+func TestRelayFailedUpdateKeepsSentRecords(t *testing.T) {
+	errUpdate := errors.New("invalid byte sequence for encoding UTF8")
+	producer := &fakeProducer{errs: []error{nil, &rubin.APIError{StatusCode: http.StatusServiceUnavailable}}}
+	relay, fake := setupRelay(t, producer, RelayOptions{}, "one", "two")
+	fake.failUpdate = errUpdate
+
+	sent, err := relay.ProcessBatch(context.Background())
+	assert.ErrorIs(t, err, errUpdate)
+	assert.Equal(t, 1, sent)
+	assert.NotNil(t, fake.row(1).sentAt, "sent records are committed before the failure is recorded")
+	assert.Nil(t, fake.row(2).sentAt)
+}
+
+func TestTruncateError(t *testing.T) {
+	assert.Equal(t, "short", truncateError("short"))
+	assert.Equal(t, "a?b", truncateError("a\xffb\x00"), "invalid UTF-8 and NUL bytes are rejected by Postgres")
+	msg := truncateError(strings.Repeat("a", maxErrorLength-1) + "ä")
+	assert.Len(t, msg, maxErrorLength-1, "multi-byte rune is not split")
+	assert.True(t, utf8.ValidString(msg))
+}
+
+func TestRelayRun(t *testing.T) {
+	producer := &fakeProducer{}
+	relay, _ := setupRelay(t, producer, RelayOptions{PollInterval: 5 * time.Millisecond}, "one")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, relay.Run(ctx))
+	assert.Len(t, producer.produced(), 1)
+}
+
+func TestRelayBackoff(t *testing.T) {
+	relay := NewRelay(nil, nil, nil, RelayOptions{Backoff: time.Second, MaxBackoff: 5 * time.Second})
+	assert.Equal(t, time.Second, relay.backoff(1))
+	assert.Equal(t, 2*time.Second, relay.backoff(2))
+	assert.Equal(t, 4*time.Second, relay.backoff(3))
+	assert.Equal(t, 5*time.Second, relay.backoff(4))
+	assert.Equal(t, 5*time.Second, relay.backoff(100))
+}
@@ -1,12 +1,14 @@
 package polly
 
 import (
+	"cmp"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -25,6 +27,8 @@ import (
 const (
 	defaultDialTimeout      = 5 * time.Second
 	defaultCloseWaitTimeout = 10 * time.Second
+	defaultMaxWait          = 10 * time.Second
+	defaultCommitInterval   = 1 * time.Second
 	minConsumeBytes         = 10
 	maxConsumeBytes         = 10e6 // 10 MB should be enough for everyone :-)
 	// defaultRetentionTime optionally sets the length of time the consumer group will be saved by the broker, Default 24h
@@ -32,9 +36,20 @@ const (
 	defaultRetentionTime = 24 * 7 * time.Hour
 )
 
+// SyncCommits can be used as Options.ConsumerCommitInterval or kafka.ReaderConfig.CommitInterval passed to Poll
+// to commit offsets synchronously, since zero values are replaced by defaults
+const SyncCommits time.Duration = -1
+
 // errInvalidContentType used as static error for Kafka messages with unexpected or no content-type header
 var errInvalidContentType = errors.New("invalid content-type")
 
+var (
+	// ErrClientClosed is returned by Poll if WaitForClose has been called, the Client doesn't accept new consumers
+	ErrClientClosed = errors.New("polly client is closed")
+	// ErrCloseTimeout is wrapped by the error returned from WaitForClose if consumers did not stop within the timeout
+	ErrCloseTimeout = errors.New("consumers did not stop within close timeout")
+)
+
 // HandleMessageFunc consumer will pass received messages to a function that matches this type
 type HandleMessageFunc func(ctx context.Context, message kafka.Message)
 
@@ -44,6 +59,20 @@ type MessageReader interface {
 	Close() error
 }
 
+// ReaderFactory returns the MessageReader used by Poll for the given config, see WithReaderFactory
+type ReaderFactory func(config kafka.ReaderConfig) MessageReader
+
+// ClientOption customizes the Client returned by NewClient
+type ClientOption func(c *Client)
+
+// WithReaderFactory replaces the default kafka.Reader, e.g. by the in-memory reader of package pollytest
+// to unit test HandleMessageFunc pipelines without a Kafka cluster
+func WithReaderFactory(factory ReaderFactory) ClientOption {
+	return func(c *Client) {
+		c.readerFactory = factory
+	}
+}
+
 // defaultMessageReader returns the standard segmentio/kafka-go reader
 func defaultMessageReader(config kafka.ReaderConfig) MessageReader {
 	return kafka.NewReader(config)
@@ -53,9 +82,23 @@ func defaultMessageReader(config kafka.ReaderConfig) MessageReader {
 type Client struct {
 	// logger  *zerolog.Logger
 	options *Options
-	// readerFactory makes it easier to Mock readers as it can be overwritten by Tests
-	readerFactory func(config kafka.ReaderConfig) MessageReader
+	// readerFactory makes it easier to Mock readers as it can be overwritten by Tests, see WithReaderFactory
+	readerFactory ReaderFactory
 	wg            sync.WaitGroup
+	// closers are closed by WaitForClose after all consumers went down, see RegisterCloser
+	closers []io.Closer
+	// mu guards the fields below, Poll registers consumers so WaitForClose can stop them and report those still running
+	mu         sync.Mutex
+	closed     bool
+	consumers  map[int]consumer
+	nextID     int
+	readerErrs []error
+}
+
+// consumer is a running Poll loop, cancel stops reading new messages without canceling in-flight handlers
+type consumer struct {
+	name   string
+	cancel context.CancelFunc
 }
 
 // String representation of the client instance
@@ -63,13 +106,18 @@ func (c *Client) String() string {
 	return fmt.Sprintf("rubin-polly@%s", c.options.String())
 }
 
-func NewClient(options *Options) *Client {
+// NewClient returns a new Client for the given options, opts are applied in order
+func NewClient(options *Options, opts ...ClientOption) *Client {
 	// logger := zerolog.Ctx(context.TODO()) //log.New() // NewAtLevel("debug")
 	c := &Client{
-		options: options,
+		options:   options,
+		consumers: map[int]consumer{},
 		// logger:  logger,
 	}
 	c.readerFactory = defaultMessageReader
+	for _, opt := range opts {
+		opt(c)
+	}
 	// logger.Printf("New Client initialized %s@%s consumerGroupId=%s",
 	//	c.options.ConsumerAPIKey, c.options.BootstrapServers, c.options.ConsumerGroupID)
 	return c
@@ -95,7 +143,9 @@ func (c *Client) Poll(ctx context.Context, rc kafka.ReaderConfig, msgHandler Han
 	// log.SyncSilently(logger)
 	logger := log.Ctx(ctx).With().Str("logger", "poll").Logger()
 	rLogger := log.Ctx(ctx).With().Str("logger", "reader").Logger()
-	c.applyDefaults(&rc)
+	if err := c.applyDefaults(&rc); err != nil {
+		return err
+	}
 	rc.Logger = LoggerWrapper{delegate: &rLogger}
 	rc.ErrorLogger = ErrorLoggerWrapper{delegate: &rLogger}
 
@@ -105,23 +155,28 @@ func (c *Client) Poll(ctx context.Context, rc kafka.ReaderConfig, msgHandler Han
 	}
 	logger.Info().Msgf("Let's consume some yummy Kafka Messages on topic(s)=%s groupID=%s brokers=%v", topics, rc.GroupID, rc.Brokers)
 
+	// readCtx is canceled by WaitForClose to stop reading, handlers still get ctx to finish in-flight messages
+	readCtx, id, err := c.register(ctx, fmt.Sprintf("topic(s)=%s groupID=%s", topics, rc.GroupID))
+	if err != nil {
+		return err
+	}
 	r := c.readerFactory(rc)
 	defer func() {
 		logger.Printf("Post-consume: closing reader stream for topic(s)=%s", topics)
-		if err := r.Close(); err != nil {
-			logger.Warn().Msgf("Error closing reader stream: %v", err)
-		}
-		c.wg.Done() // decrement, WaitForClose() will wait for this group as there may be multiple consumers
+		c.unregister(id, r.Close())
 		logger.Printf("Post-consume: reader for topic(s)=%s ready for shutdown", topics)
 	}()
-	c.wg.Add(1) // add to wait group to ensure graceful shutdown
 
 	var rcvCount int32 // thx https://github.com/cloudevents/sdk-go/blob/main/samples/kafka/sender-receiver/main.go
 	maxReceive := c.options.ConsumerMaxReceive
 	for maxReceive < 0 || atomic.AddInt32(&rcvCount, 1) <= maxReceive {
+		if readCtx.Err() != nil {
+			logger.Printf("Reader-loop: Consumer is shutting down (%v), no new messages are accepted", readCtx.Err())
+			break
+		}
 		// SimpleMessageStream reads and return the next message from the r. The method call
 		// blocks until a message becomes available, or an error occurs.
-		msg, err := r.ReadMessage(ctx)
+		msg, err := r.ReadMessage(readCtx)
 		if err != nil {
 			// handle "errors" as a result of closed context or reader which should be considered expected
 			// and only logged on debug level. other error is considered serious and returned
@@ -145,19 +200,29 @@ func (c *Client) Poll(ctx context.Context, rc kafka.ReaderConfig, msgHandler Han
 }
 
 // applyDefaults updates the kafka.ReaderConfig that is handed over to the poll request with reasonable
-// default values based on client options and context
-func (c *Client) applyDefaults(rc *kafka.ReaderConfig) {
-	dialer := &kafka.Dialer{
-		SASLMechanism: plain.Mechanism{
-			Username: c.options.ConsumerAPIKey,
-			Password: c.options.ConsumerAPISecret,
-		},
-		Timeout: defaultDialTimeout, // todo make configurable
-		TLS:     &tls.Config{MinVersion: tls.VersionTLS12},
+// default values based on client options and context. Values already set on the passed config take precedence,
+// followed by client options and finally the built-in defaults (cmp.Or returns the first non-zero value)
+func (c *Client) applyDefaults(rc *kafka.ReaderConfig) error {
+	if rc.Dialer == nil {
+		// TLS is always enabled (min version 1.2), custom CA, client certs and SNI are optional
+		tlsConfig, err := c.options.TLS.Config()
+		if err != nil {
+			return err
+		}
+		rc.Dialer = &kafka.Dialer{
+			SASLMechanism: plain.Mechanism{
+				Username: c.options.ConsumerAPIKey,
+				Password: c.options.ConsumerAPISecret,
+			},
+			Timeout: cmp.Or(c.options.DialTimeout, defaultDialTimeout),
+			TLS:     tlsConfig,
+		}
 	}
 
-	// For confluent, there's usually only a single broker server, but it could be also a list
-	rc.Brokers = []string{c.options.BootstrapServers}
+	// For confluent, there's usually only a single broker server, but it could be also a comma separated list
+	if len(rc.Brokers) == 0 {
+		rc.Brokers = c.options.Brokers()
+	}
 
 	// GroupID is important for ACLs, can be overwritten for request but default is derived from client options
 	if rc.GroupID == "" {
@@ -167,43 +232,126 @@ func (c *Client) applyDefaults(rc *kafka.ReaderConfig) {
 	// rc.GroupTopics= []string{pr.Topic} // Can listen to multiple topics
 	// kafka polls the cluster to check if there is any new data on the topic for the my-group kafka ID,
 	// the cluster will only respond if there are at least 10 new bytes of information to send.
-	rc.MinBytes = minConsumeBytes
-	rc.MaxBytes = maxConsumeBytes
-	rc.Dialer = dialer
-	if rc.RetentionTime == 0 {
-		// RetentionTime optionally sets the length of time the consumer group will be saved by broker,
-		// kafka-go see https://github.com/segmentio/kafka-go/issues/393 (used to be 24h)
-		//
-		rc.RetentionTime = defaultRetentionTime
-	}
-	rc.StartOffset = c.options.StartOffset() // see go-doc for details
-	// flushes commits to Kafka every  x seconds, default = 0 (means sync)
-	rc.CommitInterval = 1 * time.Second
+	rc.MinBytes = cmp.Or(rc.MinBytes, c.options.ConsumerMinBytes, minConsumeBytes)
+	rc.MaxBytes = cmp.Or(rc.MaxBytes, c.options.ConsumerMaxBytes, maxConsumeBytes)
+	rc.MaxWait = cmp.Or(rc.MaxWait, c.options.ConsumerMaxWait, defaultMaxWait)
+	// RetentionTime optionally sets the length of time the consumer group will be saved by broker,
+	// kafka-go see https://github.com/segmentio/kafka-go/issues/393 (used to be 24h)
+	rc.RetentionTime = cmp.Or(rc.RetentionTime, c.options.ConsumerRetentionTime, defaultRetentionTime)
+	if rc.StartOffset == 0 {
+		rc.StartOffset = c.options.StartOffset() // see go-doc for details
+	}
+	// flushes commits to Kafka every x seconds, zero means unset so synchronous commits are expressed by a negative
+	// value (see SyncCommits), which is converted to the 0 expected by kafka-go
+	rc.CommitInterval = max(0, cmp.Or(rc.CommitInterval, c.options.ConsumerCommitInterval, defaultCommitInterval))
 
 	// If Logger != nil, it is used to report internal changes within the
+	return nil
+}
+
+// CloseTimeout returns the max time WaitForClose waits for consumers (Options.ConsumerCloseTimeout, default 10s),
+// resources registered with RegisterCloser should use it to limit their own shutdown
+func (c *Client) CloseTimeout() time.Duration {
+	return cmp.Or(c.options.ConsumerCloseTimeout, defaultCloseWaitTimeout)
+}
+
+// RegisterCloser registers resources used by message handlers (e.g. long-lived handler processes)
+// which are closed by WaitForClose once all consumers went down, so in-flight messages can be finished
+func (c *Client) RegisterCloser(closer io.Closer) {
+	c.closers = append(c.closers, closer)
 }
 
-// WaitForClose blocks until the Consumer WaitGroup counter is zero, or timeout is reached
-func (c *Client) WaitForClose(ctx context.Context) {
+// WaitForClose stops all consumers from reading new messages and blocks until their in-flight handlers finished,
+// or Options.ConsumerCloseTimeout (default 10s) is reached. Resources registered with RegisterCloser are closed
+// afterwards. The returned error lists the consumers that did not stop in time (see ErrCloseTimeout) and contains
+// errors of closing readers and registered closers. The Client doesn't accept new consumers once it's called
+func (c *Client) WaitForClose(ctx context.Context) error {
 	logger := log.Ctx(ctx).With().Str("logger", "closer").Logger()
+	timeout := c.CloseTimeout()
+	c.mu.Lock()
+	c.closed = true
+	for _, cons := range c.consumers {
+		cons.cancel()
+	}
+	c.mu.Unlock()
+
 	logger.Print("Waiting for Consumer(s) to go down")
+	start := time.Now()
 	cDone := make(chan struct{})
 	go func() {
 		defer close(cDone)
 		c.wg.Wait()
 	}()
+	var errs []error
 	select {
 	case <-cDone:
-		logger.Printf("All Listeners went down within %v timeout", defaultCloseWaitTimeout)
-	case <-time.After(defaultCloseWaitTimeout):
-		logger.Printf("Timeout %v reached, stop waiting for listener shutdown", defaultCloseWaitTimeout)
+		logger.Printf("All consumers went down after %v", time.Since(start).Round(time.Millisecond))
+	case <-time.After(timeout):
+		pending := c.pendingConsumers()
+		logger.Warn().Msgf("Timeout %v reached, stop waiting for %d consumer(s): %s", timeout, len(pending), strings.Join(pending, ", "))
+		errs = append(errs, fmt.Errorf("%w %v: %s", ErrCloseTimeout, timeout, strings.Join(pending, ", ")))
 	}
+	c.mu.Lock()
+	errs = append(errs, c.readerErrs...)
+	c.mu.Unlock()
+	for _, closer := range c.closers {
+		if err := closer.Close(); err != nil {
+			logger.Warn().Msgf("Error closing %v: %v", closer, err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// register adds a consumer to the wait group, unless the client is closed. Adding to the wait group while holding
+// the lock ensures WaitForClose doesn't start waiting concurrently
+func (c *Client) register(ctx context.Context, name string) (context.Context, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ctx, 0, fmt.Errorf("%w: cannot consume %s", ErrClientClosed, name)
+	}
+	readCtx, cancel := context.WithCancel(ctx)
+	c.nextID++
+	c.consumers[c.nextID] = consumer{name: name, cancel: cancel}
+	c.wg.Add(1)
+	return readCtx, c.nextID, nil
+}
+
+// unregister removes the consumer after its reader has been closed, and keeps the close error for WaitForClose
+func (c *Client) unregister(id int, closeErr error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cons := c.consumers[id]
+	cons.cancel()
+	delete(c.consumers, id)
+	if closeErr != nil {
+		c.readerErrs = append(c.readerErrs, fmt.Errorf("cannot close reader for %s: %w", cons.name, closeErr))
+	}
+	c.wg.Done() // decrement, WaitForClose() will wait for this group as there may be multiple consumers
+}
+
+// pendingConsumers returns the names of consumers which are still running, sorted for stable output
+func (c *Client) pendingConsumers() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := make([]string, 0, len(c.consumers))
+	for _, cons := range c.consumers {
+		pending = append(pending, cons.name)
+	}
+	slices.Sort(pending)
+	return pending
 }
 
 // DumpMessage simple handler function that can be used as HandleMessageFunc and simply dumps information
 // about the received Kafka Message and the payload container therein
 func DumpMessage(_ context.Context, message kafka.Message) {
-	fmt.Printf(" kafka.Message: %s %d/%d %s\n", message.Topic, message.Partition, message.Offset, string(message.Value))
+	_ = dumpMessageTo(os.Stdout, message)
+}
+
+func dumpMessageTo(w io.Writer, message kafka.Message) error {
+	_, err := fmt.Fprintf(w, " kafka.Message: %s %d/%d %s\n", message.Topic, message.Partition, message.Offset, string(message.Value))
+	return err
 }
 
 // AsCloudEvent Helper function to unmarshal Kafka Message into a CloudEvent
@@ -11,6 +11,7 @@ import (
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/tillkuhn/rubin/internal/testutil"
+	"github.com/tillkuhn/rubin/pkg/tlsconfig"
 
 	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
@@ -40,12 +41,15 @@ func TestNewClient(t *testing.T) {
 	ctx = context.WithValue(ctx, contextKeyTopic, testTopic)
 	err := polly.Poll(ctx, kafka.ReaderConfig{Topic: testTopic}, DumpMessage)
 	assert.NoError(t, err)
-	polly.WaitForClose(ctx)
+	assert.NoError(t, polly.WaitForClose(ctx))
+	err = polly.Poll(ctx, kafka.ReaderConfig{Topic: testTopic}, DumpMessage)
+	assert.ErrorIs(t, err, ErrClientClosed, "no new consumers after close")
 
+	polly, _ = testClient(t)
 	ctx = context.WithValue(context.Background(), contextKeyTopic, errorTopic)
 	err = polly.Poll(ctx, kafka.ReaderConfig{Topic: errorTopic}, DumpMessage)
-	assert.Error(t, err)
-	polly.WaitForClose(ctx)
+	assert.ErrorIs(t, err, errTest)
+	assert.NoError(t, polly.WaitForClose(ctx))
 }
 
 func TestCloudEvent(t *testing.T) {
@@ -121,3 +125,124 @@ func (mr *MockMessageReader) ReadMessage(ctx context.Context) (kafka.Message, er
 func (mr *MockMessageReader) Close() error {
 	return nil
 }
+
+func TestInvalidTLS(t *testing.T) {
+	k := NewClient(&Options{TLS: tlsconfig.Options{CertFile: "client.pem"}}, WithReaderFactory(mockMessageReader))
+	err := k.Poll(context.Background(), kafka.ReaderConfig{Topic: testTopic}, DumpMessage)
+	assert.ErrorContains(t, err, "must be set together")
+}
+
+func TestApplyDefaults(t *testing.T) {
+	k := NewClient(&Options{BootstrapServers: "b1:9092,b2:9092", ConsumerGroupID: "group", ConsumerMaxBytes: 2048, DialTimeout: time.Second})
+	rc := kafka.ReaderConfig{Topic: testTopic}
+	assert.NoError(t, k.applyDefaults(&rc))
+	assert.Equal(t, []string{"b1:9092", "b2:9092"}, rc.Brokers)
+	assert.Equal(t, "group", rc.GroupID)
+	assert.Equal(t, minConsumeBytes, rc.MinBytes) // built-in default
+	assert.Equal(t, 2048, rc.MaxBytes)            // from options
+	assert.Equal(t, defaultMaxWait, rc.MaxWait)
+	assert.Equal(t, defaultRetentionTime, rc.RetentionTime)
+	assert.Equal(t, defaultCommitInterval, rc.CommitInterval)
+	k.options.ConsumerCommitInterval = SyncCommits
+	rc = kafka.ReaderConfig{Topic: testTopic}
+	assert.NoError(t, k.applyDefaults(&rc))
+	assert.Equal(t, time.Duration(0), rc.CommitInterval, "synchronous commits configured by options")
+	assert.Equal(t, kafka.FirstOffset, rc.StartOffset)
+	assert.Equal(t, time.Second, rc.Dialer.Timeout)
+
+	// values already set on the reader config must not be overwritten
+	dialer := &kafka.Dialer{}
+	rc = kafka.ReaderConfig{
+		Brokers: []string{"own:9092"}, GroupID: "own", MinBytes: 1, MaxBytes: 100, MaxWait: time.Second,
+		RetentionTime: time.Hour, CommitInterval: SyncCommits, StartOffset: kafka.LastOffset, Dialer: dialer,
+	}
+	assert.NoError(t, k.applyDefaults(&rc))
+	assert.Equal(t, []string{"own:9092"}, rc.Brokers)
+	assert.Equal(t, "own", rc.GroupID)
+	assert.Equal(t, 1, rc.MinBytes)
+	assert.Equal(t, 100, rc.MaxBytes)
+	assert.Equal(t, time.Second, rc.MaxWait)
+	assert.Equal(t, time.Hour, rc.RetentionTime)
+	assert.Equal(t, time.Duration(0), rc.CommitInterval, "synchronous commits")
+	assert.Equal(t, kafka.LastOffset, rc.StartOffset)
+	assert.Same(t, dialer, rc.Dialer)
+}
+
+type closeRecorder struct{ closed bool }
+
+func (cr *closeRecorder) Close() error {
+	cr.closed = true
+	return errTest
+}
+
+func TestRegisterCloser(t *testing.T) {
+	k := NewClient(&Options{})
+	cr := &closeRecorder{}
+	k.RegisterCloser(cr)
+	assert.ErrorIs(t, k.WaitForClose(context.Background()), errTest)
+	assert.True(t, cr.closed)
+}
+
+// chanReader returns messages from a channel, or the context error if it's done before a message is available
+type chanReader struct {
+	messages chan kafka.Message
+	closeErr error
+}
+
+func (cr *chanReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case msg := <-cr.messages:
+		return msg, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (cr *chanReader) Close() error {
+	return cr.closeErr
+}
+
+func TestWaitForClose(t *testing.T) {
+	reader := &chanReader{messages: make(chan kafka.Message, 1), closeErr: errTest}
+	k := NewClient(&Options{ConsumerMaxReceive: -1, ConsumerCloseTimeout: time.Second},
+		WithReaderFactory(func(kafka.ReaderConfig) MessageReader { return reader }))
+	started, finished := make(chan struct{}), make(chan error, 1)
+	handler := func(ctx context.Context, _ kafka.Message) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		finished <- ctx.Err()
+	}
+	pollErr := make(chan error, 1)
+	go func() {
+		pollErr <- k.Poll(context.Background(), kafka.ReaderConfig{Topic: testTopic, GroupID: "g1"}, handler)
+	}()
+	reader.messages <- kafka.Message{Value: []byte("in-flight")}
+	<-started
+
+	err := k.WaitForClose(context.Background())
+	assert.NoError(t, <-finished, "in-flight handler finished with its context intact")
+	assert.NoError(t, <-pollErr)
+	assert.ErrorIs(t, err, errTest, "reader close errors are returned")
+	assert.NotErrorIs(t, err, ErrCloseTimeout)
+	assert.Equal(t, time.Second, k.CloseTimeout())
+	assert.Equal(t, defaultCloseWaitTimeout, NewClient(&Options{}).CloseTimeout())
+
+	// a handler that doesn't finish within the timeout is reported
+	reader = &chanReader{messages: make(chan kafka.Message, 1)}
+	k = NewClient(&Options{ConsumerMaxReceive: -1, ConsumerCloseTimeout: 20 * time.Millisecond},
+		WithReaderFactory(func(kafka.ReaderConfig) MessageReader { return reader }))
+	release := make(chan struct{})
+	defer close(release)
+	started = make(chan struct{})
+	go func() {
+		_ = k.Poll(context.Background(), kafka.ReaderConfig{Topic: testTopic, GroupID: "g2"}, func(context.Context, kafka.Message) {
+			close(started)
+			<-release
+		})
+	}()
+	reader.messages <- kafka.Message{Value: []byte("stuck")}
+	<-started
+	err = k.WaitForClose(context.Background())
+	assert.ErrorIs(t, err, ErrCloseTimeout)
+	assert.ErrorContains(t, err, "topic(s)=[mock.hase] groupID=g2")
+}
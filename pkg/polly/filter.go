@@ -0,0 +1,300 @@
+package polly
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+)
+
+// errInvalidFilter used as static error for filter expressions that cannot be parsed
+var errInvalidFilter = errors.New("invalid filter expression")
+
+// Filter matches Kafka Messages against a list of expressions, all of them must match (logical AND).
+// An expression has the format <field><operator><value>, supported fields are
+//
+//	key                     message key
+//	partition               message partition
+//	header.<name>           value of message header <name>, e.g. header.content-type
+//	ce.<attribute>          CloudEvent attribute id, type, source, subject or any extension
+//	json.<path>             dot separated path into the JSON payload, e.g. json.data.items.0.name
+//
+// Supported operators are == (or =), != for equality, =~ and !~ for regular expressions,
+// ~ for glob patterns (* matches any sequence, ? a single char) and <, <=, >, >= for numeric comparison.
+// Fields that are not present in the message (e.g. ce.type for a non CloudEvent) only match negated operators.
+//
+//	f, err := polly.NewFilter("ce.type~net.timafe.event.*", "partition<3", `json.data.user=~^james`)
+type Filter struct {
+	terms []filterTerm
+}
+
+type filterTerm struct {
+	field    string
+	operator string
+	value    string
+	regex    *regexp.Regexp
+	number   float64
+}
+
+// NewFilter parses the given expressions and returns a Filter, or an error if any of them is invalid
+func NewFilter(expressions ...string) (*Filter, error) {
+	f := &Filter{}
+	for _, expr := range expressions {
+		term, err := parseFilterTerm(expr)
+		if err != nil {
+			return nil, err
+		}
+		f.terms = append(f.terms, term)
+	}
+	return f, nil
+}
+
+// String returns the list of parsed expressions
+func (f *Filter) String() string {
+	exprs := make([]string, len(f.terms))
+	for i, t := range f.terms {
+		exprs[i] = t.field + t.operator + t.value
+	}
+	return strings.Join(exprs, " && ")
+}
+
+// Match returns true if the message matches all filter expressions (or if the filter is empty)
+func (f *Filter) Match(message kafka.Message) bool {
+	mv := &messageView{message: message}
+	for _, t := range f.terms {
+		if !t.match(mv) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterMessages wraps a HandleMessageFunc so only messages that match the filter are passed to next.
+// Non-matching messages are skipped, but since they have already been read they are committed as usual
+func FilterMessages(filter *Filter, next HandleMessageFunc) HandleMessageFunc {
+	return func(ctx context.Context, message kafka.Message) {
+		if !filter.Match(message) {
+			log.Ctx(ctx).Debug().Str("logger", "filter").Msgf("Skip message %s %d/%d, no match for %s",
+				message.Topic, message.Partition, message.Offset, filter.String())
+			return
+		}
+		next(ctx, message)
+	}
+}
+
+func parseFilterTerm(expr string) (filterTerm, error) {
+	opIdx := strings.IndexAny(expr, "=!<>~")
+	if opIdx < 1 {
+		return filterTerm{}, fmt.Errorf("%w: %q, expected <field><operator><value>", errInvalidFilter, expr)
+	}
+	t := filterTerm{field: strings.TrimSpace(expr[:opIdx])}
+	// two-char operators must be tried before their single-char prefixes
+	for _, op := range []string{"==", "!=", "=~", "!~", "<=", ">=", "=", "<", ">", "~"} {
+		if strings.HasPrefix(expr[opIdx:], op) {
+			t.operator = op
+			break
+		}
+	}
+	if t.operator == "" {
+		return t, fmt.Errorf("%w: %q, unknown operator", errInvalidFilter, expr)
+	}
+	t.value = strings.TrimSpace(expr[opIdx+len(t.operator):])
+	if !isValidFilterField(t.field) {
+		return t, fmt.Errorf("%w: %q, unsupported field %s", errInvalidFilter, expr, t.field)
+	}
+
+	var err error
+	switch t.operator {
+	case "=~", "!~":
+		t.regex, err = regexp.Compile(t.value)
+	case "~":
+		t.regex = globToRegexp(t.value)
+	case "<", "<=", ">", ">=":
+		t.number, err = strconv.ParseFloat(t.value, 64)
+	}
+	if err != nil {
+		return t, fmt.Errorf("%w: %q, %s", errInvalidFilter, expr, err.Error())
+	}
+	return t, nil
+}
+
+func isValidFilterField(field string) bool {
+	switch {
+	case field == "key", field == "partition":
+		return true
+	case strings.HasPrefix(field, "header."), strings.HasPrefix(field, "ce."), strings.HasPrefix(field, "json."):
+		return len(field) > strings.Index(field, ".")+1
+	default:
+		return false
+	}
+}
+
+func (t filterTerm) match(mv *messageView) bool {
+	actual, found := mv.field(t.field)
+	if !found {
+		return t.operator == "!=" || t.operator == "!~"
+	}
+	switch t.operator {
+	case "==", "=":
+		return actual == t.value
+	case "!=":
+		return actual != t.value
+	case "=~", "~":
+		return t.regex.MatchString(actual)
+	case "!~":
+		return !t.regex.MatchString(actual)
+	default:
+		num, err := strconv.ParseFloat(actual, 64)
+		if err != nil {
+			return false
+		}
+		return compareNumbers(num, t.operator, t.number)
+	}
+}
+
+func compareNumbers(actual float64, operator string, expected float64) bool {
+	switch operator {
+	case "<":
+		return actual < expected
+	case "<=":
+		return actual <= expected
+	case ">":
+		return actual > expected
+	default:
+		return actual >= expected
+	}
+}
+
+// messageView lazily decodes CloudEvent and JSON payload, so they are only parsed once and only if needed
+type messageView struct {
+	message   kafka.Message
+	ce        *cloudevents.Event
+	ceErr     error
+	payload   interface{}
+	payloadOK *bool
+}
+
+func (mv *messageView) field(field string) (string, bool) {
+	name, attr, _ := strings.Cut(field, ".")
+	switch name {
+	case "key":
+		return string(mv.message.Key), true
+	case "partition":
+		return strconv.Itoa(mv.message.Partition), true
+	case "header":
+		return headerValue(mv.message, attr)
+	case "ce":
+		return mv.cloudEventAttribute(attr)
+	default: // json
+		return mv.jsonPath(attr)
+	}
+}
+
+func (mv *messageView) cloudEventAttribute(attr string) (string, bool) {
+	if mv.ce == nil && mv.ceErr == nil {
+		ce, err := AsCloudEvent(mv.message)
+		mv.ce, mv.ceErr = &ce, err
+	}
+	if mv.ceErr != nil {
+		return "", false
+	}
+	switch attr {
+	case "id":
+		return mv.ce.ID(), true
+	case "type":
+		return mv.ce.Type(), true
+	case "source":
+		return mv.ce.Source(), true
+	case "subject":
+		return mv.ce.Subject(), mv.ce.Subject() != ""
+	default:
+		ext, ok := mv.ce.Extensions()[attr]
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("%v", ext), true
+	}
+}
+
+func (mv *messageView) jsonPath(path string) (string, bool) {
+	if mv.payloadOK == nil {
+		ok := json.Unmarshal(mv.message.Value, &mv.payload) == nil
+		mv.payloadOK = &ok
+	}
+	if !*mv.payloadOK {
+		return "", false
+	}
+	return lookupJSONPath(mv.payload, path)
+}
+
+// lookupJSONPath walks a dot separated path through decoded JSON objects and arrays,
+// scalar values are returned as string, objects and arrays in their JSON representation
+func lookupJSONPath(data interface{}, path string) (string, bool) {
+	current := data
+	for _, segment := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			val, ok := node[segment]
+			if !ok {
+				return "", false
+			}
+			current = val
+		case []interface{}:
+			idx, err := strconv.Atoi(segment)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return "", false
+			}
+			current = node[idx]
+		default:
+			return "", false
+		}
+	}
+	switch val := current.(type) {
+	case nil:
+		return "", false
+	case string:
+		return val, true
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(val), true
+	default:
+		b, _ := json.Marshal(val)
+		return string(b), true
+	}
+}
+
+func headerValue(message kafka.Message, name string) (string, bool) {
+	for _, h := range message.Headers {
+		if strings.EqualFold(h.Key, name) {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// globToRegexp converts a simple glob pattern (* any sequence, ? single char) into an anchored regular expression,
+// all other chars are quoted so the result is always a valid expression
+func globToRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
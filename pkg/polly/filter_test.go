@@ -0,0 +1,98 @@
+package polly
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+)
+
+func TestFilterMatch(t *testing.T) {
+	ceBytes, err := os.ReadFile(testutil.TestDataDir + "/cloudevent-ci.json")
+	assert.NoError(t, err)
+	ceMsg := kafka.Message{
+		Partition: 2,
+		Key:       []byte("key/123"),
+		Value:     ceBytes,
+		Headers:   []kafka.Header{{Key: "content-type", Value: []byte(cloudevents.ApplicationCloudEventsJSON)}},
+	}
+	jsonMsg := kafka.Message{
+		Partition: 0,
+		Key:       []byte("order-42"),
+		Value:     []byte(`{"id": 42, "user": {"name": "james.bond"}, "items": [{"sku": "A7"}], "vip": true}`),
+		Headers:   []kafka.Header{{Key: "source", Value: []byte("ci")}},
+	}
+	tests := []struct {
+		name  string
+		expr  string
+		msg   kafka.Message
+		match bool
+	}{
+		{"key_equals", "key==order-42", jsonMsg, true},
+		{"key_equals_short", "key=order-42", jsonMsg, true},
+		{"key_not_equals", "key!=order-42", jsonMsg, false},
+		{"key_regex", "key=~^order-[0-9]+$", jsonMsg, true},
+		{"key_not_regex", "key!~^order", jsonMsg, false},
+		{"key_glob", "key~order-*", jsonMsg, true},
+		{"partition_lt", "partition<1", jsonMsg, true},
+		{"partition_ge", "partition>=3", ceMsg, false},
+		{"header", "header.source==ci", jsonMsg, true},
+		{"header_missing", "header.nope==ci", jsonMsg, false},
+		{"header_missing_negated", "header.nope!=ci", jsonMsg, true},
+		{"json_number", "json.id>40", jsonMsg, true},
+		{"json_nested", "json.user.name==james.bond", jsonMsg, true},
+		{"json_array", "json.items.0.sku==A7", jsonMsg, true},
+		{"json_bool", "json.vip==true", jsonMsg, true},
+		{"json_out_of_range", "json.items.1.sku==A7", jsonMsg, false},
+		{"json_not_a_number", "json.user.name>1", jsonMsg, false},
+		{"ce_type_glob", "ce.type~net.timafe.events.*", ceMsg, true},
+		{"ce_type_exact", "ce.type==net.timafe.events.ci.published", ceMsg, true},
+		{"ce_on_plain_json", "ce.type~*", jsonMsg, false},
+		{"ce_missing_extension", "ce.nope!~.*", ceMsg, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(tt.expr)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.match, f.Match(tt.msg), tt.expr)
+		})
+	}
+}
+
+func TestFilterAnd(t *testing.T) {
+	msg := kafka.Message{Partition: 1, Key: []byte("k1")}
+	f, err := NewFilter("key==k1", "partition==1")
+	assert.NoError(t, err)
+	assert.True(t, f.Match(msg))
+	assert.Equal(t, "key==k1 && partition==1", f.String())
+	f, err = NewFilter("key==k1", "partition==2")
+	assert.NoError(t, err)
+	assert.False(t, f.Match(msg))
+	f, err = NewFilter()
+	assert.NoError(t, err)
+	assert.True(t, f.Match(msg))
+}
+
+func TestFilterInvalid(t *testing.T) {
+	for _, expr := range []string{"key", "==abc", "unknown==1", "json.==1", "key=~[", "partition<abc"} {
+		_, err := NewFilter(expr)
+		assert.ErrorContains(t, err, "invalid filter expression", expr)
+	}
+}
+
+func TestFilterMessages(t *testing.T) {
+	f, err := NewFilter("key==yes")
+	assert.NoError(t, err)
+	var handled []string
+	handler := FilterMessages(f, func(_ context.Context, message kafka.Message) {
+		handled = append(handled, string(message.Key))
+	})
+	for _, k := range []string{"yes", "no", "yes"} {
+		handler(context.Background(), kafka.Message{Key: []byte(k)})
+	}
+	assert.Equal(t, []string{"yes", "yes"}, handled)
+}
@@ -2,16 +2,19 @@ package polly
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/segmentio/kafka-go"
+	"github.com/tillkuhn/rubin/pkg/tlsconfig"
 )
 
 const envconfigDefaultPrefix = "kafka"
 
 // Options Kafka Context params populated by envconfig in NewClientFromEnv...()
 type Options struct {
-	BootstrapServers string `required:"false" default:"localhost:9092" desc:"Kafka Bootstrap server(s)" split_words:"true"`
+	BootstrapServers string `required:"false" default:"localhost:9092" desc:"Kafka Bootstrap server(s), separate multiple brokers by comma" split_words:"true"`
 	// ProducerClientID   string `required:"false" default:"kafkaClient" desc:"Client Id for Message Producer" split_words:"true"`
 	ConsumerAPIKey     string `required:"false" default:"" desc:"Kafka API Key Key for consumer (user)"  split_words:"true"`
 	ConsumerAPISecret  string `required:"false" default:"" desc:"Kafka API Secret for consumer (password)" split_words:"true"`
@@ -19,6 +22,16 @@ type Options struct {
 	ConsumerMaxReceive int32  `required:"false" default:"-1" desc:"Max num of received messages, default -1 (unlimited), useful for dev" split_words:"true"`
 	ConsumerStartLast  bool   `required:"false" default:"false" desc:"Whether to start consuming at the last offset (default: first)" split_words:"true"`
 	Debug              bool   `default:"false" desc:"Debug mode, registers logger for kafka packages" split_words:"true"`
+	// Reader tuning, values already set on the kafka.ReaderConfig passed to Poll take precedence
+	ConsumerMinBytes       int           `required:"false" default:"10" desc:"Min bytes the broker has to collect before responding to a fetch request" split_words:"true"`
+	ConsumerMaxBytes       int           `required:"false" default:"10000000" desc:"Max bytes the broker returns for a fetch request" split_words:"true"`
+	ConsumerMaxWait        time.Duration `required:"false" default:"10s" desc:"Max time to wait for new data when fetching batches" split_words:"true"`
+	ConsumerCommitInterval time.Duration `required:"false" default:"1s" desc:"Interval to flush offset commits to the broker, a negative value (e.g. -1s) means synchronous commits" split_words:"true"`
+	ConsumerRetentionTime  time.Duration `required:"false" default:"168h" desc:"How long the broker keeps the offsets of a consumer group" split_words:"true"`
+	DialTimeout            time.Duration `required:"false" default:"5s" desc:"Timeout for establishing broker connections" split_words:"true"`
+	ConsumerCloseTimeout   time.Duration `required:"false" default:"10s" desc:"Max time to wait for in-flight messages on shutdown" split_words:"true"`
+	// TLS custom CA bundle, client certificates and SNI for brokers with internal PKI
+	TLS tlsconfig.Options `yaml:"tls" split_words:"true"`
 }
 
 // String returns a String representation of the object (but hides sensitive information)
@@ -40,6 +53,17 @@ func NewOptionsFromEnvWithPrefix(prefix string) (*Options, error) {
 	return &options, nil
 }
 
+// Brokers splits the comma separated list of BootstrapServers into a slice of broker addresses
+func (o Options) Brokers() []string {
+	var brokers []string
+	for _, b := range strings.Split(o.BootstrapServers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	return brokers
+}
+
 // StartOffset provides the reader options depending on ConsumerStartLast (true == first, else last)
 // LastOffset  int64 = -1 // The most recent offset available for a partition.
 // FirstOffset int64 = -2 // The least recent offset available for a partition.
@@ -43,3 +43,10 @@ func TestOptionsError(t *testing.T) {
 	_, err := NewOptionsFromEnv()
 	assert.ErrorContains(t, err, "invalid syntax") // envconfig.Process: strconv.ParseInt: ...
 }
+
+func TestBrokers(t *testing.T) {
+	o := Options{BootstrapServers: "broker1:9092, broker2:9092,,broker3:9092 "}
+	assert.Equal(t, []string{"broker1:9092", "broker2:9092", "broker3:9092"}, o.Brokers())
+	o.BootstrapServers = ""
+	assert.Empty(t, o.Brokers())
+}
@@ -0,0 +1,132 @@
+package polly
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+)
+
+// Supported output formats for NewOutputHandler
+const (
+	OutputText     = "text"     // legacy one-line summary, see DumpMessage
+	OutputJSON     = "json"     // one JSON document per line (NDJSON), easy to pipe into jq
+	OutputPretty   = "pretty"   // indented JSON
+	OutputRaw      = "raw"      // message value only
+	OutputTemplate = "template" // custom Go text/template, evaluated against MessageRecord
+)
+
+// Value encodings used in MessageRecord.ValueEncoding
+const (
+	encodingJSON   = "json"
+	encodingString = "string"
+	encodingBase64 = "base64"
+)
+
+// errInvalidOutput used as static error for unsupported output formats or templates
+var errInvalidOutput = errors.New("invalid output format")
+
+// MessageRecord is a serialization friendly representation of a kafka.Message with decoded key and headers.
+// Value holds the parsed JSON payload if valid, otherwise a string (valid UTF-8) or base64 encoded bytes
+type MessageRecord struct {
+	Topic         string            `json:"topic"`
+	Partition     int               `json:"partition"`
+	Offset        int64             `json:"offset"`
+	Key           string            `json:"key"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Value         interface{}       `json:"value"`
+	ValueEncoding string            `json:"value_encoding"`
+}
+
+// NewMessageRecord converts a kafka.Message into a MessageRecord
+func NewMessageRecord(message kafka.Message) MessageRecord {
+	rec := MessageRecord{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       string(message.Key),
+		Timestamp: message.Time,
+	}
+	if len(message.Headers) > 0 {
+		rec.Headers = make(map[string]string, len(message.Headers))
+		for _, h := range message.Headers {
+			rec.Headers[h.Key] = string(h.Value)
+		}
+	}
+	switch {
+	case json.Valid(message.Value):
+		rec.ValueEncoding = encodingJSON
+		_ = json.Unmarshal(message.Value, &rec.Value)
+	case utf8.Valid(message.Value):
+		rec.ValueEncoding = encodingString
+		rec.Value = string(message.Value)
+	default:
+		rec.ValueEncoding = encodingBase64
+		rec.Value = b64.StdEncoding.EncodeToString(message.Value)
+	}
+	return rec
+}
+
+// NewOutputHandler returns a HandleMessageFunc that writes each message to w in the given format,
+// tmpl is only used (and mandatory) for OutputTemplate, e.g. '{{.Key}} {{.Value.type}}'
+func NewOutputHandler(w io.Writer, format string, tmpl string) (HandleMessageFunc, error) {
+	var write func(message kafka.Message) error
+	switch format {
+	case OutputText, "":
+		write = func(message kafka.Message) error { return dumpMessageTo(w, message) }
+	case OutputJSON:
+		enc := json.NewEncoder(w) // Encode appends a newline
+		write = func(message kafka.Message) error { return enc.Encode(NewMessageRecord(message)) }
+	case OutputPretty:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		write = func(message kafka.Message) error { return enc.Encode(NewMessageRecord(message)) }
+	case OutputRaw:
+		write = func(message kafka.Message) error {
+			_, err := fmt.Fprintf(w, "%s\n", message.Value)
+			return err
+		}
+	case OutputTemplate:
+		t, err := parseOutputTemplate(tmpl)
+		if err != nil {
+			return nil, err
+		}
+		write = func(message kafka.Message) error {
+			if err := t.Execute(w, NewMessageRecord(message)); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintln(w)
+			return err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s, expected one of %s", errInvalidOutput, format,
+			strings.Join([]string{OutputText, OutputJSON, OutputPretty, OutputRaw, OutputTemplate}, "|"))
+	}
+	return func(ctx context.Context, message kafka.Message) {
+		if err := write(message); err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("logger", "output").Msgf("Cannot write message %s %d/%d",
+				message.Topic, message.Partition, message.Offset)
+		}
+	}, nil
+}
+
+func parseOutputTemplate(tmpl string) (*template.Template, error) {
+	if strings.TrimSpace(tmpl) == "" {
+		return nil, fmt.Errorf("%w: template must not be empty", errInvalidOutput)
+	}
+	t, err := template.New("output").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidOutput, err.Error())
+	}
+	return t, nil
+}
@@ -0,0 +1,92 @@
+package polly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func testMessage(value []byte) kafka.Message {
+	return kafka.Message{
+		Topic:     "public.hello",
+		Partition: 1,
+		Offset:    42,
+		Key:       []byte("key-1"),
+		Headers:   []kafka.Header{{Key: "source", Value: []byte("ci")}},
+		Time:      time.Date(2023, time.November, 14, 20, 45, 32, 0, time.UTC),
+		Value:     value,
+	}
+}
+
+func TestNewMessageRecord(t *testing.T) {
+	rec := NewMessageRecord(testMessage([]byte(`{"type":"app.started"}`)))
+	assert.Equal(t, "json", rec.ValueEncoding)
+	assert.Equal(t, map[string]interface{}{"type": "app.started"}, rec.Value)
+	assert.Equal(t, "ci", rec.Headers["source"])
+	assert.Equal(t, "key-1", rec.Key)
+
+	rec = NewMessageRecord(testMessage([]byte("Hello Franz!")))
+	assert.Equal(t, "string", rec.ValueEncoding)
+	assert.Equal(t, "Hello Franz!", rec.Value)
+
+	rec = NewMessageRecord(testMessage([]byte{0xff, 0xfe, 0x00}))
+	assert.Equal(t, "base64", rec.ValueEncoding)
+	assert.Equal(t, "//4A", rec.Value)
+}
+
+func TestOutputHandler(t *testing.T) {
+	msg := testMessage([]byte(`{"type":"app.started"}`))
+	tests := []struct {
+		format   string
+		tmpl     string
+		expected string
+	}{
+		{OutputText, "", " kafka.Message: public.hello 1/42 {\"type\":\"app.started\"}\n"},
+		{OutputRaw, "", "{\"type\":\"app.started\"}\n"},
+		{OutputTemplate, "{{.Key}} {{.Value.type}} {{index .Headers \"source\"}}", "key-1 app.started ci\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			h, err := NewOutputHandler(&buf, tt.format, tt.tmpl)
+			assert.NoError(t, err)
+			h(context.Background(), msg)
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+
+	// json lines, one document per message
+	var buf bytes.Buffer
+	h, err := NewOutputHandler(&buf, OutputJSON, "")
+	assert.NoError(t, err)
+	h(context.Background(), msg)
+	h(context.Background(), msg)
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+	var rec map[string]interface{}
+	assert.NoError(t, json.Unmarshal(lines[0], &rec))
+	assert.Equal(t, "public.hello", rec["topic"])
+	assert.Equal(t, float64(42), rec["offset"])
+	assert.Equal(t, "2023-11-14T20:45:32Z", rec["timestamp"])
+	assert.Equal(t, "app.started", rec["value"].(map[string]interface{})["type"])
+
+	buf.Reset()
+	h, err = NewOutputHandler(&buf, OutputPretty, "")
+	assert.NoError(t, err)
+	h(context.Background(), msg)
+	assert.Contains(t, buf.String(), "\n  \"topic\": \"public.hello\",\n")
+}
+
+func TestOutputHandlerInvalid(t *testing.T) {
+	_, err := NewOutputHandler(&bytes.Buffer{}, "xml", "")
+	assert.ErrorContains(t, err, "invalid output format: xml")
+	_, err = NewOutputHandler(&bytes.Buffer{}, OutputTemplate, "")
+	assert.ErrorContains(t, err, "template must not be empty")
+	_, err = NewOutputHandler(&bytes.Buffer{}, OutputTemplate, "{{.Key")
+	assert.ErrorContains(t, err, "invalid output format")
+}
@@ -0,0 +1,165 @@
+package polly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+)
+
+// errUnmatchedType passed to DeadLetterFunc if no handler is registered for the type of CloudEvent
+var errUnmatchedType = errors.New("no handler registered for event type")
+
+// UnmatchedPolicy controls how the Router deals with messages that have no matching handler (and no fallback),
+// this also applies to messages that cannot be decoded as CloudEvent
+type UnmatchedPolicy int
+
+const (
+	// UnmatchedSkip silently skips the message (only visible on debug level)
+	UnmatchedSkip UnmatchedPolicy = iota
+	// UnmatchedLog skips the message but logs a warning
+	UnmatchedLog
+	// UnmatchedDeadLetter passes the message to the Router's DeadLetter function
+	UnmatchedDeadLetter
+)
+
+// EventHandler handles a decoded CloudEvent, returned errors are logged and passed to the DeadLetter function (if set)
+type EventHandler func(ctx context.Context, ce cloudevents.Event) error
+
+// DeadLetterFunc receives messages that could not be handled, e.g. to forward them to a dead-letter topic
+type DeadLetterFunc func(ctx context.Context, message kafka.Message, reason error)
+
+type route struct {
+	pattern string
+	regex   *regexp.Regexp
+	handler EventHandler
+}
+
+// Router decodes Kafka messages with AsCloudEvent and dispatches them to handlers registered per event type.
+// Exact matches take precedence over prefix matches (longest prefix wins), followed by glob patterns in the
+// order of registration. Use HandleMessage as HandleMessageFunc for Client.Poll
+//
+//	router := polly.NewRouter()
+//	router.Handle("net.timafe.event.app.started", polly.Typed(func(ctx context.Context, ce cloudevents.Event, p *MyPayload) error {
+//		return nil
+//	}))
+//	router.HandlePrefix("net.timafe.event.entity.", entityHandler)
+//	router.Handle("*.deleted", deletedHandler)
+//	err := client.Poll(ctx, kafka.ReaderConfig{Topic: "app.events"}, router.HandleMessage)
+type Router struct {
+	// Unmatched policy for messages without matching handler, default is UnmatchedSkip
+	Unmatched UnmatchedPolicy
+	// DeadLetter is invoked for failed messages, and for unmatched messages if policy is UnmatchedDeadLetter
+	DeadLetter DeadLetterFunc
+	exact      map[string]EventHandler
+	prefixes   []route
+	globs      []route
+	fallback   EventHandler
+}
+
+// NewRouter returns a new Router with no registered handlers
+func NewRouter() *Router {
+	return &Router{exact: map[string]EventHandler{}}
+}
+
+// Handle registers a handler for the given event type, patterns containing * or ? are treated as glob patterns
+func (r *Router) Handle(pattern string, handler EventHandler) {
+	if strings.ContainsAny(pattern, "*?") {
+		r.globs = append(r.globs, route{pattern: pattern, regex: globToRegexp(pattern), handler: handler})
+		return
+	}
+	r.exact[pattern] = handler
+}
+
+// HandlePrefix registers a handler for all event types starting with prefix
+func (r *Router) HandlePrefix(prefix string, handler EventHandler) {
+	r.prefixes = append(r.prefixes, route{pattern: prefix, handler: handler})
+	// keep the longest prefix first, so the most specific handler wins
+	sort.SliceStable(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i].pattern) > len(r.prefixes[j].pattern)
+	})
+}
+
+// Fallback registers a handler for all events whose type is not matched by any other handler
+func (r *Router) Fallback(handler EventHandler) {
+	r.fallback = handler
+}
+
+// HandleMessage decodes the message and dispatches it to the matching handler, signature matches HandleMessageFunc
+func (r *Router) HandleMessage(ctx context.Context, message kafka.Message) {
+	logger := log.Ctx(ctx).With().Str("logger", "router").Logger()
+	ce, err := AsCloudEvent(message)
+	if err != nil {
+		r.handleUnmatched(ctx, message, err)
+		return
+	}
+	handler := r.match(ce.Type())
+	if handler == nil {
+		r.handleUnmatched(ctx, message, fmt.Errorf("%w: %s", errUnmatchedType, ce.Type()))
+		return
+	}
+	if err := handler(ctx, ce); err != nil {
+		logger.Error().Err(err).Msgf("Handler failed for event id=%s type=%s (%s %d/%d)",
+			ce.ID(), ce.Type(), message.Topic, message.Partition, message.Offset)
+		if r.DeadLetter != nil {
+			r.DeadLetter(ctx, message, err)
+		}
+	}
+}
+
+// match returns the handler for the event type, or nil if there's neither a matching handler nor a fallback
+func (r *Router) match(eventType string) EventHandler {
+	if h, ok := r.exact[eventType]; ok {
+		return h
+	}
+	for _, p := range r.prefixes {
+		if strings.HasPrefix(eventType, p.pattern) {
+			return p.handler
+		}
+	}
+	for _, g := range r.globs {
+		if g.regex.MatchString(eventType) {
+			return g.handler
+		}
+	}
+	return r.fallback
+}
+
+func (r *Router) handleUnmatched(ctx context.Context, message kafka.Message, reason error) {
+	logger := log.Ctx(ctx).With().Str("logger", "router").Logger()
+	switch r.Unmatched {
+	case UnmatchedLog:
+		logger.Warn().Msgf("Skip message %s %d/%d: %v", message.Topic, message.Partition, message.Offset, reason)
+	case UnmatchedDeadLetter:
+		if r.DeadLetter == nil {
+			logger.Error().Msgf("No DeadLetter function registered, skip message %s %d/%d: %v",
+				message.Topic, message.Partition, message.Offset, reason)
+			return
+		}
+		r.DeadLetter(ctx, message, reason)
+	case UnmatchedSkip:
+		logger.Debug().Msgf("Skip message %s %d/%d: %v", message.Topic, message.Partition, message.Offset, reason)
+	}
+}
+
+// Typed adapts a handler that expects the CloudEvent data unmarshalled into *T to an EventHandler
+//
+//	router.Handle("net.timafe.event.app.started", polly.Typed(func(ctx context.Context, ce cloudevents.Event, p *AppStarted) error {
+//		fmt.Println(p.Version)
+//		return nil
+//	}))
+func Typed[T any](handler func(ctx context.Context, ce cloudevents.Event, data *T) error) EventHandler {
+	return func(ctx context.Context, ce cloudevents.Event) error {
+		data := new(T)
+		if err := ce.DataAs(data); err != nil {
+			return fmt.Errorf("cannot unmarshal data of event type %s into %T: %w", ce.Type(), data, err)
+		}
+		return handler(ctx, ce, data)
+	}
+}
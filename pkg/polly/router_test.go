@@ -0,0 +1,93 @@
+package polly
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type ciPayload struct {
+	Action string `json:"action"`
+	Repo   string `json:"repo"`
+}
+
+func TestRouterDispatch(t *testing.T) {
+	var got []string
+	recorder := func(name string) EventHandler {
+		return func(_ context.Context, _ cloudevents.Event) error {
+			got = append(got, name)
+			return nil
+		}
+	}
+	r := NewRouter()
+	r.Handle("net.timafe.events.ci.published", Typed(func(_ context.Context, _ cloudevents.Event, p *ciPayload) error {
+		got = append(got, "exact:"+p.Action)
+		return nil
+	}))
+	r.HandlePrefix("net.timafe.", recorder("prefix-short"))
+	r.HandlePrefix("net.timafe.events.", recorder("prefix-long"))
+	r.Handle("*.deleted", recorder("glob"))
+	r.Fallback(recorder("fallback"))
+
+	ctx := context.Background()
+	r.HandleMessage(ctx, ceMessage(t, "net.timafe.events.ci.published", ciPayload{Action: "deploy"}))
+	r.HandleMessage(ctx, ceMessage(t, "net.timafe.events.app.started", nil))
+	r.HandleMessage(ctx, ceMessage(t, "net.timafe.user.created", nil))
+	r.HandleMessage(ctx, ceMessage(t, "com.example.user.deleted", nil))
+	r.HandleMessage(ctx, ceMessage(t, "com.example.user.created", nil))
+	assert.Equal(t, []string{"exact:deploy", "prefix-long", "prefix-short", "glob", "fallback"}, got)
+}
+
+func TestRouterUnmatchedAndErrors(t *testing.T) {
+	var deadLetters []string
+	r := NewRouter()
+	r.Unmatched = UnmatchedDeadLetter
+	r.DeadLetter = func(_ context.Context, message kafka.Message, reason error) {
+		deadLetters = append(deadLetters, string(message.Key)+": "+reason.Error())
+	}
+	r.Handle("typed.event", Typed(func(_ context.Context, _ cloudevents.Event, _ *ciPayload) error {
+		return nil
+	}))
+
+	ctx := context.Background()
+	r.HandleMessage(ctx, ceMessage(t, "other.event", nil))
+	r.HandleMessage(ctx, ceMessage(t, "typed.event", "not an object"))
+	r.HandleMessage(ctx, kafka.Message{Key: []byte("no-ce"), Value: []byte("plain")})
+	assert.Len(t, deadLetters, 3)
+	assert.Contains(t, deadLetters[0], "no handler registered for event type: other.event")
+	assert.Contains(t, deadLetters[1], "cannot unmarshal data of event type typed.event")
+	assert.Contains(t, deadLetters[2], "no-ce: invalid content-type")
+
+	// other policies must not invoke the dead letter function
+	for _, policy := range []UnmatchedPolicy{UnmatchedSkip, UnmatchedLog} {
+		r.Unmatched = policy
+		r.HandleMessage(ctx, ceMessage(t, "other.event", nil))
+	}
+	assert.Len(t, deadLetters, 3)
+
+	// dead letter policy without function only logs
+	r.Unmatched = UnmatchedDeadLetter
+	r.DeadLetter = nil
+	r.HandleMessage(ctx, ceMessage(t, "other.event", nil))
+}
+
+func ceMessage(t *testing.T, eventType string, data interface{}) kafka.Message {
+	ce := cloudevents.NewEvent()
+	ce.SetID("id-" + eventType)
+	ce.SetSource("//polly/test")
+	ce.SetType(eventType)
+	if data != nil {
+		assert.NoError(t, ce.SetData(cloudevents.ApplicationJSON, data))
+	}
+	value, err := json.Marshal(ce)
+	assert.NoError(t, err)
+	return kafka.Message{
+		Key:     []byte(eventType),
+		Value:   value,
+		Headers: []kafka.Header{{Key: "content-type", Value: []byte(cloudevents.ApplicationCloudEventsJSON)}},
+	}
+}
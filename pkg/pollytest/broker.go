@@ -0,0 +1,235 @@
+// Package pollytest provides an in-memory Kafka broker and MessageReader to unit test polly consumers and their
+// HandleMessageFunc pipelines without a Kafka cluster. Messages are stored per topic and partition with incrementing
+// offsets, readers support consumer groups with committed offsets as well as partition readers without group
+//
+//	broker := pollytest.NewBroker()
+//	_ = broker.Produce(kafka.Message{Topic: "public.hello", Value: []byte("Hello")})
+//	client := polly.NewClient(options, polly.WithReaderFactory(broker.NewReader))
+//	err := client.Poll(ctx, kafka.ReaderConfig{Topic: "public.hello"}, handler)
+package pollytest
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	// ErrUnknownTopicOrPartition is returned if a message is produced to a partition that doesn't exist
+	ErrUnknownTopicOrPartition = errors.New("unknown topic or partition")
+	errMissingTopic            = errors.New("missing topic")
+)
+
+// Broker is an in-memory topic store, it's safe for concurrent use
+type Broker struct {
+	mu         sync.Mutex
+	partitions map[string][][]kafka.Message
+	// committed holds the next offset to consume per group, topic and partition
+	committed map[groupPartition]int64
+	// positions holds the next offset to fetch per group, shared by all readers of the group
+	positions map[groupPartition]int64
+	// members counts the open readers per group, positions are reset to committed offsets if the last one closes
+	members map[string]int
+	// changed is closed and replaced whenever messages are produced, so blocked readers wake up
+	changed chan struct{}
+}
+
+type groupPartition struct {
+	group     string
+	topic     string
+	partition int
+}
+
+// NewBroker returns an empty broker, topics are created with a single partition on first produce or by CreateTopic
+func NewBroker() *Broker {
+	return &Broker{
+		partitions: map[string][][]kafka.Message{},
+		committed:  map[groupPartition]int64{},
+		positions:  map[groupPartition]int64{},
+		members:    map[string]int{},
+		changed:    make(chan struct{}),
+	}
+}
+
+// CreateTopic creates the topic with the given number of partitions, an existing topic is not changed
+func (b *Broker) CreateTopic(topic string, partitions int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.partitions[topic]; !exists {
+		b.partitions[topic] = make([][]kafka.Message, max(partitions, 1))
+	}
+}
+
+// Produce appends the messages to their topic and assigns offsets. Messages with key are assigned to a partition
+// by key hash, messages without key use their Partition field. Time is set to now if it's zero
+func (b *Broker) Produce(messages ...kafka.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, msg := range messages {
+		if msg.Topic == "" {
+			return errMissingTopic
+		}
+		if _, exists := b.partitions[msg.Topic]; !exists {
+			b.partitions[msg.Topic] = make([][]kafka.Message, 1)
+		}
+		partitions := b.partitions[msg.Topic]
+		if len(msg.Key) > 0 {
+			h := fnv.New32a()
+			_, _ = h.Write(msg.Key)
+			msg.Partition = int(h.Sum32() % uint32(len(partitions))) // #nosec G115 -- number of partitions is small
+		}
+		if msg.Partition < 0 || msg.Partition >= len(partitions) {
+			return fmt.Errorf("%w: %s/%d", ErrUnknownTopicOrPartition, msg.Topic, msg.Partition)
+		}
+		if msg.Time.IsZero() {
+			msg.Time = time.Now()
+		}
+		msg.Offset = int64(len(partitions[msg.Partition]))
+		partitions[msg.Partition] = append(partitions[msg.Partition], msg)
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return nil
+}
+
+// Messages returns all messages of the topic ordered by partition and offset
+func (b *Broker) Messages(topic string) []kafka.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var messages []kafka.Message
+	for _, partition := range b.partitions[topic] {
+		messages = append(messages, partition...)
+	}
+	return messages
+}
+
+// CommittedOffset returns the next offset the consumer group will consume from the topic partition,
+// and false if the group didn't commit any offset yet
+func (b *Broker) CommittedOffset(group string, topic string, partition int) (int64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	offset, ok := b.committed[groupPartition{group: group, topic: topic, partition: partition}]
+	return offset, ok
+}
+
+// Lag returns the number of messages of the topic that have not been committed by the consumer group
+func (b *Broker) Lag(group string, topic string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lag int64
+	for partition, messages := range b.partitions[topic] {
+		lag += int64(len(messages)) - b.committed[groupPartition{group: group, topic: topic, partition: partition}]
+	}
+	return lag
+}
+
+// fetch returns the next message for the reader if one is available, and a channel that is closed
+// once new messages are produced otherwise
+func (b *Broker) fetch(r *Reader) (kafka.Message, bool, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, topic := range r.topics {
+		partitions := b.partitions[topic]
+		for partition := range partitions {
+			if r.config.GroupID == "" && partition != r.config.Partition {
+				continue
+			}
+			key := groupPartition{group: r.config.GroupID, topic: topic, partition: partition}
+			position, ok := r.positions[key]
+			if r.config.GroupID != "" {
+				position, ok = b.positions[key]
+				if !ok {
+					position, ok = b.committed[key]
+				}
+			}
+			if !ok {
+				position = b.startOffset(r, key, len(partitions[partition]))
+			}
+			if position >= int64(len(partitions[partition])) {
+				b.setPosition(r, key, position)
+				continue
+			}
+			b.setPosition(r, key, position+1)
+			return partitions[partition][position], true, nil
+		}
+	}
+	return kafka.Message{}, false, b.changed
+}
+
+func (b *Broker) setPosition(r *Reader, key groupPartition, position int64) {
+	if r.config.GroupID == "" {
+		r.positions[key] = position
+		return
+	}
+	b.positions[key] = position
+}
+
+// startOffset resolves kafka.FirstOffset and kafka.LastOffset for readers without position or committed offset,
+// the last offset refers to the end of the partition when the reader has been created
+func (b *Broker) startOffset(r *Reader, key groupPartition, size int) int64 {
+	switch {
+	case r.config.StartOffset == kafka.LastOffset:
+		return r.endOffsets[key]
+	case r.config.StartOffset > 0:
+		return min(r.config.StartOffset, int64(size))
+	default:
+		return 0
+	}
+}
+
+// endOffsets returns the current end of all partitions of the topics
+func (b *Broker) endOffsets(group string, topics []string) map[groupPartition]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	offsets := map[groupPartition]int64{}
+	for _, topic := range topics {
+		for partition, messages := range b.partitions[topic] {
+			offsets[groupPartition{group: group, topic: topic, partition: partition}] = int64(len(messages))
+		}
+	}
+	return offsets
+}
+
+func (b *Broker) commit(group string, messages ...kafka.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, msg := range messages {
+		key := groupPartition{group: group, topic: msg.Topic, partition: msg.Partition}
+		b.committed[key] = max(b.committed[key], msg.Offset+1)
+	}
+}
+
+func (b *Broker) join(group string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.members[group]++
+}
+
+// leave removes a reader from the group, if it was the last one uncommitted messages are delivered again
+// to the next reader of the group, similar to a rebalance
+func (b *Broker) leave(group string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.members[group]--
+	if b.members[group] > 0 {
+		return
+	}
+	for key := range b.positions {
+		if key.group == group {
+			delete(b.positions, key)
+		}
+	}
+}
+
+// topicsOf returns the topics a reader consumes
+func topicsOf(config kafka.ReaderConfig) []string {
+	if len(config.GroupTopics) > 0 {
+		return slices.Clone(config.GroupTopics)
+	}
+	return []string{config.Topic}
+}
@@ -0,0 +1,154 @@
+package pollytest
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/pkg/polly"
+)
+
+const testTopic = "public.hello"
+
+// collector is a polly.HandleMessageFunc that records the received messages
+type collector struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+}
+
+func (c *collector) handle(_ context.Context, message kafka.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, message)
+}
+
+func (c *collector) values() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var values []string
+	for _, msg := range c.messages {
+		values = append(values, string(msg.Value))
+	}
+	return values
+}
+
+func produce(t *testing.T, broker *Broker, values ...string) {
+	t.Helper()
+	for _, v := range values {
+		assert.NoError(t, broker.Produce(kafka.Message{Topic: testTopic, Value: []byte(v)}))
+	}
+}
+
+func TestPollWithConsumerGroup(t *testing.T) {
+	broker := NewBroker()
+	produce(t, broker, "one", "two", "three")
+	client := polly.NewClient(&polly.Options{ConsumerGroupID: "app", ConsumerMaxReceive: 3}, polly.WithReaderFactory(broker.NewReader))
+
+	c := &collector{}
+	assert.NoError(t, client.Poll(context.Background(), kafka.ReaderConfig{Topic: testTopic}, c.handle))
+	assert.Equal(t, []string{"one", "two", "three"}, c.values())
+	offset, ok := broker.CommittedOffset("app", testTopic, 0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), offset)
+	assert.Zero(t, broker.Lag("app", testTopic))
+
+	// the group resumes from its committed offset, the reader blocks until a message is produced
+	produce(t, broker, "four")
+	c = &collector{}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		produce(t, broker, "five")
+	}()
+	client = polly.NewClient(&polly.Options{ConsumerGroupID: "app", ConsumerMaxReceive: 2}, polly.WithReaderFactory(broker.NewReader))
+	assert.NoError(t, client.Poll(context.Background(), kafka.ReaderConfig{Topic: testTopic}, c.handle))
+	assert.Equal(t, []string{"four", "five"}, c.values())
+}
+
+func TestPollTimeout(t *testing.T) {
+	broker := NewBroker()
+	client := polly.NewClient(&polly.Options{ConsumerGroupID: "app", ConsumerMaxReceive: -1}, polly.WithReaderFactory(broker.NewReader))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c := &collector{}
+	assert.NoError(t, client.Poll(ctx, kafka.ReaderConfig{Topic: testTopic}, c.handle))
+	assert.Empty(t, c.values())
+}
+
+func TestPartitionsAndKeys(t *testing.T) {
+	broker := NewBroker()
+	broker.CreateTopic(testTopic, 3)
+	assert.NoError(t, broker.Produce(
+		kafka.Message{Topic: testTopic, Key: []byte("k1"), Value: []byte("a")},
+		kafka.Message{Topic: testTopic, Key: []byte("k1"), Value: []byte("b")},
+		kafka.Message{Topic: testTopic, Partition: 2, Value: []byte("c")},
+	))
+	assert.ErrorIs(t, broker.Produce(kafka.Message{Topic: testTopic, Partition: 3}), ErrUnknownTopicOrPartition)
+	assert.Error(t, broker.Produce(kafka.Message{Value: []byte("no topic")}))
+
+	messages := broker.Messages(testTopic)
+	assert.Len(t, messages, 3)
+	var a, b kafka.Message
+	for _, msg := range messages {
+		switch string(msg.Value) {
+		case "a":
+			a = msg
+		case "b":
+			b = msg
+		}
+	}
+	assert.Equal(t, a.Partition, b.Partition, "same key, same partition")
+	assert.Equal(t, a.Offset+1, b.Offset)
+	assert.False(t, a.Time.IsZero())
+
+	// reader without group consumes a single partition and doesn't commit
+	r := broker.NewReader(kafka.ReaderConfig{Topic: testTopic, Partition: 2, StartOffset: kafka.FirstOffset})
+	msg, err := r.ReadMessage(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "c", string(msg.Value))
+	assert.Equal(t, int64(0), msg.Offset)
+	assert.NoError(t, r.Close())
+	_, err = r.ReadMessage(context.Background())
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestStartLastOffset(t *testing.T) {
+	broker := NewBroker()
+	produce(t, broker, "old")
+	r := broker.NewReader(kafka.ReaderConfig{Topic: testTopic, GroupID: "new", StartOffset: kafka.LastOffset})
+	defer func() { _ = r.Close() }()
+	produce(t, broker, "new")
+	msg, err := r.ReadMessage(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(msg.Value))
+}
+
+func TestGroupMembersShareMessages(t *testing.T) {
+	broker := NewBroker()
+	produce(t, broker, "one", "two")
+	config := kafka.ReaderConfig{GroupTopics: []string{testTopic}, GroupID: "app"}
+	r1, r2 := broker.NewReader(config).(*Reader), broker.NewReader(config).(*Reader)
+	ctx := context.Background()
+
+	m1, err := r1.FetchMessage(ctx)
+	assert.NoError(t, err)
+	m2, err := r2.FetchMessage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(m1.Value))
+	assert.Equal(t, "two", string(m2.Value))
+
+	// only the first message is committed, the second is delivered again once all members left
+	assert.NoError(t, r1.CommitMessages(ctx, m1))
+	assert.Equal(t, int64(1), broker.Lag("app", testTopic))
+	assert.NoError(t, r1.Close())
+	assert.NoError(t, r2.Close())
+	r3 := broker.NewReader(config)
+	defer func() { _ = r3.Close() }()
+	m3, err := r3.ReadMessage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(m3.Value))
+	assert.Zero(t, broker.Lag("app", testTopic))
+}
@@ -0,0 +1,98 @@
+package pollytest
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/tillkuhn/rubin/pkg/polly"
+)
+
+// Reader is an in-memory polly.MessageReader which consumes messages from a Broker. Like kafka.Reader, readers
+// with GroupID consume all partitions of Topic or GroupTopics and commit offsets, readers without GroupID consume
+// the configured Partition of Topic. Readers of the same group share the messages, each message is delivered to one of them
+type Reader struct {
+	broker    *Broker
+	config    kafka.ReaderConfig
+	topics    []string
+	positions map[groupPartition]int64 // used by readers without group, guarded by broker.mu
+	// endOffsets are the partition ends at creation time, used to resolve kafka.LastOffset
+	endOffsets map[groupPartition]int64
+	closeOnce  sync.Once
+	closed     chan struct{}
+}
+
+var _ polly.MessageReader = (*Reader)(nil)
+
+// NewReader returns a Reader for the config, it can be passed to polly.WithReaderFactory
+func (b *Broker) NewReader(config kafka.ReaderConfig) polly.MessageReader {
+	r := &Reader{
+		broker:    b,
+		config:    config,
+		topics:    topicsOf(config),
+		positions: map[groupPartition]int64{},
+		closed:    make(chan struct{}),
+	}
+	r.endOffsets = b.endOffsets(config.GroupID, r.topics)
+	if config.GroupID != "" {
+		b.join(config.GroupID)
+	}
+	return r
+}
+
+// ReadMessage blocks until the next message is available and commits it if the reader is part of a consumer group.
+// It returns io.EOF if the reader has been closed and the context error if ctx is done
+func (r *Reader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		return msg, err
+	}
+	return msg, r.CommitMessages(ctx, msg)
+}
+
+// FetchMessage is like ReadMessage but doesn't commit the message, see CommitMessages
+func (r *Reader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		select {
+		case <-r.closed:
+			return kafka.Message{}, io.EOF
+		default:
+		}
+		msg, ok, changed := r.broker.fetch(r)
+		if ok {
+			return msg, nil
+		}
+		select {
+		case <-changed:
+		case <-r.closed:
+			return kafka.Message{}, io.EOF
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		}
+	}
+}
+
+// CommitMessages commits the offsets of the messages for the consumer group, it's a no-op for readers without group
+func (r *Reader) CommitMessages(_ context.Context, messages ...kafka.Message) error {
+	if r.config.GroupID != "" {
+		r.broker.commit(r.config.GroupID, messages...)
+	}
+	return nil
+}
+
+// Config returns the config the reader has been created with
+func (r *Reader) Config() kafka.ReaderConfig {
+	return r.config
+}
+
+// Close closes the reader, pending and subsequent reads return io.EOF
+func (r *Reader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		if r.config.GroupID != "" {
+			r.broker.leave(r.config.GroupID)
+		}
+	})
+	return nil
+}
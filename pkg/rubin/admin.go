@@ -0,0 +1,190 @@
+package rubin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/confluentinc/kafka-rest-sdk-go/kafkarestv3"
+)
+
+// APIError is returned if the REST Proxy responds with a non 2xx status code, it wraps the generic client
+// response error and provides status and error code (e.g. 40301 if the API key is not authorized) for
+// fine-grained error handling. Message is empty if the body is not a JSON error, e.g. 401 responses are HTML
+type APIError struct {
+	StatusCode int
+	ErrorCode  int32
+	Message    string
+	Method     string
+	URL        string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: unexpected http status code %d for %s %s: %s", errClientResponse, e.StatusCode, e.Method, e.URL, e.Body)
+}
+
+func (e *APIError) Unwrap() error {
+	return errClientResponse
+}
+
+// TopicSpec describes a topic to be created, zero values for partitions count and replication factor
+// mean that the cluster defaults are used. Configs contains topic configs such as retention.ms
+type TopicSpec struct {
+	Name              string
+	PartitionsCount   int32
+	ReplicationFactor int32
+	Configs           map[string]string
+}
+
+// ListClusters returns the clusters managed by the REST Proxy (GET /clusters), usually there's exactly one
+func (c *Client) ListClusters(ctx context.Context) ([]kafkarestv3.ClusterData, error) {
+	var clusters kafkarestv3.ClusterDataList
+	clusterEndpoint := c.options.ClusterEndpoint()
+	err := c.doJSON(ctx, http.MethodGet, clusterEndpoint[:strings.LastIndex(clusterEndpoint, "/")], nil, &clusters)
+	return clusters.Data, err
+}
+
+// DescribeCluster returns the cluster metadata (GET /clusters/{cluster_id})
+func (c *Client) DescribeCluster(ctx context.Context) (kafkarestv3.ClusterData, error) {
+	var cluster kafkarestv3.ClusterData
+	err := c.doJSON(ctx, http.MethodGet, c.options.ClusterEndpoint(), nil, &cluster)
+	return cluster, err
+}
+
+// ListTopics returns the topics of the cluster (GET /clusters/{cluster_id}/topics)
+func (c *Client) ListTopics(ctx context.Context) ([]kafkarestv3.TopicData, error) {
+	var topics kafkarestv3.TopicDataList
+	err := c.doJSON(ctx, http.MethodGet, c.options.ClusterEndpoint()+"/topics", nil, &topics)
+	return topics.Data, err
+}
+
+// DescribeTopic returns the metadata of a single topic (GET /clusters/{cluster_id}/topics/{topic_name})
+func (c *Client) DescribeTopic(ctx context.Context, topic string) (kafkarestv3.TopicData, error) {
+	var topicData kafkarestv3.TopicData
+	err := c.doJSON(ctx, http.MethodGet, c.topicEndpoint(topic), nil, &topicData)
+	return topicData, err
+}
+
+// ListTopicConfigs returns all configs of a topic incl. defaults (GET /clusters/{cluster_id}/topics/{topic_name}/configs)
+func (c *Client) ListTopicConfigs(ctx context.Context, topic string) ([]kafkarestv3.TopicConfigData, error) {
+	var configs kafkarestv3.TopicConfigDataList
+	err := c.doJSON(ctx, http.MethodGet, c.topicEndpoint(topic)+"/configs", nil, &configs)
+	return configs.Data, err
+}
+
+// CreateTopic creates a new topic (POST /clusters/{cluster_id}/topics) and returns its metadata
+func (c *Client) CreateTopic(ctx context.Context, spec TopicSpec) (kafkarestv3.TopicData, error) {
+	var topicData kafkarestv3.TopicData
+	req := kafkarestv3.CreateTopicRequestData{
+		TopicName:         spec.Name,
+		PartitionsCount:   spec.PartitionsCount,
+		ReplicationFactor: spec.ReplicationFactor,
+	}
+	for _, name := range slices.Sorted(maps.Keys(spec.Configs)) {
+		value := spec.Configs[name]
+		req.Configs = append(req.Configs, kafkarestv3.CreateTopicRequestDataConfigs{Name: name, Value: &value})
+	}
+	err := c.doJSON(ctx, http.MethodPost, c.options.ClusterEndpoint()+"/topics", req, &topicData)
+	return topicData, err
+}
+
+// DeleteTopic deletes a topic (DELETE /clusters/{cluster_id}/topics/{topic_name}), use with care!
+func (c *Client) DeleteTopic(ctx context.Context, topic string) error {
+	return c.doJSON(ctx, http.MethodDelete, c.topicEndpoint(topic), nil, nil)
+}
+
+// ListConsumerGroups returns the consumer groups of the cluster (GET /clusters/{cluster_id}/consumer-groups)
+func (c *Client) ListConsumerGroups(ctx context.Context) ([]kafkarestv3.ConsumerGroupData, error) {
+	var groups kafkarestv3.ConsumerGroupDataList
+	err := c.doJSON(ctx, http.MethodGet, c.options.ClusterEndpoint()+"/consumer-groups", nil, &groups)
+	return groups.Data, err
+}
+
+// ConsumerGroupLagSummary returns max and total lag of a consumer group
+// (GET /clusters/{cluster_id}/consumer-groups/{consumer_group_id}/lag-summary)
+func (c *Client) ConsumerGroupLagSummary(ctx context.Context, group string) (kafkarestv3.ConsumerGroupLagSummaryData, error) {
+	var summary kafkarestv3.ConsumerGroupLagSummaryData
+	err := c.doJSON(ctx, http.MethodGet, c.consumerGroupEndpoint(group)+"/lag-summary", nil, &summary)
+	return summary, err
+}
+
+// ConsumerGroupLags returns the lag per topic partition of a consumer group
+// (GET /clusters/{cluster_id}/consumer-groups/{consumer_group_id}/lags)
+func (c *Client) ConsumerGroupLags(ctx context.Context, group string) ([]kafkarestv3.ConsumerLagData, error) {
+	var lags kafkarestv3.ConsumerLagDataList
+	err := c.doJSON(ctx, http.MethodGet, c.consumerGroupEndpoint(group)+"/lags", nil, &lags)
+	return lags.Data, err
+}
+
+func (c *Client) topicEndpoint(topic string) string {
+	return c.options.ClusterEndpoint() + "/topics/" + url.PathEscape(topic)
+}
+
+func (c *Client) consumerGroupEndpoint(group string) string {
+	return c.options.ClusterEndpoint() + "/consumer-groups/" + url.PathEscape(group)
+}
+
+// doJSON sends an authenticated request with optional JSON body to the REST Proxy and unmarshals
+// the JSON response into result (if not nil), all 2xx status codes are considered successful
+func (c *Client) doJSON(ctx context.Context, method string, endpoint string, body interface{}, result interface{}) error {
+	if c.initErr != nil {
+		return fmt.Errorf("%w: client not initialized (%s)", errClientResponse, c.initErr.Error())
+	}
+	var reqBody io.Reader
+	var encoding string
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("%w: cannot marshal request body: %s", errClientResponse, err.Error())
+		}
+		if b, encoding, err = c.encodeBody(b); err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return fmt.Errorf("%w: cannot create http request: %s", errClientResponse, err.Error())
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	req.Header.Add("Authorization", "Basic "+c.options.BasicAuth())
+	c.checkDumpRequest(req)
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: cannot send http request %s", errClientResponse, err.Error())
+	}
+	c.checkDumpResponse(res)
+	defer closeSilently(res.Body)
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("%w: cannot read response body %s", errClientResponse, err.Error())
+	}
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		apiErr := &APIError{StatusCode: res.StatusCode, Method: method, URL: endpoint, Body: string(resBody)}
+		var restErr kafkarestv3.Error
+		if json.Unmarshal(resBody, &restErr) == nil && restErr.Message != nil {
+			apiErr.ErrorCode, apiErr.Message = restErr.ErrorCode, *restErr.Message
+		}
+		return apiErr
+	}
+	if result == nil || len(resBody) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resBody, result); err != nil {
+		return fmt.Errorf("%w: unexpected api response: %s", errClientResponse, string(resBody))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package rubin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+)
+
+func TestListTopics(t *testing.T) {
+	srv := testutil.ServerMock()
+	defer srv.Close()
+	cc := NewClient(&Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw"})
+	topics, err := cc.ListTopics(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, topics, 2)
+	assert.Equal(t, "public.hello", topics[0].TopicName)
+	assert.Equal(t, int32(6), topics[0].PartitionsCount)
+
+	// unknown cluster
+	cc = NewClient(&Options{RestEndpoint: srv.URL, ClusterID: "unknown", ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw"})
+	_, err = cc.ListTopics(context.Background())
+	assert.ErrorContains(t, err, "unexpected http status code 404")
+}
+
+func TestDescribeClusterAndTopic(t *testing.T) {
+	srv := testutil.ServerMock()
+	defer srv.Close()
+	cc := NewClient(&Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw"})
+	cluster, err := cc.DescribeCluster(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, testutil.ClusterID, cluster.ClusterId)
+	topic, err := cc.DescribeTopic(context.Background(), "public.hello")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), topic.ReplicationFactor)
+
+	// no credentials result in 401 html page
+	cc = NewClient(&Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID})
+	_, err = cc.DescribeCluster(context.Background())
+	var apiErr *APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.ErrorIs(t, err, errClientResponse)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	assert.Empty(t, apiErr.Message)
+}
+
+func TestTopicAndGroupAdmin(t *testing.T) {
+	srv := testutil.ServerMock()
+	defer srv.Close()
+	ctx := context.Background()
+	cc := NewClient(&Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw"})
+
+	clusters, err := cc.ListClusters(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, clusters, 1)
+
+	configs, err := cc.ListTopicConfigs(ctx, "public.hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "retention.ms", configs[1].Name)
+
+	topic, err := cc.CreateTopic(ctx, TopicSpec{Name: "public.hello", PartitionsCount: 6, Configs: map[string]string{"retention.ms": "1000"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "public.hello", topic.TopicName)
+	assert.NoError(t, cc.DeleteTopic(ctx, "public.hello"))
+	assert.ErrorIs(t, cc.DeleteTopic(ctx, "public.unknown"), errClientResponse)
+
+	groups, err := cc.ListConsumerGroups(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+	summary, err := cc.ConsumerGroupLagSummary(ctx, "app.consumer")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(110), summary.TotalLag)
+	lags, err := cc.ConsumerGroupLags(ctx, "app.consumer")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), lags[1].Lag)
+}
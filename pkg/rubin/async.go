@@ -0,0 +1,295 @@
+package rubin
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Overflow policies of the AsyncProducer, which apply if the queue is full
+const (
+	// OverflowBlock blocks Produce until there's space in the queue or the context is done
+	OverflowBlock = "block"
+	// OverflowDropOldest removes the oldest queued record to make room, the dropped record is reported with ErrRecordDropped
+	OverflowDropOldest = "drop-oldest"
+	// OverflowError returns ErrQueueFull immediately
+	OverflowError = "error"
+)
+
+const (
+	defaultQueueSize = 1000
+	defaultBatchSize = 100
+	defaultLinger    = 100 * time.Millisecond
+	// defaultSpoolReplayInterval is the interval to re-send spooled records
+	defaultSpoolReplayInterval = 30 * time.Second
+)
+
+var (
+	// ErrQueueFull is returned by AsyncProducer.Produce if the queue is full and the overflow policy is OverflowError
+	ErrQueueFull = errors.New("async producer queue is full")
+	// ErrRecordDropped is reported for records removed from the queue by overflow policy OverflowDropOldest
+	ErrRecordDropped = errors.New("record dropped from async producer queue")
+	// ErrProducerClosed is returned if records are produced after the AsyncProducer has been closed
+	ErrProducerClosed  = errors.New("async producer is closed")
+	errInvalidOverflow = errors.New("invalid overflow policy")
+)
+
+// AsyncOptions configure queue and flush triggers of the AsyncProducer, zero values are replaced by defaults
+type AsyncOptions struct {
+	// QueueSize is the max number of records waiting to be sent (default 1000)
+	QueueSize int
+	// BatchSize triggers a flush as soon as the given number of records is queued (default 100)
+	BatchSize int
+	// Linger is the max time a record waits in the queue before a flush is triggered (default 100ms)
+	Linger time.Duration
+	// Overflow policy if the queue is full, one of OverflowBlock (default), OverflowDropOldest or OverflowError
+	Overflow string
+	// OnDelivery is called for each record once it has been sent (or failed / dropped), optional.
+	// It's called from the background goroutine (for dropped records from the producing goroutine), so it must
+	// be safe for concurrent use, should return quickly and must not produce with OverflowBlock
+	OnDelivery DeliveryFunc
+	// Spool is optional, records that failed due to temporary errors (see IsRetriable) are persisted in the spool
+	// and re-sent every SpoolReplayInterval (default 30s), as well as remaining records if Close times out
+	// (incl. records waiting for the rate limit or MaxInFlight)
+	Spool               *Spool
+	SpoolReplayInterval time.Duration
+}
+
+// DeliveryReport contains the result of an asynchronously produced record, Err is nil if the record was committed.
+// Spooled is true if the record could not be sent but has been persisted in the Spool, Err contains the original error
+type DeliveryReport struct {
+	Request  RecordRequest
+	Response RecordResponse
+	Err      error
+	Spooled  bool
+}
+
+// DeliveryFunc receives the delivery reports of an AsyncProducer
+type DeliveryFunc func(ctx context.Context, report DeliveryReport)
+
+// AsyncProducer queues records in memory and sends them in the background using the Client,
+// so callers don't pay the REST round trip. Records are sent if BatchSize records are queued,
+// after Linger time has passed or when Flush or Close is called
+type AsyncProducer struct {
+	client   *Client
+	options  AsyncOptions
+	queue    chan RecordRequest
+	flushReq chan chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	// ctx is used for sending records, it's independent of the contexts passed to Produce which are often request scoped
+	ctx    context.Context
+	cancel context.CancelFunc
+	// mu guards closed, Produce holds the read lock while enqueueing so no record is added after Close drained the queue
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsyncProducer returns an AsyncProducer which sends records via client, and starts its background goroutine.
+// Close must be called to flush pending records and stop the goroutine
+func NewAsyncProducer(ctx context.Context, client *Client, options AsyncOptions) (*AsyncProducer, error) {
+	options.QueueSize = cmp.Or(options.QueueSize, defaultQueueSize)
+	options.BatchSize = min(cmp.Or(options.BatchSize, defaultBatchSize), options.QueueSize)
+	options.Linger = cmp.Or(options.Linger, defaultLinger)
+	options.Overflow = cmp.Or(options.Overflow, OverflowBlock)
+	options.SpoolReplayInterval = cmp.Or(options.SpoolReplayInterval, defaultSpoolReplayInterval)
+	if options.Overflow != OverflowBlock && options.Overflow != OverflowDropOldest && options.Overflow != OverflowError {
+		return nil, fmt.Errorf("%w: %s, expected one of %s, %s, %s", errInvalidOverflow, options.Overflow, OverflowBlock, OverflowDropOldest, OverflowError)
+	}
+	sendCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	p := &AsyncProducer{
+		client:   client,
+		options:  options,
+		queue:    make(chan RecordRequest, options.QueueSize),
+		flushReq: make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		ctx:      sendCtx,
+		cancel:   cancel,
+	}
+	go p.run()
+	return p, nil
+}
+
+// Produce adds the record to the queue, the returned error only indicates whether the record was queued.
+// The result of the actual delivery is reported to AsyncOptions.OnDelivery
+func (p *AsyncProducer) Produce(ctx context.Context, request RecordRequest) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrProducerClosed
+	}
+	switch p.options.Overflow {
+	case OverflowError:
+		select {
+		case p.queue <- request:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case p.queue <- request:
+				return nil
+			default:
+				select {
+				case oldest := <-p.queue:
+					p.report(DeliveryReport{Request: oldest, Err: ErrRecordDropped})
+				default: // queue has been drained by the background goroutine in the meantime
+				}
+			}
+		}
+	default:
+		select {
+		case p.queue <- request:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Flush sends all records queued before the call and returns once they have been reported, or the context is done
+func (p *AsyncProducer) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case p.flushReq <- flushed:
+	case <-p.done:
+		return ErrProducerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting new records and sends the remaining ones. If the context is done before all records are sent,
+// in-flight requests are canceled and the remaining records are reported as failed. Subsequent calls are no-ops
+func (p *AsyncProducer) Close(ctx context.Context) error {
+	p.mu.Lock()
+	alreadyClosed := p.closed
+	p.closed = true
+	p.mu.Unlock()
+	if !alreadyClosed {
+		close(p.stop)
+	}
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-p.done
+		return ctx.Err()
+	}
+}
+
+// Len returns the number of records waiting in the queue
+func (p *AsyncProducer) Len() int {
+	return len(p.queue)
+}
+
+// run is the background loop that collects records into batches and sends them if a trigger fires
+func (p *AsyncProducer) run() {
+	defer close(p.done)
+	defer p.cancel()
+	batch := make([]RecordRequest, 0, p.options.BatchSize)
+	var linger <-chan time.Time // nil until the first record of a batch arrives, a nil channel never fires
+	var replay <-chan time.Time
+	if p.options.Spool != nil {
+		ticker := time.NewTicker(p.options.SpoolReplayInterval)
+		defer ticker.Stop()
+		replay = ticker.C
+	}
+	for {
+		select {
+		case <-replay:
+			p.replaySpool()
+			continue
+		case request := <-p.queue:
+			if len(batch) == 0 {
+				linger = time.After(p.options.Linger)
+			}
+			batch = append(batch, request)
+			if len(batch) < p.options.BatchSize {
+				continue
+			}
+		case <-linger:
+		case flushed := <-p.flushReq:
+			batch = p.drain(batch)
+			p.send(batch)
+			batch, linger = batch[:0], nil
+			close(flushed)
+			continue
+		case <-p.stop:
+			p.send(p.drain(batch))
+			return
+		}
+		p.send(batch)
+		batch, linger = batch[:0], nil
+	}
+}
+
+// drain appends all records that are currently queued to the batch without waiting for new ones
+func (p *AsyncProducer) drain(batch []RecordRequest) []RecordRequest {
+	for {
+		select {
+		case request := <-p.queue:
+			batch = append(batch, request)
+		default:
+			return batch
+		}
+	}
+}
+
+func (p *AsyncProducer) send(batch []RecordRequest) {
+	if len(batch) > 0 {
+		log.Ctx(p.ctx).Debug().Str("logger", "producer").Msgf("Sending batch of %d records, %d remaining in queue", len(batch), len(p.queue))
+	}
+	for _, request := range batch {
+		resp, err := p.client.Produce(p.ctx, request)
+		report := DeliveryReport{Request: request, Response: resp, Err: err}
+		// records still waiting for the limiter when Close times out haven't been sent, so they're spooled as well
+		if err != nil && p.options.Spool != nil && (IsRetriable(err) || errors.Is(err, errLimiterAborted)) {
+			if spoolErr := p.options.Spool.Append(request); spoolErr != nil {
+				report.Err = errors.Join(err, spoolErr)
+			} else {
+				report.Spooled = true
+			}
+		}
+		p.report(report)
+	}
+}
+
+// replaySpool re-sends spooled records, a failure is only logged since the records remain in the spool
+func (p *AsyncProducer) replaySpool() {
+	stats, err := p.options.Spool.Replay(p.ctx, func(ctx context.Context, request RecordRequest) error {
+		_, err := p.client.Produce(ctx, request)
+		return err
+	})
+	logger := log.Ctx(p.ctx).With().Str("logger", "producer").Logger()
+	if err != nil {
+		logger.Warn().Err(err).Msgf("Spool replay interrupted, sent=%d remaining=%d", stats.Sent, stats.Remaining)
+	} else if stats.Sent > 0 {
+		logger.Info().Msgf("Spool replay completed, sent=%d corrupted=%d", stats.Sent, stats.Corrupted)
+	}
+}
+
+func (p *AsyncProducer) report(report DeliveryReport) {
+	if p.options.OnDelivery != nil {
+		p.options.OnDelivery(p.ctx, report)
+		return
+	}
+	if report.Err != nil {
+		log.Ctx(p.ctx).Error().Str("logger", "producer").Err(report.Err).Msgf("Async delivery to topic %s failed", report.Request.Topic)
+	}
+}
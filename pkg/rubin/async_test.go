@@ -0,0 +1,128 @@
+package rubin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+)
+
+// deliveries collects delivery reports for assertions
+type deliveries struct {
+	mu      sync.Mutex
+	reports []DeliveryReport
+}
+
+func (d *deliveries) onDelivery(_ context.Context, report DeliveryReport) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reports = append(d.reports, report)
+}
+
+func (d *deliveries) errs() []error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []error
+	for _, r := range d.reports {
+		errs = append(errs, r.Err)
+	}
+	return errs
+}
+
+func TestAsyncProducerFlushAndClose(t *testing.T) {
+	srv := testutil.ServerMock()
+	defer srv.Close()
+	ctx := context.Background()
+	var d deliveries
+	p, err := NewAsyncProducer(ctx, testClient(srv.URL), AsyncOptions{Linger: time.Hour, OnDelivery: d.onDelivery})
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, p.Produce(ctx, RecordRequest{Topic: testutil.Topic(200), Data: "hello"}))
+	}
+	assert.Empty(t, d.errs()) // linger of one hour
+	assert.NoError(t, p.Flush(ctx))
+	assert.Equal(t, []error{nil, nil, nil}, d.errs())
+	assert.Equal(t, int32(42), d.reports[0].Response.Offset)
+
+	assert.NoError(t, p.Produce(ctx, RecordRequest{Topic: "unknown", Data: "hello"}))
+	assert.NoError(t, p.Close(ctx))
+	assert.Len(t, d.errs(), 4)
+	assert.ErrorIs(t, d.errs()[3], errClientResponse)
+	assert.ErrorIs(t, p.Produce(ctx, RecordRequest{Topic: testutil.Topic(200)}), ErrProducerClosed)
+	assert.ErrorIs(t, p.Flush(ctx), ErrProducerClosed)
+	assert.NoError(t, p.Close(ctx)) // no-op
+}
+
+func TestAsyncProducerTriggers(t *testing.T) {
+	srv := testutil.ServerMock()
+	defer srv.Close()
+	ctx := context.Background()
+	var d deliveries
+	// batch size
+	p, err := NewAsyncProducer(ctx, testClient(srv.URL), AsyncOptions{BatchSize: 2, Linger: time.Hour, OnDelivery: d.onDelivery})
+	assert.NoError(t, err)
+	assert.NoError(t, p.Produce(ctx, RecordRequest{Topic: testutil.Topic(200), Data: "1"}))
+	assert.NoError(t, p.Produce(ctx, RecordRequest{Topic: testutil.Topic(200), Data: "2"}))
+	assert.Eventually(t, func() bool { return len(d.errs()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.NoError(t, p.Close(ctx))
+
+	// linger
+	p, err = NewAsyncProducer(ctx, testClient(srv.URL), AsyncOptions{Linger: 10 * time.Millisecond, OnDelivery: d.onDelivery})
+	assert.NoError(t, err)
+	assert.NoError(t, p.Produce(ctx, RecordRequest{Topic: testutil.Topic(200), Data: "3"}))
+	assert.Eventually(t, func() bool { return len(d.errs()) == 3 }, time.Second, 5*time.Millisecond)
+	assert.NoError(t, p.Close(ctx))
+
+	_, err = NewAsyncProducer(ctx, testClient(srv.URL), AsyncOptions{Overflow: "ignore"})
+	assert.ErrorContains(t, err, "invalid overflow policy")
+}
+
+func TestAsyncProducerOverflow(t *testing.T) {
+	// slow server keeps the background goroutine busy, so the queue fills up
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-release
+		respBytes, _ := os.ReadFile(testutil.TestDataDir + "/response-200.json")
+		_, _ = w.Write(respBytes)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	var d deliveries
+
+	for _, overflow := range []string{OverflowError, OverflowDropOldest, OverflowBlock} {
+		p, err := NewAsyncProducer(ctx, testClient(srv.URL), AsyncOptions{QueueSize: 1, Overflow: overflow, OnDelivery: d.onDelivery})
+		assert.NoError(t, err)
+		// first record is picked up by the background goroutine and blocks in the http request, second is queued
+		assert.NoError(t, p.Produce(ctx, RecordRequest{Topic: "first", Data: "1"}))
+		assert.Eventually(t, func() bool { return p.Len() == 0 }, time.Second, time.Millisecond)
+		assert.NoError(t, p.Produce(ctx, RecordRequest{Topic: "second", Data: "2"}))
+
+		switch overflow {
+		case OverflowError:
+			assert.ErrorIs(t, p.Produce(ctx, RecordRequest{Topic: "third", Data: "3"}), ErrQueueFull)
+		case OverflowDropOldest:
+			assert.NoError(t, p.Produce(ctx, RecordRequest{Topic: "third", Data: "3"}))
+			assert.ErrorIs(t, d.errs()[len(d.errs())-1], ErrRecordDropped)
+			assert.Equal(t, "second", d.reports[len(d.reports)-1].Request.Topic)
+		case OverflowBlock:
+			tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			assert.ErrorIs(t, p.Produce(tctx, RecordRequest{Topic: "third", Data: "3"}), context.DeadlineExceeded)
+			cancel()
+		}
+		// close with timeout cancels the in-flight request
+		tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		assert.ErrorIs(t, p.Close(tctx), context.DeadlineExceeded)
+		cancel()
+	}
+	close(release)
+}
+
+func testClient(endpoint string) *Client {
+	return NewClient(&Options{RestEndpoint: endpoint, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw"})
+}
@@ -0,0 +1,191 @@
+package rubin
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultBreakerMinRequests = 10
+	defaultBreakerWindow      = time.Minute
+	defaultBreakerCoolDown    = 30 * time.Second
+)
+
+// ErrCircuitOpen is wrapped by CircuitOpenError, use errors.Is(err, ErrCircuitOpen) to detect fail-fast errors
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is returned by Produce without sending the request while the circuit breaker is open,
+// RetryAfter is the remaining cool-down (zero if the breaker is half-open and a probe request is already running)
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrCircuitOpen, e.RetryAfter)
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// CircuitState is the state of the circuit breaker, see Client.CircuitState
+type CircuitState int
+
+// States of the circuit breaker
+const (
+	// CircuitClosed lets all requests pass, it's also the state if the circuit breaker is disabled
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all requests fast until the cool-down has passed
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe request pass, which decides whether the breaker is closed or opened again
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker counts requests and failures in a fixed window while closed, and opens if the failure ratio is
+// exceeded after at least minRequests. Only outages count as failure, i.e. network errors, timeouts and 5xx responses,
+// but not permanent errors such as 401 or 429 Too Many Requests which show that the REST Proxy is up
+type circuitBreaker struct {
+	ratio       float64
+	minRequests int
+	window      time.Duration
+	coolDown    time.Duration
+
+	mu          sync.Mutex
+	state       CircuitState
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	probing     bool
+}
+
+// newCircuitBreaker returns nil if the breaker is disabled (Options.BreakerFailureRatio is zero)
+func newCircuitBreaker(options *Options) *circuitBreaker {
+	if options.BreakerFailureRatio <= 0 {
+		return nil
+	}
+	return &circuitBreaker{
+		ratio:       options.BreakerFailureRatio,
+		minRequests: cmp.Or(options.BreakerMinRequests, defaultBreakerMinRequests),
+		window:      cmp.Or(options.BreakerWindow, defaultBreakerWindow),
+		coolDown:    cmp.Or(options.BreakerCoolDown, defaultBreakerCoolDown),
+		windowStart: time.Now(),
+	}
+}
+
+// allow returns a CircuitOpenError if the request must not be sent, otherwise record must be called with its result
+func (b *circuitBreaker) allow(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.state == CircuitOpen {
+		if remaining := b.openedAt.Add(b.coolDown).Sub(now); remaining > 0 {
+			return &CircuitOpenError{RetryAfter: remaining}
+		}
+		b.transition(ctx, CircuitHalfOpen)
+	}
+	if b.state == CircuitHalfOpen {
+		if b.probing {
+			return &CircuitOpenError{}
+		}
+		b.probing = true
+		return nil
+	}
+	if now.Sub(b.windowStart) >= b.window {
+		b.requests, b.failures, b.windowStart = 0, 0, now
+	}
+	return nil
+}
+
+// record updates the breaker with the result of a request that has been allowed
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		// canceled by the caller, so the request tells nothing about the REST Proxy
+		b.probing = false
+		return
+	}
+	failed := isOutage(err)
+	switch b.state {
+	case CircuitHalfOpen:
+		b.probing = false
+		if failed {
+			b.transition(ctx, CircuitOpen)
+		} else {
+			b.transition(ctx, CircuitClosed)
+		}
+	case CircuitClosed:
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.minRequests && float64(b.failures)/float64(b.requests) >= b.ratio {
+			b.transition(ctx, CircuitOpen)
+		}
+	case CircuitOpen:
+		// result of a request that has been sent before the breaker opened
+	}
+}
+
+// transition changes the state and resets the counters, caller must hold the lock
+func (b *circuitBreaker) transition(ctx context.Context, state CircuitState) {
+	logger := log.Ctx(ctx).With().Str("logger", "breaker").Logger()
+	if state == CircuitOpen {
+		b.openedAt = time.Now()
+		logger.Warn().Int("failures", b.failures).Int("requests", b.requests).
+			Msgf("Circuit breaker changed from %s to %s, failing fast for %s", b.state, state, b.coolDown)
+	} else {
+		logger.Info().Msgf("Circuit breaker changed from %s to %s", b.state, state)
+	}
+	b.state = state
+	b.requests, b.failures, b.windowStart = 0, 0, time.Now()
+}
+
+func (b *circuitBreaker) currentState() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.coolDown {
+		return CircuitHalfOpen // the next request will be the probe
+	}
+	return b.state
+}
+
+// isOutage returns true for errors which indicate that the REST Proxy is unavailable
+func isOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	return IsRetriable(err)
+}
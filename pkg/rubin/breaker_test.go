@@ -0,0 +1,127 @@
+package rubin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var down atomic.Bool
+	var requests atomic.Int32
+	mock := testutil.ServerMock()
+	defer mock.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mock.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	cc := NewClient(&Options{
+		RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw",
+		BreakerFailureRatio: 0.5, BreakerMinRequests: 4, BreakerCoolDown: 50 * time.Millisecond,
+	})
+	ctx := context.Background()
+	request := RecordRequest{Topic: testutil.Topic(200), Data: "Hello"}
+	produce := func() error {
+		_, err := cc.Produce(ctx, request)
+		return err
+	}
+
+	assert.Equal(t, CircuitClosed, cc.CircuitState())
+	assert.NoError(t, produce())
+	assert.NoError(t, produce())
+	down.Store(true)
+	assert.Error(t, produce())
+	assert.Equal(t, CircuitClosed, cc.CircuitState(), "min requests not yet reached")
+	assert.Error(t, produce())
+	assert.Equal(t, CircuitOpen, cc.CircuitState())
+
+	sent := requests.Load()
+	err := produce()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	var openErr *CircuitOpenError
+	assert.True(t, errors.As(err, &openErr))
+	assert.Positive(t, openErr.RetryAfter)
+	assert.True(t, IsRetriable(err))
+	assert.Equal(t, sent, requests.Load(), "request fails fast without being sent")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, cc.CircuitState())
+	assert.Error(t, produce(), "probe fails")
+	assert.Equal(t, CircuitOpen, cc.CircuitState())
+
+	time.Sleep(60 * time.Millisecond)
+	down.Store(false)
+	assert.NoError(t, produce(), "probe succeeds")
+	assert.Equal(t, CircuitClosed, cc.CircuitState())
+	assert.NoError(t, produce())
+}
+
+func TestCircuitBreakerWithMaxInFlight(t *testing.T) {
+	mock := testutil.ServerMock()
+	defer mock.Close()
+	received, unblock := make(chan struct{}, 1), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-unblock
+		mock.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	cc := NewClient(&Options{
+		RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw",
+		MaxInFlight: 1, BreakerFailureRatio: 0.5, BreakerMinRequests: 1, BreakerCoolDown: 50 * time.Millisecond,
+	})
+	ctx := context.Background()
+	request := RecordRequest{Topic: testutil.Topic(200), Data: "Hello"}
+	done := make(chan error)
+	go func() {
+		_, err := cc.Produce(ctx, request)
+		done <- err
+	}()
+	<-received // the only in-flight slot is taken
+	cc.breaker.record(ctx, &APIError{StatusCode: http.StatusServiceUnavailable})
+	assert.Equal(t, CircuitOpen, cc.CircuitState())
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, err := cc.Produce(timeoutCtx, request)
+	assert.ErrorIs(t, err, ErrCircuitOpen, "fails fast without waiting for the in-flight slot")
+
+	time.Sleep(60 * time.Millisecond)
+	shortCtx, cancelShort := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancelShort()
+	_, err = cc.Produce(shortCtx, request)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "probe is allowed but waits for the in-flight slot")
+
+	close(unblock)
+	assert.NoError(t, <-done)
+	_, err = cc.Produce(ctx, request)
+	assert.NoError(t, err, "probe has been released, so the next request is allowed")
+	assert.Equal(t, CircuitClosed, cc.CircuitState())
+}
+
+func TestIsOutage(t *testing.T) {
+	assert.False(t, isOutage(nil))
+	assert.True(t, isOutage(&APIError{StatusCode: http.StatusBadGateway}))
+	assert.False(t, isOutage(&APIError{StatusCode: http.StatusTooManyRequests}), "rate limited means the proxy is up")
+	assert.False(t, isOutage(&APIError{StatusCode: http.StatusUnauthorized}))
+	assert.False(t, isOutage(fmt.Errorf("%w: invalid payload", errClientResponse)))
+
+	// the breaker is disabled by default
+	var disabled *circuitBreaker
+	assert.NoError(t, disabled.allow(context.Background()))
+	assert.Equal(t, CircuitClosed, disabled.currentState())
+	assert.Equal(t, "half-open", CircuitHalfOpen.String())
+}
@@ -2,11 +2,13 @@ package rubin
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	b64 "encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"strings"
@@ -35,6 +37,14 @@ var errClientResponse = errors.New("kafka client response error")
 type Client struct {
 	options    *Options
 	httpClient *http.Client
+	// initErr keeps errors during client setup (e.g. invalid TLS files) which are reported by Produce
+	initErr error
+	// validator is optional, see SetSchemaValidator
+	validator *SchemaValidator
+	// limiter throttles produce requests according to Options.RateLimit, RateLimitBytes and MaxInFlight
+	limiter *limiter
+	// breaker is nil unless enabled by Options.BreakerFailureRatio
+	breaker *circuitBreaker
 	// logger     *zerolog.Logger
 }
 
@@ -48,13 +58,36 @@ func NewClient(options *Options) *Client {
 		options.HTTPTimeout = defaultTimeout
 	}
 
+	httpClient, err := newHTTPClient(options)
+	if err == nil {
+		err = validateCompression(options.Compression)
+	}
 	return &Client{
 		options:    options,
-		httpClient: &http.Client{Timeout: options.HTTPTimeout},
+		httpClient: httpClient,
+		initErr:    err,
+		limiter:    newLimiter(options),
+		breaker:    newCircuitBreaker(options),
 		// logger:     &logger,
 	}
 }
 
+// newHTTPClient returns a http.Client with the configured timeout, and a custom transport if TLS options are set
+func newHTTPClient(options *Options) (*http.Client, error) {
+	httpClient := &http.Client{Timeout: options.HTTPTimeout}
+	if !options.TLS.IsCustom() {
+		return httpClient, nil
+	}
+	tlsConfig, err := options.TLS.Config()
+	if err != nil {
+		return httpClient, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	httpClient.Transport = transport
+	return httpClient, nil
+}
+
 // NewClientFromEnv Convenience function using default envconfig prefix for
 //
 //	opts, err := NewOptionsFromEnv()
@@ -67,6 +100,18 @@ func NewClientFromEnv() (*Client, error) {
 	return NewClient(opts), err
 }
 
+// SetSchemaValidator enables client-side validation of payloads, Produce returns a SchemaValidationError
+// without sending the record if the payload violates the schema registered for its topic or CloudEvent type
+func (c *Client) SetSchemaValidator(validator *SchemaValidator) {
+	c.validator = validator
+}
+
+// CircuitState returns the state of the circuit breaker e.g. for health checks, it's always CircuitClosed if
+// the breaker is disabled, see Options.BreakerFailureRatio
+func (c *Client) CircuitState() CircuitState {
+	return c.breaker.currentState()
+}
+
 // LogLevel allows dynamic configuration of LogLevel after the client has been initialized
 // func (c *Client) LogLevel(levelStr string) {
 //	logger = log.NewAtLevel(levelStr)
@@ -83,6 +128,11 @@ type RecordRequest struct {
 	Data    interface{}
 	Key     string
 	Headers map[string]string
+	// Partition is optional, if nil the partition is selected by the partitioner based on the key
+	Partition *int32
+	// Timestamp is optional, if zero the current time is used. Kafka timestamps have millisecond precision,
+	// so a custom timestamp (e.g. for backfills) is truncated to milliseconds
+	Timestamp time.Time
 	// AsCloudEvent section for CloudEvents specific attributes
 	AsCloudEvent bool
 	Source       string
@@ -94,29 +144,140 @@ type RecordRequest struct {
 type RecordResponse struct {
 	ErrorCode int `json:"error_code"`
 	kafkarestv3.ProduceResponse
+	// CloudEventID is the generated id of the CloudEvent if the record has been wrapped (RecordRequest.AsCloudEvent)
+	CloudEventID string `json:"-"`
+}
+
+// ProduceResult is a flat view of the RecordResponse with the metadata returned by the REST Proxy,
+// e.g. for machine-readable output. Timestamp is zero and sizes are 0 if the REST Proxy didn't return them
+type ProduceResult struct {
+	ClusterID    string    `json:"cluster_id"`
+	Topic        string    `json:"topic"`
+	Partition    int32     `json:"partition"`
+	Offset       int64     `json:"offset"`
+	Timestamp    time.Time `json:"timestamp,omitzero"`
+	KeySize      int64     `json:"key_size"`
+	ValueSize    int64     `json:"value_size"`
+	CloudEventID string    `json:"cloudevent_id,omitempty"`
+}
+
+// Result returns the typed produce result, nil timestamp and key / value metadata are mapped to zero values
+func (r RecordResponse) Result() ProduceResult {
+	result := ProduceResult{
+		ClusterID:    r.ClusterId,
+		Topic:        r.TopicName,
+		Partition:    r.PartitionId,
+		Offset:       int64(r.Offset),
+		CloudEventID: r.CloudEventID,
+	}
+	if r.Timestamp != nil {
+		result.Timestamp = *r.Timestamp
+	}
+	if r.Key != nil {
+		result.KeySize = r.Key.Size
+	}
+	if r.Value != nil {
+		result.ValueSize = r.Value.Size
+	}
+	return result
 }
 
 // Produce produces a Kafka Record into the given Topic
 func (c *Client) Produce(ctx context.Context, request RecordRequest) (RecordResponse, error) {
 	logger := log.Ctx(ctx).With().Str("logger", "producer").Logger()
 	// defer log.SyncSilently(logger)
+	var prodResp RecordResponse
+	if c.initErr != nil {
+		return prodResp, fmt.Errorf("%w: client not initialized (%s)", errClientResponse, c.initErr.Error())
+	}
+	if c.validator != nil {
+		if err := c.validator.Validate(ctx, request); err != nil {
+			return prodResp, err
+		}
+	}
+	prepared, err := c.prepare(request)
+	if err != nil {
+		return prodResp, err
+	}
+	if err := c.checkSize(prepared); err != nil {
+		return prodResp, err
+	}
+	if c.options.DryRun {
+		return c.dryRun(prepared)
+	}
+	body, encoding, err := c.encodeBody(prepared.body)
+	if err != nil {
+		return prodResp, err
+	}
+	// check the breaker first, so requests fail fast instead of waiting for the limiter while it's open
+	if err := c.breaker.allow(ctx); err != nil {
+		return prodResp, err
+	}
+	release, err := c.limiter.acquire(ctx, len(body))
+	if err != nil {
+		c.breaker.record(ctx, context.Canceled) // the request hasn't been sent, release a half-open probe
+		return prodResp, err
+	}
+	defer release()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, prepared.url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json") // don't add ;charset=UTF8 or server will complain
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	req.Header.Add("Authorization", "Basic "+c.options.BasicAuth())
+
+	logger.Info().Msgf("TopicURL=%s type=%s ce=%v len=%d sent=%d hd=%d", prepared.url,
+		prepared.dataType, request.AsCloudEvent, len(prepared.body), len(body), len(prepared.payload.Headers),
+	)
+	c.checkDumpRequest(req)
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		c.breaker.record(ctx, err)
+		return prodResp, fmt.Errorf("%w: cannot send http request %w", errClientResponse, err)
+	}
+	c.checkDumpResponse(res)
+	prodResp, err = parseResponse(res, prepared.url)
+	c.breaker.record(ctx, err)
+	prodResp.CloudEventID = prepared.cloudEventID
+	if err != nil {
+		return prodResp, err
+	}
+
+	logger.Info().Msgf("Record successfully committed code=%d topic=%s offset=%d partition=%d", prodResp.ErrorCode, prodResp.TopicName, prodResp.Offset, prodResp.PartitionId)
+
+	return prodResp, nil
+}
+
+// preparedRequest is the REST Proxy request built from a RecordRequest, see Client.prepare
+type preparedRequest struct {
+	url          string
+	topic        string
+	payload      kafkarestv3.ProduceRequest
+	body         []byte
+	dataType     string
+	cloudEventID string
+}
+
+// prepare builds the produce request, wraps the data into a CloudEvent if requested and encodes key and headers
+func (c *Client) prepare(request RecordRequest) (preparedRequest, error) {
+	prepared := preparedRequest{url: c.options.RecordEndpoint(request.Topic), topic: request.Topic}
 	keyData := c.messageKeyData(request.Key)
-	url := c.options.RecordEndpoint(request.Topic)
 
-	var prodResp RecordResponse
 	if request.AsCloudEvent {
 		// wrap data into a Cloud Event
 		ce, err := NewCloudEvent(request.Source, request.Type, request.Data)
 		if err != nil {
-			return prodResp, err
+			return prepared, err
 		}
 		ce.SetSubject(request.Subject)
 		request.Data = ce
+		prepared.cloudEventID = ce.ID()
 	}
+	prepared.dataType = fmt.Sprintf("%T", request.Data)
 
 	valueType, valueData, err := transformPayload(request.Data)
 	if err != nil {
-		return prodResp, fmt.Errorf("%w: unable to extract paylos (%s)", errClientResponse, err.Error())
+		return prepared, fmt.Errorf("%w: unable to extract paylos (%s)", errClientResponse, err.Error())
 	}
 	// handle message headers, add content type for cloud events
 	if request.Headers == nil {
@@ -128,12 +289,11 @@ func (c *Client) Produce(ctx context.Context, request RecordRequest) (RecordResp
 	if isCE {
 		request.Headers["content-type"] = cloudevents.ApplicationCloudEventsJSON + "; charset=UTF-8"
 	}
-	apiHeaders := messageHeaders(request.Headers)
 
-	ts := time.Now().Round(time.Second)
-	payload := kafkarestv3.ProduceRequest{
-		// PartitionId: nil, // not needed
-		Headers: apiHeaders,
+	ts := cmp.Or(request.Timestamp, time.Now()).Truncate(time.Millisecond)
+	prepared.payload = kafkarestv3.ProduceRequest{
+		PartitionId: request.Partition, // nil means partitioner decides
+		Headers:     messageHeaders(request.Headers),
 		Key: &kafkarestv3.ProduceRequestData{
 			Type: "BINARY",
 			Data: &keyData,
@@ -144,31 +304,11 @@ func (c *Client) Produce(ctx context.Context, request RecordRequest) (RecordResp
 		},
 		Timestamp: &ts,
 	}
-	payloadJSON, _ := json.Marshal(payload)
-	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payloadJSON))
-	req.Header.Set("Content-Type", "application/json") // don't add ;charset=UTF8 or server will complain
-	req.Header.Add("Authorization", "Basic "+c.options.BasicAuth())
-
-	logger.Info().Msgf("TopicURL=%s type=%s ce=%v len=%d hd=%d", url,
-		fmt.Sprintf("%T", request.Data), request.AsCloudEvent, len(payloadJSON), len(apiHeaders),
-	)
-	c.checkDumpRequest(req)
-	res, err := c.httpClient.Do(req)
-	if err != nil {
-		return prodResp, fmt.Errorf("%w: cannot send http request %s", errClientResponse, err.Error())
-	}
-	c.checkDumpResponse(res)
-	prodResp, err = parseResponse(res)
-	if err != nil {
-		return prodResp, err
-	}
-
-	logger.Info().Msgf("Record successfully committed code=%d topic=%s offset=%d partition=%d", prodResp.ErrorCode, prodResp.TopicName, prodResp.Offset, prodResp.PartitionId)
-
-	return prodResp, nil
+	prepared.body, _ = json.Marshal(prepared.payload)
+	return prepared, nil
 }
 
-func parseResponse(res *http.Response) (RecordResponse, error) {
+func parseResponse(res *http.Response, url string) (RecordResponse, error) {
 	defer closeSilently(res.Body)
 	var prodResp RecordResponse
 	body, err := io.ReadAll(res.Body)
@@ -177,7 +317,7 @@ func parseResponse(res *http.Response) (RecordResponse, error) {
 	}
 
 	if res.StatusCode != http.StatusOK {
-		return prodResp, fmt.Errorf("%w: unexpected http status code %d", errClientResponse, res.StatusCode)
+		return prodResp, &APIError{StatusCode: res.StatusCode, Method: http.MethodPost, URL: url, Body: string(body)}
 	}
 
 	if err := json.Unmarshal(body, &prodResp); err != nil {
@@ -190,6 +330,21 @@ func parseResponse(res *http.Response) (RecordResponse, error) {
 	return prodResp, nil
 }
 
+// IsRetriable returns true if the error is temporary, i.e. the request could not be sent at all (e.g. network errors,
+// timeouts or an open circuit breaker) or the REST Proxy responded with a server error or 429 Too Many Requests.
+// Other errors such as authorization failures or invalid requests are permanent, so there's no point in retrying them
+func IsRetriable(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
 func (c *Client) messageKeyData(key string) interface{} {
 	if key == "" {
 		key = uuid.New().String()
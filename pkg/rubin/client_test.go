@@ -2,14 +2,19 @@ package rubin
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/tillkuhn/rubin/internal/testutil"
+	"github.com/tillkuhn/rubin/pkg/tlsconfig"
 
+	"github.com/confluentinc/kafka-rest-sdk-go/kafkarestv3"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -73,6 +78,12 @@ func TestProduceMessageOK(t *testing.T) {
 		Type:         "test.event",
 	}) //
 	assert.NoError(t, err)
+	result := resp.Result()
+	assert.NotEmpty(t, result.CloudEventID)
+	assert.Equal(t, int64(42), result.Offset)
+	assert.Equal(t, int64(6), result.KeySize)
+	assert.Equal(t, int64(31), result.ValueSize)
+	assert.Equal(t, 2022, result.Timestamp.Year())
 
 	// test with empty header map
 	_, err = cc.Produce(ctx, RecordRequest{Topic: testutil.Topic(200), Data: event, Headers: hm}) // struct that can be unmarshalled
@@ -114,3 +125,55 @@ func TestProduceMessageError(t *testing.T) {
 	_, err := cc.Produce(ctx, req)
 	assert.ErrorContains(t, err, "Not authorized")
 }
+
+func TestProduceMessageCustomTLS(t *testing.T) {
+	ctx := context.Background()
+	srv := testutil.TLSServerMock()
+	defer srv.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, testutil.WriteCertPEM(srv, caFile))
+	opts := &Options{
+		RestEndpoint: srv.URL, ClusterID: testutil.ClusterID,
+		ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw",
+	}
+	req := RecordRequest{Topic: testutil.Topic(200), Data: "Hello TLS!"}
+
+	// self-signed server cert is not trusted by system pool
+	_, err := NewClient(opts).Produce(ctx, req)
+	assert.ErrorContains(t, err, "certificate")
+
+	// httptest certificates are issued for example.com and 127.0.0.1
+	opts.TLS = tlsconfig.Options{CAFile: caFile, ServerName: "example.com"}
+	_, err = NewClient(opts).Produce(ctx, req)
+	assert.NoError(t, err)
+
+	// invalid tls settings are reported on produce
+	opts.TLS = tlsconfig.Options{CAFile: caFile + ".missing"}
+	_, err = NewClient(opts).Produce(ctx, req)
+	assert.ErrorContains(t, err, "cannot read ca file")
+}
+
+func TestProducePartitionAndTimestamp(t *testing.T) {
+	var payload kafkarestv3.ProduceRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_ = json.NewDecoder(req.Body).Decode(&payload)
+		respBytes, _ := os.ReadFile(testutil.TestDataDir + "/response-200.json")
+		_, _ = w.Write(respBytes)
+	}))
+	defer srv.Close()
+	cc := NewClient(&Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw"})
+
+	// default: no partition and current time with millisecond precision
+	_, err := cc.Produce(context.Background(), RecordRequest{Topic: "public.hello", Data: "now"})
+	assert.NoError(t, err)
+	assert.Nil(t, payload.PartitionId)
+	assert.WithinDuration(t, time.Now(), *payload.Timestamp, time.Second)
+	assert.Equal(t, payload.Timestamp.Truncate(time.Millisecond), *payload.Timestamp)
+
+	partition := int32(3)
+	backfill := time.Date(2019, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	_, err = cc.Produce(context.Background(), RecordRequest{Topic: "public.hello", Data: "then", Partition: &partition, Timestamp: backfill})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), *payload.PartitionId)
+	assert.Equal(t, time.Date(2019, 3, 1, 12, 0, 0, 123000000, time.UTC), payload.Timestamp.UTC())
+}
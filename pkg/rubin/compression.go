@@ -0,0 +1,45 @@
+package rubin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+)
+
+// Supported values for Options.Compression
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// compressionMinBytes is the min size of request bodies to be compressed, smaller bodies are not worth it
+const compressionMinBytes = 1024
+
+var errInvalidCompression = errors.New("invalid compression")
+
+func validateCompression(compression string) error {
+	switch compression {
+	case "", CompressionNone, CompressionGzip:
+		return nil
+	default:
+		return fmt.Errorf("%w %s, expected %s or %s", errInvalidCompression, compression, CompressionNone, CompressionGzip)
+	}
+}
+
+// encodeBody compresses the request body if enabled by Options.Compression, and returns the Content-Encoding
+// header value (empty if the body is sent as is)
+func (c *Client) encodeBody(body []byte) ([]byte, string, error) {
+	if c.options.Compression != CompressionGzip || len(body) < compressionMinBytes {
+		return body, "", nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, "", fmt.Errorf("%w: cannot compress request body: %w", errClientResponse, err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", fmt.Errorf("%w: cannot compress request body: %w", errClientResponse, err)
+	}
+	return buf.Bytes(), CompressionGzip, nil
+}
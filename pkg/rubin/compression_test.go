@@ -0,0 +1,42 @@
+package rubin
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+)
+
+func TestCompression(t *testing.T) {
+	var encodings []string
+	mock := testutil.ServerMock()
+	defer mock.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") == CompressionGzip {
+			zr, err := gzip.NewReader(r.Body)
+			assert.NoError(t, err)
+			r.Body = io.NopCloser(zr)
+		}
+		mock.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	opts := &Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw", Compression: CompressionGzip}
+	cc := NewClient(opts)
+	_, err := cc.Produce(context.Background(), RecordRequest{Topic: testutil.Topic(200), Data: strings.Repeat("Hello ", 500)})
+	assert.NoError(t, err)
+	_, err = cc.Produce(context.Background(), RecordRequest{Topic: testutil.Topic(200), Data: "Hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{CompressionGzip, ""}, encodings, "small bodies are not compressed")
+
+	opts.Compression = "zstd"
+	_, err = NewClient(opts).Produce(context.Background(), RecordRequest{Topic: testutil.Topic(200), Data: "Hello"})
+	assert.ErrorContains(t, err, "invalid compression zstd")
+}
@@ -0,0 +1,135 @@
+package rubin
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/confluentinc/kafka-rest-sdk-go/kafkarestv3"
+	"github.com/pkg/errors"
+)
+
+// Supported values for Options.DryRunFormat
+const (
+	DryRunFormatJSON = "json"
+	DryRunFormatCurl = "curl"
+)
+
+// redactedAuth replaces the credentials in rendered requests
+const redactedAuth = "Basic ************"
+
+var errInvalidDryRunFormat = errors.New("invalid dry-run format")
+
+// DryRunRequest is the REST Proxy request that would be sent by Produce, the Authorization header is redacted.
+// Body is always shown uncompressed, the Content-Encoding header tells whether it would be sent compressed
+type DryRunRequest struct {
+	Method       string                     `json:"method"`
+	URL          string                     `json:"url"`
+	Headers      map[string]string          `json:"headers"`
+	Body         kafkarestv3.ProduceRequest `json:"body"`
+	CloudEventID string                     `json:"cloudevent_id,omitempty"`
+}
+
+// Curl returns an equivalent curl command, single quotes in the body are escaped for POSIX shells.
+// If the request is compressed (Content-Encoding gzip), the body is piped through gzip
+func (r DryRunRequest) Curl() string {
+	body, _ := json.Marshal(r.Body)
+	data := shellQuote(string(body))
+	var sb strings.Builder
+	if r.Headers["Content-Encoding"] == CompressionGzip {
+		sb.WriteString("printf '%s' " + data + " | gzip | ")
+		data = "@-"
+	}
+	sb.WriteString("curl -X " + r.Method + " " + shellQuote(r.URL))
+	names := make([]string, 0, len(r.Headers))
+	for name := range r.Headers {
+		names = append(names, name)
+	}
+	slices.Sort(names) // stable output
+	for _, name := range names {
+		sb.WriteString(" \\\n  -H " + shellQuote(name+": "+r.Headers[name]))
+	}
+	sb.WriteString(" \\\n  --data-binary " + data)
+	return sb.String()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// DryRun builds the request for the record exactly like Produce, but returns it without sending it
+func (c *Client) DryRun(ctx context.Context, request RecordRequest) (DryRunRequest, error) {
+	if c.validator != nil {
+		if err := c.validator.Validate(ctx, request); err != nil {
+			return DryRunRequest{}, err
+		}
+	}
+	prepared, err := c.prepare(request)
+	if err != nil {
+		return DryRunRequest{}, err
+	}
+	return c.newDryRunRequest(prepared)
+}
+
+// newDryRunRequest returns the request with the same headers as Produce, incl. Content-Encoding if it's compressed
+func (c *Client) newDryRunRequest(prepared preparedRequest) (DryRunRequest, error) {
+	_, encoding, err := c.encodeBody(prepared.body)
+	if err != nil {
+		return DryRunRequest{}, err
+	}
+	headers := map[string]string{
+		"Content-Type":  "application/json",
+		"Authorization": redactedAuth,
+	}
+	if encoding != "" {
+		headers["Content-Encoding"] = encoding
+	}
+	return DryRunRequest{
+		Method:       http.MethodPost,
+		URL:          prepared.url,
+		Headers:      headers,
+		Body:         prepared.payload,
+		CloudEventID: prepared.cloudEventID,
+	}, nil
+}
+
+// dryRun writes the prepared request in the configured format to Options.DryRunOutput and returns a synthetic response,
+// Offset is -1 since the record hasn't been produced
+func (c *Client) dryRun(prepared preparedRequest) (RecordResponse, error) {
+	var prodResp RecordResponse
+	request, err := c.newDryRunRequest(prepared)
+	if err != nil {
+		return prodResp, err
+	}
+	var rendered string
+	switch format := cmp.Or(c.options.DryRunFormat, DryRunFormatJSON); format {
+	case DryRunFormatJSON:
+		b, _ := json.MarshalIndent(request, "", "  ")
+		rendered = string(b)
+	case DryRunFormatCurl:
+		rendered = request.Curl()
+	default:
+		return prodResp, fmt.Errorf("%w %s, expected %s or %s", errInvalidDryRunFormat, format, DryRunFormatJSON, DryRunFormatCurl)
+	}
+	if _, err := fmt.Fprintln(cmp.Or[io.Writer](c.options.DryRunOutput, io.Discard), rendered); err != nil {
+		return prodResp, err
+	}
+
+	prodResp.ErrorCode = http.StatusOK
+	prodResp.ClusterId = c.options.ClusterID
+	prodResp.TopicName = prepared.topic
+	if prepared.payload.PartitionId != nil {
+		prodResp.PartitionId = *prepared.payload.PartitionId
+	}
+	prodResp.Offset = -1
+	prodResp.Timestamp = prepared.payload.Timestamp
+	prodResp.Key = &kafkarestv3.ProduceResponseData{Size: dataSize(prepared.payload.Key)}
+	prodResp.Value = &kafkarestv3.ProduceResponseData{Size: dataSize(prepared.payload.Value)}
+	prodResp.CloudEventID = prepared.cloudEventID
+	return prodResp, nil
+}
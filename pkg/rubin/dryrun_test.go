@@ -0,0 +1,72 @@
+package rubin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun(t *testing.T) {
+	var out bytes.Buffer
+	// nothing listens on port 1, so the test fails if the request would actually be sent
+	opts := &Options{RestEndpoint: "http://127.0.0.1:1", ClusterID: "abc", ProducerAPIKey: "key", ProducerAPISecret: "very-secret", DryRun: true, DryRunOutput: &out}
+	cc := NewClient(opts)
+	partition := int32(2)
+	resp, err := cc.Produce(context.Background(), RecordRequest{Topic: "public.hello", Key: "1234", Data: `{"action":"deploy"}`, Partition: &partition, AsCloudEvent: true, Type: "deploy.event"})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.ErrorCode)
+	assert.Equal(t, "public.hello", resp.TopicName)
+	assert.Equal(t, int32(2), resp.PartitionId)
+	assert.Equal(t, int32(-1), resp.Offset)
+	assert.Equal(t, int64(4), resp.Key.Size)
+	assert.NotEmpty(t, resp.CloudEventID)
+
+	var rendered DryRunRequest
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &rendered))
+	assert.Equal(t, "http://127.0.0.1:1/kafka/v3/clusters/abc/topics/public.hello/records", rendered.URL)
+	assert.Equal(t, "POST", rendered.Method)
+	assert.Equal(t, redactedAuth, rendered.Headers["Authorization"])
+	assert.Equal(t, "JSON", rendered.Body.Value.Type)
+	assert.Equal(t, resp.CloudEventID, rendered.CloudEventID)
+	assert.NotContains(t, out.String(), opts.BasicAuth())
+
+	out.Reset()
+	opts.DryRunFormat = DryRunFormatCurl
+	_, err = cc.Produce(context.Background(), RecordRequest{Topic: "public.hello", Data: "It's a string"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "curl -X POST 'http://127.0.0.1:1/kafka/v3/clusters/abc/topics/public.hello/records'")
+	assert.Contains(t, out.String(), "-H 'Authorization: Basic ************'")
+	assert.Contains(t, out.String(), `"data":"It'\''s a string"`, "single quotes are escaped")
+	assert.NotContains(t, out.String(), opts.BasicAuth())
+
+	// the rendered request has the same headers as the real request
+	out.Reset()
+	opts.Compression = CompressionGzip
+	large := strings.Repeat("x", compressionMinBytes)
+	_, err = cc.Produce(context.Background(), RecordRequest{Topic: "public.hello", Data: large})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "-H 'Content-Encoding: gzip'")
+	assert.True(t, strings.HasPrefix(out.String(), `printf '%s' '{`), out.String())
+	assert.Contains(t, out.String(), "| gzip | curl -X POST")
+	assert.Contains(t, out.String(), "--data-binary @-")
+	request, err := cc.DryRun(context.Background(), RecordRequest{Topic: "public.hello", Data: "small"})
+	assert.NoError(t, err)
+	assert.NotContains(t, request.Headers, "Content-Encoding", "small bodies are not compressed")
+
+	opts.DryRunFormat = "yaml"
+	_, err = cc.Produce(context.Background(), RecordRequest{Topic: "public.hello", Data: "Hello"})
+	assert.ErrorIs(t, err, errInvalidDryRunFormat)
+}
+
+func TestDryRunRequest(t *testing.T) {
+	cc := NewClient(&Options{RestEndpoint: "http://127.0.0.1:1", ClusterID: "abc"})
+	request, err := cc.DryRun(context.Background(), RecordRequest{Topic: "public.hello", Data: "Hello", Headers: map[string]string{"trace": "1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "STRING", request.Body.Value.Type)
+	assert.Len(t, request.Body.Headers, 1)
+	assert.Empty(t, request.CloudEventID)
+}
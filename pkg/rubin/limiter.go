@@ -0,0 +1,117 @@
+package rubin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// errLimiterAborted is returned if the context is done while a request waits for the limiter, so it hasn't been sent
+var errLimiterAborted = errors.New("waiting for limiter aborted")
+
+// limiter throttles produce requests with token buckets for records and bytes per second, and limits the number
+// of concurrent requests. Zero values disable the respective limit, see Options.RateLimit
+type limiter struct {
+	records  *tokenBucket
+	bytes    *tokenBucket
+	inFlight chan struct{}
+}
+
+func newLimiter(options *Options) *limiter {
+	l := &limiter{}
+	if options.RateLimit > 0 {
+		// allow a burst of one second, but at least a single record
+		l.records = newTokenBucket(options.RateLimit, math.Max(1, options.RateLimit))
+	}
+	if options.RateLimitBytes > 0 {
+		l.bytes = newTokenBucket(float64(options.RateLimitBytes), float64(options.RateLimitBytes))
+	}
+	if options.MaxInFlight > 0 {
+		l.inFlight = make(chan struct{}, options.MaxInFlight)
+	}
+	return l
+}
+
+// acquire blocks until a request with the given body size is allowed, release must be called once it's done.
+// Records larger than the bytes per second are not rejected, but delay subsequent requests accordingly
+func (l *limiter) acquire(ctx context.Context, size int) (release func(), err error) {
+	release = func() {}
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+			release = func() { <-l.inFlight }
+		case <-ctx.Done():
+			return release, fmt.Errorf("%w: max in-flight requests: %w", errLimiterAborted, ctx.Err())
+		}
+	}
+	if err := l.records.wait(ctx, 1); err != nil {
+		release()
+		return func() {}, err
+	}
+	if err := l.bytes.wait(ctx, float64(size)); err != nil {
+		release()
+		return func() {}, err
+	}
+	return release, nil
+}
+
+// tokenBucket is refilled with rate tokens per second up to burst. Callers reserve tokens even if the bucket
+// doesn't have enough of them (it may become negative) and wait until the debt has been refilled, so waiting
+// callers are served in order and large requests can't starve
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait takes n tokens and blocks until they're available or the context is done, a nil bucket doesn't limit
+func (b *tokenBucket) wait(ctx context.Context, n float64) error {
+	if b == nil {
+		return nil
+	}
+	delay := b.reserve(n)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel(n)
+		return fmt.Errorf("%w: rate limit: %w", errLimiterAborted, ctx.Err())
+	}
+}
+
+// reserve takes n tokens and returns the time to wait until they're covered
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	// requests larger than burst only wait for a full bucket, the remaining debt delays subsequent requests
+	missing := math.Min(n, b.burst) - b.tokens
+	b.tokens -= n
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / b.rate * float64(time.Second))
+}
+
+// cancel returns tokens of an aborted reservation
+func (b *tokenBucket) cancel(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+n)
+}
@@ -0,0 +1,84 @@
+package rubin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	assert.Zero(t, b.reserve(1))
+	assert.Zero(t, b.reserve(1), "burst is available immediately")
+	assert.InDelta(t, 100*time.Millisecond, b.reserve(1), float64(10*time.Millisecond))
+	assert.InDelta(t, 300*time.Millisecond, b.reserve(1+1), float64(10*time.Millisecond), "waiting callers queue up")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, b.wait(ctx, 1), context.Canceled)
+	assert.InDelta(t, 300*time.Millisecond, b.reserve(0), float64(10*time.Millisecond), "tokens of aborted waits are returned")
+
+	var unlimited *tokenBucket
+	assert.NoError(t, unlimited.wait(ctx, 1000))
+}
+
+func TestProduceRateLimit(t *testing.T) {
+	srv := testutil.ServerMock()
+	defer srv.Close()
+	cc := NewClient(&Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw", RateLimit: 20})
+	start := time.Now()
+	for range 25 {
+		_, err := cc.Produce(context.Background(), RecordRequest{Topic: testutil.Topic(200), Data: "Hello"})
+		assert.NoError(t, err)
+	}
+	// 20 records burst, the remaining 5 need 250ms
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	cc = NewClient(&Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw", RateLimitBytes: 10})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := cc.Produce(ctx, RecordRequest{Topic: testutil.Topic(200), Data: "This record exceeds the byte budget"})
+	assert.NoError(t, err, "large records are not rejected")
+	_, err = cc.Produce(ctx, RecordRequest{Topic: testutil.Topic(200), Data: "Hello"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "but delay subsequent requests")
+}
+
+func TestProduceMaxInFlight(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, maxSeen int
+	mock := testutil.ServerMock()
+	defer mock.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxSeen = max(maxSeen, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(20 * time.Millisecond)
+		mock.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	cc := NewClient(&Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw", MaxInFlight: 2})
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cc.Produce(context.Background(), RecordRequest{Topic: testutil.Topic(200), Data: "Hello"})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, maxSeen)
+}
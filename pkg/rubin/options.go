@@ -3,11 +3,13 @@ package rubin
 import (
 	b64 "encoding/base64"
 	"fmt"
+	"io"
 	"net/url"
 	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
+	"github.com/tillkuhn/rubin/pkg/tlsconfig"
 )
 
 const (
@@ -26,6 +28,30 @@ type Options struct {
 	HTTPTimeout       time.Duration `yaml:"http_timeout" default:"10s" required:"false" desc:"Timeout for HTTP Client" split_words:"true"`
 	DumpMessages      bool          `yaml:"dump_messages" default:"false" required:"false" desc:"Print http request/response to stdout" split_words:"true"`
 	LogLevel          string        `yaml:"log_level" default:"info" required:"false" desc:"Min LogLevel debug,info,warn,error" split_words:"true"`
+	// SpoolDir enables the durable on-disk Spool for records that could not be produced due to temporary errors
+	SpoolDir     string `yaml:"spool_dir" default:"" required:"false" desc:"Directory to persist records if the REST Proxy is unreachable, disabled if empty" split_words:"true"`
+	SpoolMaxSize int64  `yaml:"spool_max_size" default:"104857600" required:"false" desc:"Max size of the spool in bytes" split_words:"true"`
+	// Compression of request bodies, the REST Proxy must support Content-Encoding gzip
+	Compression string `yaml:"compression" default:"none" required:"false" desc:"Request body compression none or gzip (bodies smaller than 1KB are not compressed)" split_words:"true"`
+	// MaxRecordBytes enables a pre-flight size check, Produce returns RecordTooLargeError instead of sending oversize records
+	MaxRecordBytes int64 `yaml:"max_record_bytes" default:"0" required:"false" desc:"Max record size (key, value and headers) in bytes, e.g. max.message.bytes of the topic, 0 disables the check" split_words:"true"`
+	// RateLimit, RateLimitBytes and MaxInFlight throttle produce requests on the client side, e.g. to stay within
+	// request quotas of Confluent Cloud during bulk replays. Zero values disable the respective limit
+	RateLimit      float64 `yaml:"rate_limit" default:"0" required:"false" desc:"Max records per second, 0 is unlimited" split_words:"true"`
+	RateLimitBytes int64   `yaml:"rate_limit_bytes" default:"0" required:"false" desc:"Max request bytes per second, 0 is unlimited" split_words:"true"`
+	MaxInFlight    int     `yaml:"max_in_flight" default:"0" required:"false" desc:"Max concurrent produce requests, 0 is unlimited" split_words:"true"`
+	// BreakerFailureRatio enables the circuit breaker, which fails fast with CircuitOpenError while the REST Proxy is down
+	BreakerFailureRatio float64       `yaml:"breaker_failure_ratio" default:"0" required:"false" desc:"Open the circuit breaker if the ratio of failed requests reaches the value, e.g. 0.5, 0 disables it" split_words:"true"`
+	BreakerMinRequests  int           `yaml:"breaker_min_requests" default:"10" required:"false" desc:"Min number of requests in the window before the failure ratio is evaluated" split_words:"true"`
+	BreakerWindow       time.Duration `yaml:"breaker_window" default:"60s" required:"false" desc:"Interval after which failure counts are reset while the breaker is closed" split_words:"true"`
+	BreakerCoolDown     time.Duration `yaml:"breaker_cool_down" default:"30s" required:"false" desc:"Duration the breaker stays open before a probe request is sent" split_words:"true"`
+	// DryRun builds the produce request and prints it instead of sending it to the REST Proxy, see DryRunFormat
+	DryRun       bool   `yaml:"dry_run" default:"false" required:"false" desc:"Print the REST request instead of sending it" split_words:"true"`
+	DryRunFormat string `yaml:"dry_run_format" default:"json" required:"false" desc:"Format of the request printed in dry-run mode json or curl" split_words:"true"`
+	// DryRunOutput receives the requests rendered in dry-run mode, they're discarded if it's nil (use Client.DryRun instead)
+	DryRunOutput io.Writer `yaml:"-" ignored:"true"`
+	// TLS custom CA bundle, client certificates and SNI, e.g. for on-prem REST Proxies with internal PKI
+	TLS tlsconfig.Options `yaml:"tls" split_words:"true"`
 }
 
 // NewOptionsFromEnv uses environment configuration with default prefix "kafka" to init Options
@@ -75,6 +101,16 @@ func (o Options) RecordEndpoint(topic string) string {
 	}
 }
 
+// ClusterEndpoint returns the REST API base URL of the cluster, which is used for admin operations
+// (e.g. list topics). If ProducerTopicURL is specified, it takes precedence and the topic part is stripped
+func (o Options) ClusterEndpoint() string {
+	if o.ProducerTopicURL.String() == "" {
+		return fmt.Sprintf("%s/kafka/v3/clusters/%s", o.RestEndpoint, o.ClusterID)
+	}
+	clusterURL, _, _ := strings.Cut(sanitizeURL(o.ProducerTopicURL), "/topics/")
+	return clusterURL
+}
+
 // sanitizeURL removes optional user and password info from URL
 func sanitizeURL(u url.URL) string {
 	u.User = nil
@@ -0,0 +1,54 @@
+package rubin
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// recordJSON is the serialized form of a RecordRequest, e.g. to persist it in a Spool or an outbox table.
+// Data is kept as raw JSON, so strings stay strings and structs become JSON objects
+type recordJSON struct {
+	Topic        string            `json:"topic,omitempty"`
+	Data         json.RawMessage   `json:"data"`
+	Key          string            `json:"key,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Partition    *int32            `json:"partition,omitempty"`
+	Timestamp    time.Time         `json:"timestamp,omitzero"`
+	AsCloudEvent bool              `json:"ce,omitempty"`
+	Source       string            `json:"source,omitempty"`
+	Type         string            `json:"type,omitempty"`
+	Subject      string            `json:"subject,omitempty"`
+}
+
+// MarshalJSON serializes the request incl. CloudEvent attributes, timestamps are truncated to milliseconds
+func (r RecordRequest) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(r.Data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(recordJSON{
+		Topic: r.Topic, Data: data, Key: r.Key, Headers: r.Headers, Partition: r.Partition,
+		Timestamp:    r.Timestamp.Truncate(time.Millisecond),
+		AsCloudEvent: r.AsCloudEvent, Source: r.Source, Type: r.Type, Subject: r.Subject,
+	})
+}
+
+// UnmarshalJSON restores a request serialized by MarshalJSON, Data is unmarshalled into a generic
+// value (string, map, slice ...) which is produced the same way as the original value
+func (r *RecordRequest) UnmarshalJSON(b []byte) error {
+	var rj recordJSON
+	if err := json.Unmarshal(b, &rj); err != nil {
+		return err
+	}
+	var data interface{}
+	if len(rj.Data) > 0 {
+		if err := json.Unmarshal(rj.Data, &data); err != nil {
+			return err
+		}
+	}
+	*r = RecordRequest{
+		Topic: rj.Topic, Data: data, Key: rj.Key, Headers: rj.Headers, Partition: rj.Partition, Timestamp: rj.Timestamp,
+		AsCloudEvent: rj.AsCloudEvent, Source: rj.Source, Type: rj.Type, Subject: rj.Subject,
+	}
+	return nil
+}
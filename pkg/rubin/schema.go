@@ -0,0 +1,557 @@
+package rubin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrSchemaValidation is wrapped by SchemaValidationError, use errors.Is to check if a payload has been rejected
+	ErrSchemaValidation = errors.New("payload violates json schema")
+	errInvalidSchema    = errors.New("invalid json schema")
+)
+
+// SchemaViolation is a single validation failure, Path is a JSON pointer to the invalid value (empty for the root)
+type SchemaViolation struct {
+	Path    string
+	Message string
+}
+
+// SchemaValidationError lists all violations of a payload, it wraps ErrSchemaValidation
+type SchemaValidationError struct {
+	Schema     string
+	Violations []SchemaViolation
+}
+
+func (e *SchemaValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.String())
+	}
+	return fmt.Sprintf("%s %s: %s", ErrSchemaValidation, e.Schema, strings.Join(msgs, "; "))
+}
+
+func (e *SchemaValidationError) Unwrap() error {
+	return ErrSchemaValidation
+}
+
+// String returns "<path>: <message>", the root is shown as (root)
+func (v SchemaViolation) String() string {
+	if v.Path == "" {
+		return "(root): " + v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// Schema is a compiled JSON Schema, see SchemaValidator for the supported keywords
+type Schema struct {
+	name string
+	root interface{}
+	// patterns are compiled by CompileSchema, so invalid patterns are rejected with the schema
+	patterns map[string]*regexp.Regexp
+}
+
+// unsupportedKeywords are rejected by CompileSchema, since ignoring them would accept invalid payloads
+var unsupportedKeywords = []string{
+	"$anchor", "$dynamicRef", "$recursiveRef", "additionalItems", "contains", "dependencies", "dependentRequired",
+	"dependentSchemas", "else", "if", "maxContains", "maxProperties", "minContains", "minProperties", "multipleOf",
+	"patternProperties", "prefixItems", "propertyNames", "then", "unevaluatedItems", "unevaluatedProperties",
+}
+
+// numericKeywords must have a number value, e.g. the draft 4 boolean form of exclusiveMinimum is rejected
+var numericKeywords = []string{
+	"exclusiveMaximum", "exclusiveMinimum", "maxItems", "maxLength", "maximum", "minItems", "minLength", "minimum",
+}
+
+// CompileSchema parses the JSON Schema document, name is used in error messages (e.g. file name or URL).
+// Documents with unsupported keywords, non-local or circular references are rejected
+func CompileSchema(name string, document []byte) (*Schema, error) {
+	var root interface{}
+	if err := json.Unmarshal(document, &root); err != nil {
+		return nil, fmt.Errorf("%w %s: %w", errInvalidSchema, name, err)
+	}
+	switch root.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return nil, fmt.Errorf("%w %s: schema must be an object or boolean", errInvalidSchema, name)
+	}
+	schema := &Schema{name: name, root: root, patterns: map[string]*regexp.Regexp{}}
+	// check all subschemas incl. the targets of references, which may be located anywhere in the document
+	var err error
+	var refs []string
+	var check func(node map[string]interface{})
+	check = func(node map[string]interface{}) {
+		if err != nil {
+			return
+		}
+		if err = schema.checkKeywords(node); err != nil {
+			return
+		}
+		if ref, ok := node["$ref"].(string); ok && !slices.Contains(refs, ref) {
+			refs = append(refs, ref)
+			target, _ := schema.resolveRef(ref) // checked by checkKeywords
+			walkSchema(target, check)
+		}
+	}
+	walkSchema(root, check)
+	if err != nil {
+		return nil, err
+	}
+	if err := schema.checkRefs(refs); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// checkKeywords rejects unsupported keywords and references of a (sub)schema
+func (s *Schema) checkKeywords(schema map[string]interface{}) error {
+	for _, keyword := range unsupportedKeywords {
+		if _, found := schema[keyword]; found {
+			return fmt.Errorf("%w %s: unsupported keyword %s", errInvalidSchema, s.name, keyword)
+		}
+	}
+	for _, keyword := range numericKeywords {
+		if value, found := schema[keyword]; found {
+			if _, isNumber := toFloat(value); !isNumber {
+				return fmt.Errorf("%w %s: %s must be a number but got %s", errInvalidSchema, s.name, keyword, compactJSON(value))
+			}
+		}
+	}
+	if _, isTuple := schema["items"].([]interface{}); isTuple {
+		return fmt.Errorf("%w %s: unsupported keyword items with array value", errInvalidSchema, s.name)
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		if _, err := s.resolveRef(ref); err != nil {
+			return fmt.Errorf("%w %s: %w", errInvalidSchema, s.name, err)
+		}
+	}
+	if value, found := schema["pattern"]; found {
+		pattern, isString := value.(string)
+		if !isString {
+			return fmt.Errorf("%w %s: pattern must be a string", errInvalidSchema, s.name)
+		}
+		// Go uses RE2 syntax, so ECMA 262 features such as lookahead (?=...) or backreferences are not supported
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%w %s: invalid pattern %s: %w", errInvalidSchema, s.name, pattern, err)
+		}
+		s.patterns[pattern] = re
+	}
+	return nil
+}
+
+// checkRefs rejects $ref cycles which don't descend into the value, e.g. {"$ref": "#"}, since validation would
+// never end. Recursive schemas such as {"properties": {"children": {"items": {"$ref": "#"}}}} are fine
+func (s *Schema) checkRefs(refs []string) error {
+	const visiting, done = 1, 2
+	state := map[string]int{}
+	var visit func(ref string) error
+	visit = func(ref string) error {
+		switch state[ref] {
+		case visiting:
+			return fmt.Errorf("%w %s: circular $ref %s", errInvalidSchema, s.name, ref)
+		case done:
+			return nil
+		}
+		state[ref] = visiting
+		target, _ := s.resolveRef(ref) // unresolvable refs are rejected by checkKeywords
+		for _, next := range inPlaceRefs(target) {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[ref] = done
+		return nil
+	}
+	for _, ref := range refs {
+		if err := visit(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inPlaceRefs returns the references that are applied to the same value as the schema, i.e. its own $ref
+// and those of its allOf, anyOf, oneOf and not subschemas
+func inPlaceRefs(node interface{}) []string {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var refs []string
+	if ref, ok := schema["$ref"].(string); ok {
+		refs = append(refs, ref)
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, _ := schema[keyword].([]interface{})
+		for _, sub := range subs {
+			refs = append(refs, inPlaceRefs(sub)...)
+		}
+	}
+	return append(refs, inPlaceRefs(schema["not"])...)
+}
+
+// walkSchema calls fn for the schema and all of its subschemas
+func walkSchema(node interface{}, fn func(schema map[string]interface{})) {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return
+	}
+	fn(schema)
+	for _, keyword := range []string{"properties", "$defs", "definitions"} {
+		subs, _ := schema[keyword].(map[string]interface{})
+		for _, name := range slices.Sorted(maps.Keys(subs)) {
+			walkSchema(subs[name], fn)
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, _ := schema[keyword].([]interface{})
+		for _, sub := range subs {
+			walkSchema(sub, fn)
+		}
+	}
+	for _, keyword := range []string{"not", "items", "additionalProperties"} {
+		walkSchema(schema[keyword], fn)
+	}
+}
+
+// Validate validates the payload as Produce would send it: a string is validated as JSON if it's valid JSON,
+// otherwise as JSON string value, any other value is marshalled to JSON first (so json.RawMessage is validated as
+// raw JSON, but []byte as base64 encoded string). It returns a SchemaValidationError with all violations
+func (s *Schema) Validate(payload interface{}) error {
+	value, err := jsonValue(payload)
+	if err != nil {
+		return &SchemaValidationError{Schema: s.name, Violations: []SchemaViolation{{Message: err.Error()}}}
+	}
+	var violations []SchemaViolation
+	s.validate(s.root, value, "", &violations)
+	if len(violations) > 0 {
+		return &SchemaValidationError{Schema: s.name, Violations: violations}
+	}
+	return nil
+}
+
+// jsonValue converts the payload into its generic JSON representation exactly like transformPayload,
+// strings that are not valid JSON are validated as JSON string values (sent as STRING)
+func jsonValue(payload interface{}) (interface{}, error) {
+	var raw []byte
+	switch p := payload.(type) {
+	case string:
+		if !json.Valid([]byte(p)) {
+			return p, nil
+		}
+		raw = []byte(p)
+	default:
+		var err error
+		if raw, err = json.Marshal(p); err != nil {
+			return nil, err
+		}
+	}
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber() // retain precision for integer checks
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+	return value, nil
+}
+
+func (s *Schema) validate(node interface{}, value interface{}, path string, violations *[]SchemaViolation) {
+	schema, isObject := node.(map[string]interface{})
+	if !isObject {
+		if allowed, _ := node.(bool); !allowed {
+			s.addViolation(violations, path, "value is not allowed")
+		}
+		return
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := s.resolveRef(ref)
+		if err != nil {
+			s.addViolation(violations, path, err.Error())
+		} else {
+			s.validate(target, value, path, violations)
+		}
+	}
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		s.addViolation(violations, path, fmt.Sprintf("expected type %v but got %s", typeNames(t), jsonType(value)))
+		return // further keywords would only produce follow-up errors
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !slices.ContainsFunc(enum, func(e interface{}) bool { return jsonEqual(e, value) }) {
+		s.addViolation(violations, path, fmt.Sprintf("value must be one of %v", compactJSON(enum)))
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, value) {
+		s.addViolation(violations, path, fmt.Sprintf("value must be %s", compactJSON(c)))
+	}
+	switch v := value.(type) {
+	case string:
+		s.validateString(schema, v, path, violations)
+	case json.Number:
+		s.validateNumber(schema, v, path, violations)
+	case map[string]interface{}:
+		s.validateObject(schema, v, path, violations)
+	case []interface{}:
+		s.validateArray(schema, v, path, violations)
+	}
+	s.validateCombinators(schema, value, path, violations)
+}
+
+// validateCombinators applies allOf, anyOf, oneOf and not
+func (s *Schema) validateCombinators(schema map[string]interface{}, value interface{}, path string, violations *[]SchemaViolation) {
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			s.validate(sub, value, path, violations)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && s.countValid(anyOf, value, path) == 0 {
+		s.addViolation(violations, path, "value must match at least one schema of anyOf")
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		if n := s.countValid(oneOf, value, path); n != 1 {
+			s.addViolation(violations, path, fmt.Sprintf("value must match exactly one schema of oneOf, but matches %d", n))
+		}
+	}
+	if not, ok := schema["not"]; ok && s.countValid([]interface{}{not}, value, path) == 1 {
+		s.addViolation(violations, path, "value must not match the schema of not")
+	}
+}
+
+func (s *Schema) validateString(schema map[string]interface{}, v string, path string, violations *[]SchemaViolation) {
+	length := utf8.RuneCountInString(v)
+	if minLength, ok := schemaNumber(schema, "minLength"); ok && float64(length) < minLength {
+		s.addViolation(violations, path, fmt.Sprintf("length must be >= %v", minLength))
+	}
+	if maxLength, ok := schemaNumber(schema, "maxLength"); ok && float64(length) > maxLength {
+		s.addViolation(violations, path, fmt.Sprintf("length must be <= %v", maxLength))
+	}
+	if pattern, ok := schema["pattern"].(string); ok && !s.patterns[pattern].MatchString(v) {
+		s.addViolation(violations, path, fmt.Sprintf("value must match pattern %s", pattern))
+	}
+}
+
+func (s *Schema) validateNumber(schema map[string]interface{}, n json.Number, path string, violations *[]SchemaViolation) {
+	v, _ := n.Float64()
+	if minimum, ok := schemaNumber(schema, "minimum"); ok && v < minimum {
+		s.addViolation(violations, path, fmt.Sprintf("value must be >= %v", minimum))
+	}
+	if maximum, ok := schemaNumber(schema, "maximum"); ok && v > maximum {
+		s.addViolation(violations, path, fmt.Sprintf("value must be <= %v", maximum))
+	}
+	if exclusiveMinimum, ok := schemaNumber(schema, "exclusiveMinimum"); ok && v <= exclusiveMinimum {
+		s.addViolation(violations, path, fmt.Sprintf("value must be > %v", exclusiveMinimum))
+	}
+	if exclusiveMaximum, ok := schemaNumber(schema, "exclusiveMaximum"); ok && v >= exclusiveMaximum {
+		s.addViolation(violations, path, fmt.Sprintf("value must be < %v", exclusiveMaximum))
+	}
+}
+
+func (s *Schema) validateObject(schema map[string]interface{}, v map[string]interface{}, path string, violations *[]SchemaViolation) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, _ := r.(string); name != "" {
+				if _, exists := v[name]; !exists {
+					s.addViolation(violations, path+"/"+escapePointer(name), "required property is missing")
+				}
+			}
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	additional, hasAdditional := schema["additionalProperties"]
+	for _, name := range slices.Sorted(maps.Keys(v)) {
+		propPath := path + "/" + escapePointer(name)
+		if sub, ok := properties[name]; ok {
+			s.validate(sub, v[name], propPath, violations)
+		} else if hasAdditional {
+			if allowed, isBool := additional.(bool); isBool && !allowed {
+				s.addViolation(violations, propPath, "additional property is not allowed")
+			} else if !isBool {
+				s.validate(additional, v[name], propPath, violations)
+			}
+		}
+	}
+}
+
+func (s *Schema) validateArray(schema map[string]interface{}, v []interface{}, path string, violations *[]SchemaViolation) {
+	if minItems, ok := schemaNumber(schema, "minItems"); ok && float64(len(v)) < minItems {
+		s.addViolation(violations, path, fmt.Sprintf("array must have >= %v items", minItems))
+	}
+	if maxItems, ok := schemaNumber(schema, "maxItems"); ok && float64(len(v)) > maxItems {
+		s.addViolation(violations, path, fmt.Sprintf("array must have <= %v items", maxItems))
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range v {
+			for j := range i {
+				if jsonEqual(v[i], v[j]) {
+					s.addViolation(violations, path, fmt.Sprintf("array items %d and %d must be unique", j, i))
+				}
+			}
+		}
+	}
+	if items, ok := schema["items"]; ok {
+		for i, item := range v {
+			s.validate(items, item, path+"/"+strconv.Itoa(i), violations)
+		}
+	}
+}
+
+// countValid returns the number of subschemas the value is valid against
+func (s *Schema) countValid(schemas []interface{}, value interface{}, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		var subViolations []SchemaViolation
+		s.validate(sub, value, path, &subViolations)
+		if len(subViolations) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// resolveRef resolves local references (JSON pointers starting with #) within the schema document
+func (s *Schema) resolveRef(ref string) (interface{}, error) {
+	pointer, isLocal := strings.CutPrefix(ref, "#")
+	if !isLocal {
+		return nil, fmt.Errorf("unsupported $ref %s, only local references are supported", ref)
+	}
+	node := s.root
+	for token := range strings.SplitSeq(strings.TrimPrefix(pointer, "/"), "/") {
+		if token == "" {
+			continue
+		}
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot resolve $ref %s", ref)
+		}
+		if node, ok = obj[unescapePointer(token)]; !ok {
+			return nil, fmt.Errorf("cannot resolve $ref %s", ref)
+		}
+	}
+	return node, nil
+}
+
+func (s *Schema) addViolation(violations *[]SchemaViolation, path string, message string) {
+	*violations = append(*violations, SchemaViolation{Path: path, Message: message})
+}
+
+func matchesType(t interface{}, value interface{}) bool {
+	actual := jsonType(value)
+	for _, name := range typeNames(t) {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeNames(t interface{}) []string {
+	switch v := t.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		names := make([]string, 0, len(v))
+		for _, n := range v {
+			if s, ok := n.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// jsonType returns the JSON Schema type name of a generic JSON value
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// jsonEqual compares JSON values, numbers are compared by value regardless of their representation
+func jsonEqual(a, b interface{}) bool {
+	na, aIsNum := toFloat(a)
+	nb, bIsNum := toFloat(b)
+	if aIsNum || bIsNum {
+		return aIsNum && bIsNum && na == nb
+	}
+	switch av := a.(type) {
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			if other, exists := bv[k]; !exists || !jsonEqual(v, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func schemaNumber(schema map[string]interface{}, keyword string) (float64, bool) {
+	return toFloat(schema[keyword])
+}
+
+func compactJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func unescapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+}
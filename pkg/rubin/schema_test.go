@@ -0,0 +1,184 @@
+package rubin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+)
+
+var deploymentSchema = testutil.TestDataDir + "/schema-deployment.json"
+
+func TestSchemaValidate(t *testing.T) {
+	document, err := os.ReadFile(deploymentSchema)
+	assert.NoError(t, err)
+	schema, err := CompileSchema("deployment", document)
+	assert.NoError(t, err)
+
+	assert.NoError(t, schema.Validate(`{"app": "api", "version": "v1.2.3", "env": "prod", "replicas": 3, "tags": ["a", "b"]}`))
+	assert.NoError(t, schema.Validate(map[string]interface{}{"app": "api", "version": "1.0.0"}))
+
+	err = schema.Validate(`{"app": "", "version": "latest", "env": "qa", "replicas": 1.5, "tags": ["a", "a", "this-tag-is-way-too-long"], "extra": true}`)
+	var validationErr *SchemaValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.ErrorIs(t, err, ErrSchemaValidation)
+	var paths []string
+	for _, v := range validationErr.Violations {
+		paths = append(paths, v.Path)
+	}
+	assert.ElementsMatch(t, []string{"/app", "/env", "/extra", "/replicas", "/tags", "/tags/2", "/version"}, paths)
+	assert.Contains(t, err.Error(), "/version: value must match pattern")
+
+	err = schema.Validate(`{"version": "1.0.0"}`)
+	assert.ErrorContains(t, err, "/app: required property is missing")
+	err = schema.Validate("plain text")
+	assert.ErrorContains(t, err, "(root): expected type [object] but got string")
+
+	// payloads are validated as they're sent by Produce
+	assert.NoError(t, schema.Validate(json.RawMessage(`{"app": "api", "version": "1.0.0"}`)))
+	assert.ErrorContains(t, schema.Validate([]byte(`{"app": "api", "version": "1.0.0"}`)), "expected type [object] but got string",
+		"[]byte is marshalled as base64 string")
+
+	_, err = CompileSchema("broken", []byte(`[1, 2]`))
+	assert.ErrorIs(t, err, errInvalidSchema)
+}
+
+func TestSchemaCombinators(t *testing.T) {
+	schema, err := CompileSchema("combinators", []byte(`{
+		"oneOf": [{"type": "integer"}, {"type": "number", "minimum": 1}],
+		"not": {"const": 0},
+		"anyOf": [{"minimum": -5}, {"exclusiveMaximum": -10}]
+	}`))
+	assert.NoError(t, err)
+	assert.NoError(t, schema.Validate(`1.5`))
+	assert.ErrorContains(t, schema.Validate(`2`), "matches 2") // integer and >= 1
+	assert.ErrorContains(t, schema.Validate(`0`), "must not match")
+	assert.ErrorContains(t, schema.Validate(`-7.5`), "anyOf")
+
+	items, err := CompileSchema("items", []byte(`{"items": {"type": "string"}, "minItems": 1}`))
+	assert.NoError(t, err)
+	assert.NoError(t, items.Validate(`["a"]`))
+	assert.ErrorContains(t, items.Validate(`["a", 1]`), "/1: expected type [string] but got integer")
+	assert.ErrorContains(t, items.Validate(`[]`), ">= 1 items")
+}
+
+func TestSchemaUnsupported(t *testing.T) {
+	for document, msg := range map[string]string{
+		`{"properties": {"a": {"multipleOf": 2}}}`:            "unsupported keyword multipleOf",
+		`{"$defs": {"a": {"if": {"const": 1}}}}`:              "unsupported keyword if",
+		`{"items": [{"type": "string"}]}`:                     "unsupported keyword items with array value",
+		`{"$ref": "https://example.com/schema.json"}`:         "only local references are supported",
+		`{"$ref": "#/$defs/missing"}`:                         "cannot resolve $ref #/$defs/missing",
+		`{"properties": {"id": {"pattern": "^(?=.*[0-9])"}}}`: "invalid pattern ^(?=.*[0-9])",
+		`{"$ref": "#/x", "x": {"pattern": "["}}`:              "invalid pattern [",
+		`{"pattern": 42}`:                                     "pattern must be a string",
+		`{"minimum": 0, "exclusiveMinimum": true}`:            "exclusiveMinimum must be a number but got true",
+		`{"maxLength": "10"}`:                                 "maxLength must be a number",
+	} {
+		_, err := CompileSchema("unsupported", []byte(document))
+		assert.ErrorIs(t, err, errInvalidSchema, document)
+		assert.ErrorContains(t, err, msg, document)
+	}
+	_, err := CompileSchema("annotations", []byte(`{"title": "t", "format": "email", "properties": {"if": {"type": "string"}}}`))
+	assert.NoError(t, err, "annotations and properties named like keywords are fine")
+}
+
+func TestSchemaRefCycles(t *testing.T) {
+	for _, document := range []string{
+		`{"$ref": "#"}`,
+		`{"$defs": {"a": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`,
+		`{"$defs": {"a": {"allOf": [{"$ref": "#/$defs/b"}]}, "b": {"not": {"$ref": "#/$defs/a"}}}, "properties": {"x": {"$ref": "#/$defs/a"}}}`,
+		`{"$ref": "#/x", "x": {"properties": {"a": {"$ref": "#/y"}}}, "y": {"$ref": "#/y"}}`,
+	} {
+		_, err := CompileSchema("cycle", []byte(document))
+		assert.ErrorIs(t, err, errInvalidSchema, document)
+		assert.ErrorContains(t, err, "circular $ref", document)
+	}
+
+	tree, err := CompileSchema("tree", []byte(`{"type": "object", "required": ["name"],
+		"properties": {"name": {"type": "string"}, "children": {"type": "array", "items": {"$ref": "#"}}}}`))
+	assert.NoError(t, err, "recursion into the value is fine")
+	assert.NoError(t, tree.Validate(`{"name": "root", "children": [{"name": "leaf", "children": []}]}`))
+	assert.ErrorContains(t, tree.Validate(`{"name": "root", "children": [{"children": []}]}`), "/children/0/name: required property is missing")
+}
+
+func TestSchemaValidator(t *testing.T) {
+	var loaded atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		loaded.Add(1)
+		_, _ = w.Write([]byte(`{"type": "object", "required": ["id"]}`))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	v := NewSchemaValidator().ForTopic("public.deployments", deploymentSchema).ForType("order.created", srv.URL)
+
+	assert.NoError(t, v.Validate(ctx, RecordRequest{Topic: "public.other", Data: "no schema"}))
+	assert.ErrorIs(t, v.Validate(ctx, RecordRequest{Topic: "public.deployments", Data: `{"app": "api"}`}), ErrSchemaValidation)
+	// schema of the CloudEvent type takes precedence over the schema of the topic
+	request := RecordRequest{Topic: "public.deployments", Data: `{"id": 1}`, AsCloudEvent: true, Type: "order.created"}
+	assert.NoError(t, v.Validate(ctx, request))
+	assert.NoError(t, v.Validate(ctx, request))
+	assert.Equal(t, int32(1), loaded.Load(), "schema is cached")
+	request.AsCloudEvent = false
+	assert.ErrorIs(t, v.Validate(ctx, request), ErrSchemaValidation)
+
+	_, err := NewSchemaValidator().Schema(ctx, "nope.json")
+	assert.ErrorIs(t, err, errInvalidSchema)
+	_, err = NewSchemaValidator().Schema(ctx, srv.URL+"/missing\x7f")
+	assert.Error(t, err)
+}
+
+func TestSchemaValidatorLoad(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/large.json" {
+			_, _ = w.Write([]byte(`{"description": "` + strings.Repeat("x", maxSchemaSize) + `"}`))
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	v := NewSchemaValidator()
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := v.Schema(ctx, srv.URL)
+			assert.ErrorContains(t, err, "http status 503")
+		}()
+	}
+	wg.Wait()
+	_, err := v.Schema(ctx, srv.URL)
+	assert.ErrorIs(t, err, errInvalidSchema)
+	assert.Equal(t, int32(1), requests.Load(), "concurrent loads are shared and errors are cached")
+
+	_, err = v.Schema(ctx, srv.URL+"/large.json")
+	assert.ErrorContains(t, err, "exceeds")
+}
+
+func TestProduceWithSchemaValidator(t *testing.T) {
+	srv := testutil.ServerMock()
+	defer srv.Close()
+	client := NewClient(&Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw"})
+	client.SetSchemaValidator(NewSchemaValidator().ForTopic(testutil.Topic(200), deploymentSchema))
+	_, err := client.Produce(context.Background(), RecordRequest{Topic: testutil.Topic(200), Data: `{"app": "api"}`})
+	assert.ErrorIs(t, err, ErrSchemaValidation)
+	assert.False(t, IsRetriable(err))
+	_, err = client.Produce(context.Background(), RecordRequest{Topic: testutil.Topic(200), Data: `{"app": "api", "version": "1.0.0"}`})
+	assert.NoError(t, err)
+}
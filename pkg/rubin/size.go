@@ -0,0 +1,72 @@
+package rubin
+
+import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/confluentinc/kafka-rest-sdk-go/kafkarestv3"
+)
+
+// ErrRecordTooLarge is wrapped by RecordTooLargeError, use errors.Is(err, ErrRecordTooLarge) to detect it
+var ErrRecordTooLarge = errors.New("record too large")
+
+// RecordTooLargeError is returned by Produce without sending the record if its size exceeds Options.MaxRecordBytes.
+// Size is the sum of key, value and headers, the broker adds some bytes of overhead per record (see max.message.bytes)
+type RecordTooLargeError struct {
+	Topic string
+	Size  int64
+	Limit int64
+}
+
+func (e *RecordTooLargeError) Error() string {
+	return fmt.Sprintf("%s for topic %s: computed size %d bytes (key, value and headers) exceeds limit of %d bytes",
+		ErrRecordTooLarge, e.Topic, e.Size, e.Limit)
+}
+
+func (e *RecordTooLargeError) Unwrap() error {
+	return ErrRecordTooLarge
+}
+
+// checkSize returns a RecordTooLargeError if the size check is enabled and the record exceeds the limit
+func (c *Client) checkSize(prepared preparedRequest) error {
+	if c.options.MaxRecordBytes <= 0 {
+		return nil
+	}
+	if size := recordSize(prepared.payload); size > c.options.MaxRecordBytes {
+		return &RecordTooLargeError{Topic: prepared.topic, Size: size, Limit: c.options.MaxRecordBytes}
+	}
+	return nil
+}
+
+// recordSize returns the size of the Kafka record built from the request, i.e. the decoded key and header values
+// and the serialized value
+func recordSize(payload kafkarestv3.ProduceRequest) int64 {
+	size := dataSize(payload.Key) + dataSize(payload.Value)
+	for _, header := range payload.Headers {
+		size += int64(len(header.Name))
+		if header.Value == nil {
+			continue
+		}
+		if decoded, err := b64.StdEncoding.DecodeString(*header.Value); err == nil {
+			size += int64(len(decoded))
+		}
+	}
+	return size
+}
+
+// dataSize returns the size of the serialized data, binary keys are base64 decoded
+func dataSize(data *kafkarestv3.ProduceRequestData) int64 {
+	if data == nil || data.Data == nil {
+		return 0
+	}
+	if s, isString := (*data.Data).(string); isString {
+		if decoded, err := b64.StdEncoding.DecodeString(s); err == nil && data.Type == "BINARY" {
+			return int64(len(decoded))
+		}
+		return int64(len(s))
+	}
+	b, _ := json.Marshal(*data.Data)
+	return int64(len(b))
+}
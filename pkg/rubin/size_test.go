@@ -0,0 +1,35 @@
+package rubin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+)
+
+func TestMaxRecordBytes(t *testing.T) {
+	srv := testutil.ServerMock()
+	defer srv.Close()
+	cc := NewClient(&Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw", MaxRecordBytes: 100})
+	ctx := context.Background()
+
+	// key (4) + value (90) + header (5+1) is exactly at the limit
+	_, err := cc.Produce(ctx, RecordRequest{Topic: testutil.Topic(200), Key: "1234", Data: strings.Repeat("x", 90), Headers: map[string]string{"trace": "1"}})
+	assert.NoError(t, err)
+
+	_, err = cc.Produce(ctx, RecordRequest{Topic: testutil.Topic(200), Key: "1234", Data: strings.Repeat("x", 91), Headers: map[string]string{"trace": "1"}})
+	assert.ErrorIs(t, err, ErrRecordTooLarge)
+	var tooLarge *RecordTooLargeError
+	assert.True(t, errors.As(err, &tooLarge))
+	assert.Equal(t, int64(101), tooLarge.Size)
+	assert.Equal(t, int64(100), tooLarge.Limit)
+	assert.Contains(t, err.Error(), "computed size 101 bytes")
+	assert.False(t, IsRetriable(err))
+
+	// JSON values are counted in their serialized form
+	_, err = cc.Produce(ctx, RecordRequest{Topic: testutil.Topic(200), Key: "1", Data: map[string]string{"msg": strings.Repeat("x", 100)}})
+	assert.ErrorIs(t, err, ErrRecordTooLarge)
+}
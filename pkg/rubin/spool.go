@@ -0,0 +1,373 @@
+package rubin
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultSpoolMaxSize        = 100 << 20 // 100 MiB
+	defaultSpoolMaxSegmentSize = 1 << 20   // 1 MiB
+	spoolSegmentPrefix         = "segment-"
+	spoolSegmentSuffix         = ".spool"
+	// spoolClaimedSuffix marks segments that have been claimed by a replay, so no process appends to them anymore
+	spoolClaimedSuffix = ".replay"
+	// spoolAppendLock serializes appends and claims of segments, spoolReplayLock is held while a replay is running
+	spoolAppendLock = "append.lock"
+	spoolReplayLock = "replay.lock"
+	// spoolMaxRecordSize is the max length of a line that can be read from a segment, incl. checksum and newline.
+	// Single records may exceed the default segment size, but larger records are rejected by Append
+	spoolMaxRecordSize = defaultSpoolMaxSegmentSize << 4
+	spoolDirPerm       = 0o700
+	spoolFilePerm      = 0o600
+)
+
+var (
+	// ErrSpoolFull is returned by Spool.Append if the record would exceed the max size of the spool
+	ErrSpoolFull = errors.New("spool is full")
+	errSpool     = errors.New("spool error")
+	// errSpoolLocked is returned by lockFile if the lock is held by another process and we don't wait for it
+	errSpoolLocked = errors.New("spool is locked by another process")
+)
+
+// SpoolOptions configure location and size limits of the Spool, zero values are replaced by defaults
+type SpoolOptions struct {
+	// Dir is the directory for segment files, created if it doesn't exist
+	Dir string
+	// MaxSize is the max total size in bytes of all segments (default 100 MiB)
+	MaxSize int64
+	// MaxSegmentSize is the size in bytes after which a new segment file is started (default 1 MiB)
+	MaxSegmentSize int64
+}
+
+// SpoolStats are returned by Spool.Replay
+type SpoolStats struct {
+	// Sent is the number of records that have been successfully re-sent and removed from the spool
+	Sent int
+	// Remaining is the number of valid records that are still in the spool
+	Remaining int
+	// Corrupted is the number of unreadable records (e.g. partially written during a crash) that were skipped
+	Corrupted int
+}
+
+// Spool is a durable on-disk queue for records that could not be produced, e.g. because the REST Proxy is unreachable.
+// Records are appended to segment files as JSON lines prefixed with a CRC32 checksum, and synced to disk before Append returns.
+// Replay re-sends them in order and removes them from the spool only after they have been sent (at-least-once), unreadable
+// lines are skipped. A Spool is safe for concurrent use, also by multiple processes using the same directory (e.g. produce
+// and flush-spool): appends are serialized by a lock file, and a replay claims the segments it sends by renaming them
+type Spool struct {
+	options SpoolOptions
+	// mu guards the current segment and the sequence, replayMu makes sure only one replay runs at a time
+	mu          sync.Mutex
+	replayMu    sync.Mutex
+	current     *os.File
+	currentName string
+	currentSize int64
+	nextSeq     int
+}
+
+// NewSpool opens the spool in options.Dir, existing segments are retained
+func NewSpool(options SpoolOptions) (*Spool, error) {
+	options.MaxSize = cmp.Or(options.MaxSize, defaultSpoolMaxSize)
+	options.MaxSegmentSize = cmp.Or(options.MaxSegmentSize, defaultSpoolMaxSegmentSize)
+	if err := os.MkdirAll(options.Dir, spoolDirPerm); err != nil {
+		return nil, fmt.Errorf("%w: cannot create spool dir: %w", errSpool, err)
+	}
+	return &Spool{options: options}, nil
+}
+
+// Append persists the record at the end of the spool. If the record has no timestamp, the current time is used,
+// so the original event time is retained when the record is re-sent later
+func (s *Spool) Append(request RecordRequest) error {
+	line, err := encodeSpoolRecord(request)
+	if err != nil {
+		return err
+	}
+	if len(line) > spoolMaxRecordSize {
+		return fmt.Errorf("%w: spooled record of %d bytes exceeds the max of %d bytes", ErrRecordTooLarge, len(line), spoolMaxRecordSize)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(filepath.Join(s.options.Dir, spoolAppendLock), true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if s.current != nil {
+		if _, err := os.Stat(s.currentName); err != nil {
+			// the segment has been claimed by a replay (maybe of another process) in the meantime
+			if err := s.closeCurrent(); err != nil {
+				return err
+			}
+		}
+	}
+	size, err := s.size()
+	if err != nil {
+		return err
+	}
+	if size+int64(len(line)) > s.options.MaxSize {
+		return fmt.Errorf("%w: max size %d bytes exceeded", ErrSpoolFull, s.options.MaxSize)
+	}
+	if s.current == nil || s.currentSize+int64(len(line)) > s.options.MaxSegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.current.Write(line)
+	s.currentSize += int64(n)
+	if err != nil {
+		return fmt.Errorf("%w: cannot write segment: %w", errSpool, err)
+	}
+	if err := s.current.Sync(); err != nil {
+		return fmt.Errorf("%w: cannot sync segment: %w", errSpool, err)
+	}
+	return nil
+}
+
+// Replay passes the spooled records in order to send, and removes them once send succeeded. Replay stops at the first
+// error returned by send, and returns it together with the stats. Records appended while the replay is running are
+// written to a new segment and are not included. If another process is replaying the spool, Replay fails immediately
+func (s *Spool) Replay(ctx context.Context, send func(ctx context.Context, request RecordRequest) error) (SpoolStats, error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+	var stats SpoolStats
+	unlock, err := lockFile(filepath.Join(s.options.Dir, spoolReplayLock), false)
+	if err != nil {
+		return stats, err
+	}
+	defer unlock()
+	segments, err := s.claim()
+	if err != nil {
+		return stats, err
+	}
+	for i, segment := range segments {
+		records, corrupted, err := readSegment(segment)
+		stats.Corrupted += corrupted
+		if err != nil {
+			return stats, err
+		}
+		for j, request := range records {
+			if err := ctx.Err(); err != nil {
+				return s.keepRemaining(stats, segment, records[j:], segments[i+1:], err)
+			}
+			if err := send(ctx, request); err != nil {
+				return s.keepRemaining(stats, segment, records[j:], segments[i+1:], err)
+			}
+			stats.Sent++
+		}
+		if err := os.Remove(segment); err != nil {
+			return stats, fmt.Errorf("%w: cannot remove segment: %w", errSpool, err)
+		}
+	}
+	return stats, nil
+}
+
+// Len returns the number of valid records in the spool
+func (s *Spool) Len() (int, error) {
+	s.mu.Lock()
+	segments, err := s.segments(spoolClaimedSuffix, spoolSegmentSuffix)
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, segment := range segments {
+		records, _, err := readSegment(segment)
+		if err != nil {
+			return count, err
+		}
+		count += len(records)
+	}
+	return count, nil
+}
+
+// Close closes the current segment file, the spool can still be replayed
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeCurrent()
+}
+
+// keepRemaining rewrites the segment with the records that have not been sent, so they are not sent again.
+// If the process crashes before the rewrite, the sent records are sent again on the next replay (at-least-once)
+func (s *Spool) keepRemaining(stats SpoolStats, segment string, remaining []RecordRequest, segments []string, err error) (SpoolStats, error) {
+	stats.Remaining = len(remaining)
+	for _, other := range segments {
+		records, _, _ := readSegment(other)
+		stats.Remaining += len(records)
+	}
+	var buf bytes.Buffer
+	for _, request := range remaining {
+		line, _ := encodeSpoolRecord(request) // has been decoded from json before, so it can be encoded again
+		buf.Write(line)
+	}
+	tmp := segment + ".tmp"
+	if wErr := os.WriteFile(tmp, buf.Bytes(), spoolFilePerm); wErr != nil {
+		return stats, errors.Join(err, fmt.Errorf("%w: cannot rewrite segment: %w", errSpool, wErr))
+	}
+	if rErr := os.Rename(tmp, segment); rErr != nil {
+		return stats, errors.Join(err, fmt.Errorf("%w: cannot rewrite segment: %w", errSpool, rErr))
+	}
+	return stats, err
+}
+
+// claim closes the current segment and renames all segments, so new appends (also of other processes) go to a new
+// segment. It returns the claimed segments incl. the ones left over by an interrupted replay, oldest first
+func (s *Spool) claim() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(filepath.Join(s.options.Dir, spoolAppendLock), true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := s.closeCurrent(); err != nil {
+		return nil, err
+	}
+	segments, err := s.segments(spoolSegmentSuffix)
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		if err := os.Rename(segment, strings.TrimSuffix(segment, spoolSegmentSuffix)+spoolClaimedSuffix); err != nil {
+			return nil, fmt.Errorf("%w: cannot claim segment: %w", errSpool, err)
+		}
+	}
+	return s.segments(spoolClaimedSuffix)
+}
+
+// rotate closes the current segment and starts a new one after the newest segment on disk, since other processes may
+// have created segments in the meantime. It must be called with mu and the append lock held
+func (s *Spool) rotate() error {
+	if err := s.closeCurrent(); err != nil {
+		return err
+	}
+	segments, err := s.segments(spoolClaimedSuffix, spoolSegmentSuffix)
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 {
+		s.nextSeq = max(s.nextSeq, segmentSeq(segments[len(segments)-1])+1)
+	}
+	name := filepath.Join(s.options.Dir, fmt.Sprintf("%s%012d%s", spoolSegmentPrefix, s.nextSeq, spoolSegmentSuffix))
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_EXCL|os.O_WRONLY, spoolFilePerm)
+	if err != nil {
+		return fmt.Errorf("%w: cannot create segment: %w", errSpool, err)
+	}
+	s.nextSeq++
+	s.current, s.currentName, s.currentSize = f, name, 0
+	return nil
+}
+
+func (s *Spool) closeCurrent() error {
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	if err != nil {
+		return fmt.Errorf("%w: cannot close segment: %w", errSpool, err)
+	}
+	return nil
+}
+
+// segments returns the segment files with one of the suffixes sorted by sequence number, i.e. oldest first
+func (s *Spool) segments(suffixes ...string) ([]string, error) {
+	var segments []string
+	for _, suffix := range suffixes {
+		matches, err := filepath.Glob(filepath.Join(s.options.Dir, spoolSegmentPrefix+"*"+suffix))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errSpool, err)
+		}
+		segments = append(segments, matches...)
+	}
+	slices.SortFunc(segments, func(a, b string) int { return cmp.Compare(segmentSeq(a), segmentSeq(b)) })
+	return segments, nil
+}
+
+func (s *Spool) size() (int64, error) {
+	segments, err := s.segments(spoolClaimedSuffix, spoolSegmentSuffix)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, segment := range segments {
+		if fi, err := os.Stat(segment); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size, nil
+}
+
+func segmentSeq(segment string) int {
+	name := strings.TrimPrefix(filepath.Base(segment), spoolSegmentPrefix)
+	seq, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(name, spoolSegmentSuffix), spoolClaimedSuffix))
+	return seq
+}
+
+// encodeSpoolRecord returns the line "<crc32 hex> <json>\n" for the request
+func encodeSpoolRecord(request RecordRequest) ([]byte, error) {
+	request.Timestamp = cmp.Or(request.Timestamp, time.Now())
+	rec, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot marshal record: %w", errSpool, err)
+	}
+	return fmt.Appendf(nil, "%08x %s\n", crc32.ChecksumIEEE(rec), rec), nil
+}
+
+// readSegment returns the valid records of a segment and the number of corrupted lines,
+// i.e. lines with invalid checksum or json (e.g. a partially written last line)
+func readSegment(segment string) ([]RecordRequest, int, error) {
+	f, err := os.Open(segment)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: cannot open segment: %w", errSpool, err)
+	}
+	defer func() { _ = f.Close() }()
+	var records []RecordRequest
+	var corrupted int
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, spoolMaxRecordSize)
+	for scanner.Scan() {
+		request, err := decodeSpoolRecord(scanner.Bytes())
+		if err != nil {
+			log.Warn().Str("logger", "spool").Msgf("Skipping corrupted record in %s: %v", filepath.Base(segment), err)
+			corrupted++
+			continue
+		}
+		records = append(records, request)
+	}
+	if err := scanner.Err(); err != nil {
+		return records, corrupted, fmt.Errorf("%w: cannot read segment: %w", errSpool, err)
+	}
+	return records, corrupted, nil
+}
+
+func decodeSpoolRecord(line []byte) (RecordRequest, error) {
+	checksum, rec, found := bytes.Cut(line, []byte(" "))
+	if !found {
+		return RecordRequest{}, fmt.Errorf("%w: missing checksum", errSpool)
+	}
+	if string(checksum) != fmt.Sprintf("%08x", crc32.ChecksumIEEE(rec)) {
+		return RecordRequest{}, fmt.Errorf("%w: checksum mismatch", errSpool)
+	}
+	var request RecordRequest
+	if err := json.Unmarshal(rec, &request); err != nil {
+		return RecordRequest{}, fmt.Errorf("%w: %w", errSpool, err)
+	}
+	return request, nil
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package rubin
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile only creates the lock file on platforms without flock, so processes are not serialized there.
+// Replays still claim segments before sending them, but concurrent appends and replays are not safe
+func lockFile(name string, _ bool) (unlock func(), err error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, spoolFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot open lock file: %w", errSpool, err)
+	}
+	return func() { _ = f.Close() }, nil
+}
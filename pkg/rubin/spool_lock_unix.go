@@ -0,0 +1,32 @@
+//go:build unix
+
+package rubin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile acquires an exclusive advisory lock (flock) on the file, which is created if it doesn't exist.
+// If wait is false, it fails with errSpoolLocked instead of waiting for another process to release the lock.
+// The lock is also released by the OS if the process dies
+func lockFile(name string, wait bool) (unlock func(), err error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, spoolFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot open lock file: %w", errSpool, err)
+	}
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil { // #nosec G115 -- file descriptors fit into an int
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", errSpoolLocked, name)
+		}
+		return nil, fmt.Errorf("%w: cannot lock %s: %w", errSpool, name, err)
+	}
+	return func() { _ = f.Close() }, nil // closing the file releases the lock
+}
@@ -0,0 +1,203 @@
+package rubin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/internal/testutil"
+)
+
+var errTestSend = errors.New("proxy still down")
+
+func TestSpoolAppendAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := NewSpool(SpoolOptions{Dir: dir, MaxSegmentSize: 200})
+	assert.NoError(t, err)
+	partition := int32(2)
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 123000000, time.UTC)
+	assert.NoError(t, spool.Append(RecordRequest{Topic: "public.hello", Data: "first", Key: "1", Partition: &partition, Timestamp: ts}))
+	assert.NoError(t, spool.Append(RecordRequest{Topic: "public.hello", Data: map[string]interface{}{"id": 2}, Headers: map[string]string{"h": "v"}}))
+	assert.NoError(t, spool.Append(RecordRequest{Topic: "public.hello", Data: `{"id":3}`, AsCloudEvent: true, Type: "t"}))
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.spool"))
+	assert.Greater(t, len(segments), 1) // segment size exceeded
+	assert.NoError(t, spool.Close())
+
+	// reopen to make sure the spool survives restarts, first replay fails after the first record
+	spool, err = NewSpool(SpoolOptions{Dir: dir, MaxSegmentSize: 200})
+	assert.NoError(t, err)
+	var sent []RecordRequest
+	stats, err := spool.Replay(context.Background(), func(_ context.Context, request RecordRequest) error {
+		if len(sent) == 1 {
+			return errTestSend
+		}
+		sent = append(sent, request)
+		return nil
+	})
+	assert.ErrorIs(t, err, errTestSend)
+	assert.Equal(t, SpoolStats{Sent: 1, Remaining: 2}, stats)
+	assert.Equal(t, "first", sent[0].Data)
+	assert.Equal(t, int32(2), *sent[0].Partition)
+	assert.Equal(t, ts, sent[0].Timestamp.UTC())
+
+	// records appended during replay go to a new segment and are replayed in order
+	assert.NoError(t, spool.Append(RecordRequest{Topic: "public.hello", Data: "fourth"}))
+	n, err := spool.Len()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	stats, err = spool.Replay(context.Background(), func(_ context.Context, request RecordRequest) error {
+		sent = append(sent, request)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stats.Sent)
+	assert.Equal(t, map[string]interface{}{"id": float64(2)}, sent[1].Data)
+	assert.Equal(t, "v", sent[1].Headers["h"])
+	assert.Equal(t, `{"id":3}`, sent[2].Data)
+	assert.True(t, sent[2].AsCloudEvent)
+	assert.False(t, sent[2].Timestamp.IsZero()) // time of append is retained
+	assert.Equal(t, "fourth", sent[3].Data)
+	segments, _ = filepath.Glob(filepath.Join(dir, "*.spool"))
+	assert.Empty(t, segments)
+}
+
+func TestSpoolCorruptionAndLimits(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := NewSpool(SpoolOptions{Dir: dir, MaxSize: 300})
+	assert.NoError(t, err)
+	assert.NoError(t, spool.Append(RecordRequest{Topic: "public.hello", Data: "ok"}))
+	// simulate a bit flip and a partially written last line (crash during append)
+	segment := filepath.Join(dir, "segment-000000000000.spool")
+	content, _ := os.ReadFile(segment)
+	corrupted := append([]byte{}, content...)
+	corrupted[len(corrupted)-5] = 'X'
+	assert.NoError(t, os.WriteFile(segment, append(append(content, corrupted...), content[:20]...), 0o600))
+
+	stats, err := spool.Replay(context.Background(), func(_ context.Context, _ RecordRequest) error { return nil })
+	assert.NoError(t, err)
+	assert.Equal(t, SpoolStats{Sent: 1, Corrupted: 2}, stats)
+
+	assert.NoError(t, spool.Append(RecordRequest{Topic: "public.hello", Data: "ok"}))
+	assert.ErrorIs(t, spool.Append(RecordRequest{Topic: "public.hello", Data: string(make([]byte, 300))}), ErrSpoolFull)
+
+	// records that could not be read again are rejected, so they don't block the replay
+	spool, err = NewSpool(SpoolOptions{Dir: t.TempDir(), MaxSize: 2 * spoolMaxRecordSize})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, spool.Append(RecordRequest{Topic: "public.hello", Data: strings.Repeat("x", spoolMaxRecordSize)}), ErrRecordTooLarge)
+	n, _ := spool.Len()
+	assert.Equal(t, 0, n)
+}
+
+func TestSpoolMultipleProcesses(t *testing.T) {
+	// each Spool has its own lock file descriptors, so two of them behave like two processes
+	dir := t.TempDir()
+	producer, err := NewSpool(SpoolOptions{Dir: dir})
+	assert.NoError(t, err)
+	flusher, err := NewSpool(SpoolOptions{Dir: dir})
+	assert.NoError(t, err)
+	other, err := NewSpool(SpoolOptions{Dir: dir})
+	assert.NoError(t, err)
+	assert.NoError(t, producer.Append(RecordRequest{Topic: "public.hello", Data: "first"}))
+
+	var sent []string
+	stats, err := flusher.Replay(context.Background(), func(_ context.Context, request RecordRequest) error {
+		// appends to the segment that is being replayed must not get lost
+		assert.NoError(t, producer.Append(RecordRequest{Topic: "public.hello", Data: "second"}))
+		_, err := other.Replay(context.Background(), func(_ context.Context, _ RecordRequest) error { return nil })
+		assert.ErrorIs(t, err, errSpoolLocked, "only one replay at a time")
+		sent = append(sent, request.Data.(string))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Sent)
+	n, _ := producer.Len()
+	assert.Equal(t, 1, n)
+
+	// a segment claimed by an interrupted replay is replayed first
+	_, err = flusher.Replay(context.Background(), func(_ context.Context, _ RecordRequest) error { return errTestSend })
+	assert.ErrorIs(t, err, errTestSend)
+	assert.NoError(t, producer.Append(RecordRequest{Topic: "public.hello", Data: "third"}))
+	stats, err = other.Replay(context.Background(), func(_ context.Context, request RecordRequest) error {
+		sent = append(sent, request.Data.(string))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Sent)
+	assert.Equal(t, []string{"first", "second", "third"}, sent)
+	assert.NoError(t, producer.Close())
+}
+
+func TestAsyncProducerWithSpool(t *testing.T) {
+	down := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		respBytes, _ := os.ReadFile(testutil.TestDataDir + "/response-200.json")
+		_, _ = w.Write(respBytes)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	spool, err := NewSpool(SpoolOptions{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	var d deliveries
+	p, err := NewAsyncProducer(ctx, testClient(srv.URL), AsyncOptions{Spool: spool, SpoolReplayInterval: time.Hour, OnDelivery: d.onDelivery})
+	assert.NoError(t, err)
+	assert.NoError(t, p.Produce(ctx, RecordRequest{Topic: "public.hello", Data: "spool me"}))
+	assert.NoError(t, p.Flush(ctx))
+	assert.True(t, d.reports[0].Spooled)
+	assert.Error(t, d.reports[0].Err)
+	n, _ := spool.Len()
+	assert.Equal(t, 1, n)
+
+	down = false
+	p.replaySpool()
+	n, _ = spool.Len()
+	assert.Equal(t, 0, n)
+	assert.NoError(t, p.Close(ctx))
+}
+
+func TestAsyncProducerSpoolsOnCloseTimeout(t *testing.T) {
+	srv := testutil.ServerMock()
+	defer srv.Close()
+	ctx := context.Background()
+	spool, err := NewSpool(SpoolOptions{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	client := NewClient(&Options{RestEndpoint: srv.URL, ClusterID: testutil.ClusterID, ProducerAPIKey: "test.key", ProducerAPISecret: "test.pw", RateLimit: 1})
+	var d deliveries
+	p, err := NewAsyncProducer(ctx, client, AsyncOptions{Spool: spool, Linger: time.Hour, SpoolReplayInterval: time.Hour, OnDelivery: d.onDelivery})
+	assert.NoError(t, err)
+	for range 3 {
+		assert.NoError(t, p.Produce(ctx, RecordRequest{Topic: testutil.Topic(200), Data: "hello"}))
+	}
+	// the first record is sent right away, the others wait for the rate limit until Close times out
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.Close(tctx), context.DeadlineExceeded)
+	assert.Len(t, d.reports, 3)
+	assert.False(t, d.reports[0].Spooled)
+	for _, report := range d.reports[1:] {
+		assert.True(t, report.Spooled, "records waiting for the limiter are spooled")
+		assert.ErrorIs(t, report.Err, errLimiterAborted)
+	}
+	n, _ := spool.Len()
+	assert.Equal(t, 2, n)
+}
+
+func TestIsRetriable(t *testing.T) {
+	assert.True(t, IsRetriable(&APIError{StatusCode: http.StatusBadGateway}))
+	assert.True(t, IsRetriable(&APIError{StatusCode: http.StatusTooManyRequests}))
+	assert.False(t, IsRetriable(&APIError{StatusCode: http.StatusUnauthorized}))
+	assert.False(t, IsRetriable(errTestSend))
+
+	_, err := testClient("http://127.0.0.1:1").Produce(context.Background(), RecordRequest{Topic: "public.hello", Data: "unreachable"})
+	assert.True(t, IsRetriable(err))
+}
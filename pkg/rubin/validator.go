@@ -0,0 +1,167 @@
+package rubin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSchemaSize limits the size of schema documents loaded from http(s) URLs
+	maxSchemaSize = 10 << 20 // 10 MiB
+	// schemaErrorTTL is the time a failed load is cached, so an unreachable URL doesn't delay every record
+	schemaErrorTTL = 30 * time.Second
+)
+
+// SchemaValidator validates record payloads against JSON Schemas, which are registered by topic or CloudEvent type.
+// Schemas are loaded from a local file or http(s) URL on first use and cached. If a record is wrapped as CloudEvent
+// and a schema is registered for its type, it takes precedence over the schema of the topic. The payload (i.e. the
+// data of the CloudEvent) is validated, records without registered schema are not validated.
+//
+// The built-in validator supports a subset of JSON Schema (draft 7 and 2020-12), documents using other validation
+// keywords are rejected when they're loaded:
+//   - type, enum, const
+//   - properties, required, additionalProperties
+//   - items (single schema), minItems, maxItems, uniqueItems
+//   - minLength, maxLength, pattern (Go RE2 syntax, so lookahead or backreferences are rejected)
+//   - minimum, maximum, exclusiveMinimum, exclusiveMaximum (numbers, the draft 4 boolean form is rejected)
+//   - allOf, anyOf, oneOf, not
+//   - $ref to local definitions (e.g. #/$defs/name), remote references are not supported
+//
+// Annotations such as format, title or description are ignored
+type SchemaValidator struct {
+	httpClient *http.Client
+	mu         sync.Mutex
+	byTopic    map[string]string
+	byType     map[string]string
+	cache      map[string]*schemaEntry
+}
+
+// schemaEntry is the result of loading a schema, done is closed once schema or err are set
+type schemaEntry struct {
+	done     chan struct{}
+	schema   *Schema
+	err      error
+	loadedAt time.Time
+}
+
+// expired returns true if the load failed more than schemaErrorTTL ago, so it's retried
+func (e *schemaEntry) expired() bool {
+	select {
+	case <-e.done:
+		return e.err != nil && time.Since(e.loadedAt) > schemaErrorTTL
+	default:
+		return false // still loading
+	}
+}
+
+// NewSchemaValidator returns a SchemaValidator without registered schemas
+func NewSchemaValidator() *SchemaValidator {
+	return &SchemaValidator{
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		byTopic:    map[string]string{},
+		byType:     map[string]string{},
+		cache:      map[string]*schemaEntry{},
+	}
+}
+
+// ForTopic registers the schema location (file path or http(s) URL) for records of the topic
+func (v *SchemaValidator) ForTopic(topic string, location string) *SchemaValidator {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.byTopic[topic] = location
+	return v
+}
+
+// ForType registers the schema location (file path or http(s) URL) for CloudEvents of the type
+func (v *SchemaValidator) ForType(eventType string, location string) *SchemaValidator {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.byType[eventType] = location
+	return v
+}
+
+// Validate validates the payload of the request against the registered schema, see SchemaValidationError
+func (v *SchemaValidator) Validate(ctx context.Context, request RecordRequest) error {
+	v.mu.Lock()
+	location, found := v.byType[request.Type]
+	if !request.AsCloudEvent || !found {
+		location, found = v.byTopic[request.Topic]
+	}
+	v.mu.Unlock()
+	if !found {
+		return nil
+	}
+	schema, err := v.Schema(ctx, location)
+	if err != nil {
+		return err
+	}
+	return schema.Validate(request.Data)
+}
+
+// Schema returns the compiled schema from location, it's loaded only once and concurrent callers wait for the
+// same load. Errors are cached for 30s, so an unreachable URL doesn't cost a full timeout for every record
+func (v *SchemaValidator) Schema(ctx context.Context, location string) (*Schema, error) {
+	v.mu.Lock()
+	entry, cached := v.cache[location]
+	if !cached || entry.expired() {
+		entry = &schemaEntry{done: make(chan struct{})}
+		v.cache[location] = entry
+		v.mu.Unlock()
+		// the result is shared, so it must not depend on the cancellation of this caller (the http client has a timeout)
+		entry.schema, entry.err = v.compile(context.WithoutCancel(ctx), location)
+		entry.loadedAt = time.Now()
+		close(entry.done)
+		return entry.schema, entry.err
+	}
+	v.mu.Unlock()
+	select {
+	case <-entry.done:
+		return entry.schema, entry.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for schema %s aborted: %w", location, ctx.Err())
+	}
+}
+
+func (v *SchemaValidator) compile(ctx context.Context, location string) (*Schema, error) {
+	document, err := v.load(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	return CompileSchema(location, document)
+}
+
+func (v *SchemaValidator) load(ctx context.Context, location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		document, err := os.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("%w: cannot read schema: %w", errInvalidSchema, err)
+		}
+		return document, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidSchema, err)
+	}
+	res, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot load schema: %w", errInvalidSchema, err)
+	}
+	defer closeSilently(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: cannot load schema from %s: http status %d", errInvalidSchema, location, res.StatusCode)
+	}
+	document, err := io.ReadAll(io.LimitReader(res.Body, maxSchemaSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot load schema from %s: %w", errInvalidSchema, location, err)
+	}
+	if len(document) > maxSchemaSize {
+		return nil, fmt.Errorf("%w: schema %s exceeds %d bytes", errInvalidSchema, location, maxSchemaSize)
+	}
+	return document, nil
+}
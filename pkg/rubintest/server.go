@@ -0,0 +1,482 @@
+// Package rubintest provides an in-process fake Kafka REST Proxy v3 for testing code that produces records with rubin.
+// The Server stores produced records per topic and partition with incrementing offsets, so tests can assert on
+// received keys, headers and values, and supports fault injection (latency, error status sequences, auth failures
+// and connection drops) to test retry and error handling
+//
+//	srv := rubintest.NewServer(rubintest.ServerOptions{})
+//	defer srv.Close()
+//	client := rubin.NewClient(srv.Options())
+//	srv.InjectFaults(rubintest.ErrorFault(http.StatusServiceUnavailable))
+//	...
+//	records := srv.Records("public.hello")
+package rubintest
+
+import (
+	"cmp"
+	"compress/gzip"
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/kafka-rest-sdk-go/kafkarestv3"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+const (
+	defaultClusterID  = "fake-cluster"
+	defaultAPIKey     = "test-key"
+	defaultAPISecret  = "test-secret"
+	defaultPartitions = 1
+	// errorCodeNotFound is returned by the REST Proxy for unknown topics or partitions
+	errorCodeNotFound = 40403
+	// errorCodeNotAuthorized is returned by Confluent Cloud if the API key has no ACL for the topic
+	errorCodeNotAuthorized = 40301
+	// unauthorizedHTML is the body of a 401 response, which is not JSON but a HTML page
+	unauthorizedHTML = "<html><body><h1>401 Unauthorized</h1></body></html>"
+)
+
+// ServerOptions configure the fake REST Proxy, zero values are replaced by defaults
+type ServerOptions struct {
+	// ClusterID is part of the URL (default fake-cluster)
+	ClusterID string
+	// APIKey and APISecret are the valid credentials (default test-key / test-secret), other credentials are
+	// rejected with a 401 HTML page like the real REST Proxy does
+	APIKey    string
+	APISecret string
+	// Partitions is the number of partitions per topic (default 1), records without explicit partition
+	// are assigned based on the hash of their key
+	Partitions int32
+	// Topics restricts the known topics, producing to other topics fails with 404. If empty, all topics are accepted
+	Topics []string
+}
+
+// Record is a record stored by the Server
+type Record struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       string
+	Headers   map[string]string
+	// ValueType is JSON, STRING or BINARY
+	ValueType string
+	// Value is the raw JSON for type JSON (e.g. a CloudEvent), and the decoded data for STRING and BINARY
+	Value     []byte
+	Timestamp time.Time
+}
+
+// UnmarshalValue unmarshals the JSON value of the record into v
+func (r Record) UnmarshalValue(v interface{}) error {
+	return json.Unmarshal(r.Value, v)
+}
+
+// Fault is applied to a single produce request instead of the regular processing, see Server.InjectFaults.
+// Latency delays the response, and is combined with the other fields (zero values mean the request succeeds)
+type Fault struct {
+	Latency time.Duration
+	// StatusCode is the http status of the response, Body and ContentType default to a JSON error with ErrorCode
+	StatusCode  int
+	ErrorCode   int32
+	Body        string
+	ContentType string
+	// DropConnection closes the connection without response, so the client sees a network error
+	DropConnection bool
+}
+
+// ErrorFault returns a fault that responds with a JSON error and the given status, e.g. 429 or 503
+func ErrorFault(statusCode int) Fault {
+	return Fault{StatusCode: statusCode}
+}
+
+// UnauthorizedFault returns a fault that responds like the REST Proxy for invalid credentials, i.e. 401 with a HTML body
+func UnauthorizedFault() Fault {
+	return Fault{StatusCode: http.StatusUnauthorized, Body: unauthorizedHTML, ContentType: "text/html"}
+}
+
+// ForbiddenFault returns a fault that responds like Confluent Cloud if the API key is not authorized for the topic
+func ForbiddenFault() Fault {
+	return Fault{StatusCode: http.StatusForbidden, ErrorCode: errorCodeNotAuthorized}
+}
+
+// LatencyFault returns a fault that delays the response, the request is processed regularly
+func LatencyFault(latency time.Duration) Fault {
+	return Fault{Latency: latency}
+}
+
+// DropConnectionFault returns a fault that closes the connection without sending a response
+func DropConnectionFault() Fault {
+	return Fault{DropConnection: true}
+}
+
+// Repeat returns a sequence with n copies of the fault, e.g. srv.InjectFaults(rubintest.Repeat(rubintest.ErrorFault(503), 3)...)
+func Repeat(fault Fault, n int) []Fault {
+	faults := make([]Fault, n)
+	for i := range faults {
+		faults[i] = fault
+	}
+	return faults
+}
+
+// Server is the fake REST Proxy, it's safe for concurrent use
+type Server struct {
+	*httptest.Server
+	options ServerOptions
+	mu      sync.Mutex
+	records map[string][]Record
+	offsets map[string]int64
+	faults  []Fault
+	latency time.Duration
+	// requests counts produce requests incl. failed ones
+	requests int
+}
+
+// NewServer starts a fake REST Proxy, which must be closed by the caller
+func NewServer(options ServerOptions) *Server {
+	s := newServer(options)
+	s.Server = httptest.NewServer(s.routes())
+	return s
+}
+
+// NewTLSServer starts a fake REST Proxy that serves https using a self-signed certificate, use
+// Certificate() to add it to the trusted CA of the client
+func NewTLSServer(options ServerOptions) *Server {
+	s := newServer(options)
+	s.Server = httptest.NewTLSServer(s.routes())
+	return s
+}
+
+func newServer(options ServerOptions) *Server {
+	if options.ClusterID == "" {
+		options.ClusterID = defaultClusterID
+	}
+	if options.APIKey == "" && options.APISecret == "" {
+		options.APIKey, options.APISecret = defaultAPIKey, defaultAPISecret
+	}
+	if options.Partitions < 1 {
+		options.Partitions = defaultPartitions
+	}
+	return &Server{options: options, records: map[string][]Record{}, offsets: map[string]int64{}}
+}
+
+// Options returns client options with endpoint, cluster and valid credentials of the server
+func (s *Server) Options() *rubin.Options {
+	return &rubin.Options{
+		RestEndpoint:      s.URL,
+		ClusterID:         s.options.ClusterID,
+		ProducerAPIKey:    s.options.APIKey,
+		ProducerAPISecret: s.options.APISecret,
+		HTTPTimeout:       5 * time.Second,
+	}
+}
+
+// InjectFaults queues faults which are applied to the next produce requests in order, one fault per request
+func (s *Server) InjectFaults(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, faults...)
+}
+
+// SetLatency delays all responses, in addition to the latency of injected faults
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+}
+
+// Records returns the records produced to the topic, ordered by arrival
+func (s *Server) Records(topic string) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.records[topic])
+}
+
+// Topics returns the topics that records have been produced to, sorted by name
+func (s *Server) Topics() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	topics := make([]string, 0, len(s.records))
+	for topic := range s.records {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	return topics
+}
+
+// Requests returns the number of produce requests received, including failed ones
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Reset removes all records and pending faults, and resets latency and request counter
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records, s.offsets = map[string][]Record{}, map[string]int64{}
+	s.faults, s.latency, s.requests = nil, 0, 0
+}
+
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /kafka/v3/clusters/{cluster}/topics/{topic}/records", s.authorized(s.handleProduce))
+	mux.HandleFunc("GET /kafka/v3/clusters", s.authorized(s.handleClusters))
+	mux.HandleFunc("GET /kafka/v3/clusters/{cluster}", s.authorized(s.handleCluster))
+	mux.HandleFunc("GET /kafka/v3/clusters/{cluster}/topics", s.authorized(s.handleTopics))
+	mux.HandleFunc("GET /kafka/v3/clusters/{cluster}/topics/{topic}", s.authorized(s.handleTopic))
+	return mux
+}
+
+// authorized checks the credentials and the cluster id of the request before calling next
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if user, password, _ := req.BasicAuth(); user != s.options.APIKey || password != s.options.APISecret {
+			writeFault(w, UnauthorizedFault())
+			return
+		}
+		if cluster := req.PathValue("cluster"); cluster != "" && cluster != s.options.ClusterID {
+			writeError(w, http.StatusNotFound, errorCodeNotFound, "Cluster "+cluster+" not found")
+			return
+		}
+		next(w, req)
+	}
+}
+
+func (s *Server) handleProduce(w http.ResponseWriter, req *http.Request) {
+	fault, latency := s.nextFault()
+	time.Sleep(latency + fault.Latency)
+	if fault.DropConnection {
+		dropConnection(w)
+		return
+	}
+	if fault.StatusCode != 0 {
+		writeFault(w, fault)
+		return
+	}
+	body := io.Reader(req.Body)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, http.StatusBadRequest, "Invalid gzip body: "+err.Error())
+			return
+		}
+		body = zr
+	}
+	var produceReq kafkarestv3.ProduceRequest
+	if err := json.NewDecoder(body).Decode(&produceReq); err != nil {
+		writeError(w, http.StatusBadRequest, http.StatusBadRequest, "Invalid produce request: "+err.Error())
+		return
+	}
+	topic := req.PathValue("topic")
+	if !s.knownTopic(topic) {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "This server does not host this topic-partition.")
+		return
+	}
+	record, err := decodeRecord(topic, produceReq)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, http.StatusBadRequest, err.Error())
+		return
+	}
+	if produceReq.PartitionId != nil {
+		record.Partition = *produceReq.PartitionId
+	} else {
+		record.Partition = s.partition(record.Key)
+	}
+	if record.Partition < 0 || record.Partition >= s.options.Partitions {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "This server does not host this topic-partition.")
+		return
+	}
+	record = s.store(record)
+	writeJSON(w, http.StatusOK, produceResponse(s.options.ClusterID, record))
+}
+
+func (s *Server) handleClusters(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, kafkarestv3.ClusterDataList{Kind: "KafkaClusterList", Data: []kafkarestv3.ClusterData{s.clusterData()}})
+}
+
+func (s *Server) handleCluster(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.clusterData())
+}
+
+func (s *Server) handleTopics(w http.ResponseWriter, _ *http.Request) {
+	topics := kafkarestv3.TopicDataList{Kind: "KafkaTopicList", Data: []kafkarestv3.TopicData{}}
+	for _, topic := range s.knownTopics() {
+		topics.Data = append(topics.Data, s.topicData(topic))
+	}
+	writeJSON(w, http.StatusOK, topics)
+}
+
+func (s *Server) handleTopic(w http.ResponseWriter, req *http.Request) {
+	topic := req.PathValue("topic")
+	if !slices.Contains(s.knownTopics(), topic) {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "This server does not host this topic-partition.")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.topicData(topic))
+}
+
+// nextFault returns and removes the next injected fault (zero value if there's none) and the global latency
+func (s *Server) nextFault() (Fault, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if len(s.faults) == 0 {
+		return Fault{}, s.latency
+	}
+	fault := s.faults[0]
+	s.faults = s.faults[1:]
+	return fault, s.latency
+}
+
+func (s *Server) knownTopic(topic string) bool {
+	return len(s.options.Topics) == 0 || slices.Contains(s.options.Topics, topic)
+}
+
+// knownTopics returns the configured topics, or the topics that records have been produced to
+func (s *Server) knownTopics() []string {
+	if len(s.options.Topics) > 0 {
+		return slices.Sorted(slices.Values(s.options.Topics))
+	}
+	return s.Topics()
+}
+
+// partition selects the partition by key hash, similar to the default partitioner
+func (s *Server) partition(key string) int32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int32(h.Sum32() % uint32(s.options.Partitions)) // #nosec G115 -- result is less than partitions which is int32
+}
+
+// store assigns the next offset of the topic partition to the record and stores it
+func (s *Server) store(record Record) Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tp := fmt.Sprintf("%s-%d", record.Topic, record.Partition)
+	record.Offset = s.offsets[tp]
+	s.offsets[tp]++
+	s.records[record.Topic] = append(s.records[record.Topic], record)
+	return record
+}
+
+func (s *Server) clusterData() kafkarestv3.ClusterData {
+	return kafkarestv3.ClusterData{
+		Kind:      "KafkaCluster",
+		Metadata:  kafkarestv3.ResourceMetadata{Self: s.URL + "/kafka/v3/clusters/" + s.options.ClusterID},
+		ClusterId: s.options.ClusterID,
+	}
+}
+
+func (s *Server) topicData(topic string) kafkarestv3.TopicData {
+	return kafkarestv3.TopicData{
+		Kind:              "KafkaTopic",
+		Metadata:          kafkarestv3.ResourceMetadata{Self: s.URL + "/kafka/v3/clusters/" + s.options.ClusterID + "/topics/" + topic},
+		ClusterId:         s.options.ClusterID,
+		TopicName:         topic,
+		ReplicationFactor: 1,
+		PartitionsCount:   s.options.Partitions,
+	}
+}
+
+// decodeRecord converts the produce request into a Record, keys and headers are base64 encoded by the rubin client
+func decodeRecord(topic string, req kafkarestv3.ProduceRequest) (Record, error) {
+	record := Record{Topic: topic, Headers: map[string]string{}, Timestamp: time.Now()}
+	if req.Timestamp != nil {
+		record.Timestamp = *req.Timestamp
+	}
+	for _, header := range req.Headers {
+		var value []byte
+		if header.Value != nil {
+			var err error
+			if value, err = b64.StdEncoding.DecodeString(*header.Value); err != nil {
+				return record, fmt.Errorf("invalid base64 value of header %s: %w", header.Name, err)
+			}
+		}
+		record.Headers[header.Name] = string(value)
+	}
+	if req.Key != nil {
+		_, key, err := decodeData(req.Key)
+		if err != nil {
+			return record, fmt.Errorf("invalid key: %w", err)
+		}
+		record.Key = string(key)
+	}
+	if req.Value != nil {
+		var err error
+		if record.ValueType, record.Value, err = decodeData(req.Value); err != nil {
+			return record, fmt.Errorf("invalid value: %w", err)
+		}
+	}
+	return record, nil
+}
+
+func decodeData(data *kafkarestv3.ProduceRequestData) (string, []byte, error) {
+	if data.Data == nil {
+		return data.Type, nil, nil
+	}
+	switch data.Type {
+	case "BINARY":
+		s, _ := (*data.Data).(string)
+		b, err := b64.StdEncoding.DecodeString(s)
+		return data.Type, b, err
+	case "STRING":
+		s, _ := (*data.Data).(string)
+		return data.Type, []byte(s), nil
+	default:
+		b, err := json.Marshal(*data.Data)
+		return "JSON", b, err
+	}
+}
+
+func produceResponse(clusterID string, record Record) rubin.RecordResponse {
+	keyType, valueType := "BINARY", record.ValueType
+	return rubin.RecordResponse{
+		ErrorCode: http.StatusOK,
+		ProduceResponse: kafkarestv3.ProduceResponse{
+			ClusterId:   clusterID,
+			TopicName:   record.Topic,
+			PartitionId: record.Partition,
+			Offset:      int32(record.Offset), // #nosec G115 -- fake offsets don't exceed int32
+			Timestamp:   &record.Timestamp,
+			Key:         &kafkarestv3.ProduceResponseData{Type: &keyType, Size: int64(len(record.Key))},
+			Value:       &kafkarestv3.ProduceResponseData{Type: &valueType, Size: int64(len(record.Value))},
+		},
+	}
+}
+
+func writeFault(w http.ResponseWriter, fault Fault) {
+	if fault.Body == "" {
+		writeError(w, fault.StatusCode, cmp.Or(fault.ErrorCode, int32(fault.StatusCode)), http.StatusText(fault.StatusCode)) // #nosec G115 -- http status
+		return
+	}
+	w.Header().Set("Content-Type", fault.ContentType)
+	w.WriteHeader(fault.StatusCode)
+	_, _ = w.Write([]byte(fault.Body))
+}
+
+func writeError(w http.ResponseWriter, statusCode int, errorCode int32, message string) {
+	writeJSON(w, statusCode, kafkarestv3.Error{ErrorCode: errorCode, Message: &message})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// dropConnection closes the underlying connection without writing a response
+func dropConnection(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		panic("rubintest: response writer does not support hijacking")
+	}
+	conn, _, err := hj.Hijack()
+	if err == nil {
+		_ = conn.Close()
+	}
+}
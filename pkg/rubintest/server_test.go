@@ -0,0 +1,162 @@
+package rubintest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tillkuhn/rubin/pkg/rubin"
+)
+
+func TestServerStoresRecords(t *testing.T) {
+	srv := NewServer(ServerOptions{Partitions: 3})
+	defer srv.Close()
+	client := rubin.NewClient(srv.Options())
+	ctx := context.Background()
+
+	partition := int32(2)
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	resp, err := client.Produce(ctx, rubin.RecordRequest{Topic: "public.hello", Data: "Hello", Key: "k1",
+		Headers: map[string]string{"trace": "abc"}, Partition: &partition, Timestamp: ts})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), resp.PartitionId)
+	assert.Equal(t, int32(0), resp.Offset)
+	resp, err = client.Produce(ctx, rubin.RecordRequest{Topic: "public.hello", Data: `{"action":"update"}`, Partition: &partition})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), resp.Offset, "offsets increment per partition")
+
+	records := srv.Records("public.hello")
+	assert.Len(t, records, 2)
+	assert.Equal(t, "k1", records[0].Key)
+	assert.Equal(t, "abc", records[0].Headers["trace"])
+	assert.Equal(t, "STRING", records[0].ValueType)
+	assert.Equal(t, "Hello", string(records[0].Value))
+	assert.True(t, ts.Equal(records[0].Timestamp))
+	assert.Equal(t, "JSON", records[1].ValueType)
+	var value map[string]string
+	assert.NoError(t, records[1].UnmarshalValue(&value))
+	assert.Equal(t, "update", value["action"])
+	assert.Equal(t, []string{"public.hello"}, srv.Topics())
+	assert.Equal(t, 2, srv.Requests())
+
+	// partition is selected by key hash, so the same key always ends up in the same partition
+	r1, _ := client.Produce(ctx, rubin.RecordRequest{Topic: "public.keys", Data: "a", Key: "same"})
+	r2, _ := client.Produce(ctx, rubin.RecordRequest{Topic: "public.keys", Data: "b", Key: "same"})
+	assert.Equal(t, r1.PartitionId, r2.PartitionId)
+
+	srv.Reset()
+	assert.Empty(t, srv.Records("public.hello"))
+	assert.Zero(t, srv.Requests())
+}
+
+func TestServerCloudEvent(t *testing.T) {
+	srv := NewServer(ServerOptions{})
+	defer srv.Close()
+	_, err := rubin.NewClient(srv.Options()).Produce(context.Background(), rubin.RecordRequest{
+		Topic: "public.hello", Data: map[string]string{"id": "4711"}, AsCloudEvent: true, Type: "order.created", Source: "//test"})
+	assert.NoError(t, err)
+	records := srv.Records("public.hello")
+	assert.Len(t, records, 1)
+	assert.Contains(t, records[0].Headers["content-type"], "application/cloudevents+json")
+	var ce map[string]interface{}
+	assert.NoError(t, records[0].UnmarshalValue(&ce))
+	assert.Equal(t, "order.created", ce["type"])
+}
+
+func TestServerCompressedRequest(t *testing.T) {
+	srv := NewServer(ServerOptions{})
+	defer srv.Close()
+	options := srv.Options()
+	options.Compression = rubin.CompressionGzip
+	payload := strings.Repeat("Hello compressed world! ", 100)
+	_, err := rubin.NewClient(options).Produce(context.Background(), rubin.RecordRequest{Topic: "public.hello", Data: payload})
+	assert.NoError(t, err)
+	records := srv.Records("public.hello")
+	assert.Len(t, records, 1)
+	assert.Equal(t, payload, string(records[0].Value))
+}
+
+func TestServerFaults(t *testing.T) {
+	srv := NewServer(ServerOptions{})
+	defer srv.Close()
+	client := rubin.NewClient(srv.Options())
+	ctx := context.Background()
+	request := rubin.RecordRequest{Topic: "public.hello", Data: "Hello"}
+
+	srv.InjectFaults(append(Repeat(ErrorFault(http.StatusTooManyRequests), 2), ErrorFault(http.StatusServiceUnavailable),
+		UnauthorizedFault(), ForbiddenFault(), DropConnectionFault())...)
+	for _, expected := range []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		_, err := client.Produce(ctx, request)
+		var apiErr *rubin.APIError
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, expected, apiErr.StatusCode)
+		assert.True(t, rubin.IsRetriable(err))
+	}
+	_, err := client.Produce(ctx, request)
+	assert.ErrorContains(t, err, "<html>")
+	assert.False(t, rubin.IsRetriable(err))
+	_, err = client.Produce(ctx, request)
+	assert.ErrorContains(t, err, "40301")
+	_, err = client.Produce(ctx, request)
+	assert.Error(t, err)
+	assert.True(t, rubin.IsRetriable(err), "dropped connections are network errors")
+
+	_, err = client.Produce(ctx, request)
+	assert.NoError(t, err, "faults are consumed")
+	assert.Len(t, srv.Records("public.hello"), 1)
+	assert.Equal(t, 7, srv.Requests())
+}
+
+func TestServerLatency(t *testing.T) {
+	srv := NewServer(ServerOptions{})
+	defer srv.Close()
+	options := srv.Options()
+	options.HTTPTimeout = time.Second
+	client := rubin.NewClient(options)
+	srv.InjectFaults(LatencyFault(50 * time.Millisecond))
+	start := time.Now()
+	_, err := client.Produce(context.Background(), rubin.RecordRequest{Topic: "public.hello", Data: "slow"})
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	srv.SetLatency(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.Produce(ctx, rubin.RecordRequest{Topic: "public.hello", Data: "timeout"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestServerAuthAndTopics(t *testing.T) {
+	srv := NewServer(ServerOptions{Topics: []string{"public.hello"}, ClusterID: "abc-r2d2"})
+	defer srv.Close()
+	ctx := context.Background()
+
+	options := srv.Options()
+	options.ProducerAPISecret = "wrong"
+	_, err := rubin.NewClient(options).Produce(ctx, rubin.RecordRequest{Topic: "public.hello", Data: "Hello"})
+	var apiErr *rubin.APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+
+	client := rubin.NewClient(srv.Options())
+	_, err = client.Produce(ctx, rubin.RecordRequest{Topic: "public.unknown", Data: "Hello"})
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	partition := int32(1)
+	_, err = client.Produce(ctx, rubin.RecordRequest{Topic: "public.hello", Data: "Hello", Partition: &partition})
+	assert.ErrorContains(t, err, "404", "only a single partition by default")
+
+	topics, err := client.ListTopics(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, topics, 1)
+	topic, err := client.DescribeTopic(ctx, "public.hello")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), topic.PartitionsCount)
+	cluster, err := client.DescribeCluster(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc-r2d2", cluster.ClusterId)
+}
@@ -0,0 +1,85 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	o := Options{}
+	assert.False(t, o.IsCustom())
+	tc, err := o.Config()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), tc.MinVersion)
+	assert.Nil(t, tc.RootCAs)
+	assert.Empty(t, tc.Certificates)
+}
+
+func TestCustomConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedKeyPair(t, dir)
+	o := Options{CAFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "kafka.internal", InsecureSkipVerify: true}
+	assert.True(t, o.IsCustom())
+	assert.Contains(t, o.String(), "sni=kafka.internal")
+	tc, err := o.Config()
+	assert.NoError(t, err)
+	assert.NotNil(t, tc.RootCAs)
+	assert.Len(t, tc.Certificates, 1)
+	assert.Equal(t, "kafka.internal", tc.ServerName)
+	assert.True(t, tc.InsecureSkipVerify)
+}
+
+func TestInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, _ := writeSelfSignedKeyPair(t, dir)
+	_, err := Options{CAFile: filepath.Join(dir, "nope.pem")}.Config()
+	assert.ErrorContains(t, err, "cannot read ca file")
+
+	noPEM := filepath.Join(dir, "no.pem")
+	assert.NoError(t, os.WriteFile(noPEM, []byte("no pem here"), 0o600))
+	_, err = Options{CAFile: noPEM}.Config()
+	assert.ErrorContains(t, err, "no PEM encoded certificates")
+
+	_, err = Options{CertFile: certFile}.Config()
+	assert.ErrorContains(t, err, "must be set together")
+
+	_, err = Options{CertFile: certFile, KeyFile: noPEM}.Config()
+	assert.ErrorContains(t, err, "cannot load client key pair")
+}
+
+// writeSelfSignedKeyPair creates a self-signed certificate which can be used both as CA and client certificate
+func writeSelfSignedKeyPair(t *testing.T, dir string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rubin-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
@@ -0,0 +1,18 @@
+#!/usr/bin/env sh
+# test handler for polly -handler-mode coprocess demo
+# reads one JSON envelope per line from STDIN and writes one ack per line to STDOUT,
+# anything else should go to STDERR (or STDOUT lines that are no valid ack are logged by polly)
+# the message key controls the ack for testing: retry, fail or crash, everything else is ok
+me=$(basename "$0")
+while IFS= read -r line; do
+  id=$(echo "$line" | sed -E 's/^\{"id":([0-9]+).*/\1/')
+  key=$(echo "$line" | sed -E 's/.*"key":"([^"]*)".*/\1/')
+  echo "$me: received message id=$id key=$key" >&2
+  case "$key" in
+    retry) echo "{\"id\":$id,\"status\":\"retry\",\"reason\":\"try again later\"}" ;;
+    fail) echo "{\"id\":$id,\"status\":\"fail\",\"reason\":\"cannot process $key\"}" ;;
+    crash) exit 1 ;;
+    *) echo "{\"id\":$id,\"status\":\"ok\"}" ;;
+  esac
+done
+echo "$me: STDIN closed, shutting down" >&2
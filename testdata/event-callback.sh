@@ -1,14 +1,16 @@
 #!/usr/bin/env bash
 # test handler for polly callback demo
+# metadata is passed as POLLY_* env vars, exit 0 = ok, exit 75 = retry, other = dead-letter / skip
 sleepy=0 # simulate long processing
 
 me=$(basename "$0")
 # or if you only want to read from stdin into a variable
 if [ ! -t 0 ]; then
     echo "$me: Reading data from STDIN"  # cat cloud-event.json | ./script.sh
+    echo "$me: topic=${POLLY_TOPIC:-} offset=${POLLY_OFFSET:-} key=${POLLY_KEY:-} ce_type=${POLLY_CE_TYPE:-}"
     json=$(</dev/stdin)
     echo "$me: Received message: $json, processing for $sleepy seconds"
-    sleep $sleepy # test shutdown handling error="signal: killed"
+    sleep $sleepy # test shutdown handling, in-flight handlers are drained instead of "signal: killed"
     echo "$me: Processing finished after $sleepy seconds"
        if ! jq type --argjson data "$json" >/dev/null; then
         echo "$me: Message is not valid JSON, stop further processing"; exit 0